@@ -0,0 +1,339 @@
+// Package blockcache keeps ranges of remote files on disk in fixed-size
+// blocks. Blocks are fetched with parallel range requests ahead of where
+// readers are, shared between readers, and kept across restarts up to a size
+// limit, evicting the least recently used.
+package blockcache
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBlockSize = 2 << 20
+	// Blocks fetched ahead of a reader.
+	DefaultReadAhead = 8
+	// Concurrent background fetches across all files.
+	DefaultFetchers = 4
+)
+
+// File is a version of a remote file, and how to read it.
+type File struct {
+	// Identifies the file's contents. It must change when they do.
+	Key       string
+	Size      int64
+	ReadRange func(off, length int64) (io.ReadCloser, error)
+}
+
+// Cache stores blocks of files under a directory.
+type Cache struct {
+	dir       string
+	maxSize   int64
+	blockSize int64
+	readAhead int
+	fetchers  chan struct{}
+
+	mu sync.Mutex
+	// Stored blocks by file name, in a list from most to least recently used.
+	blocks   map[string]*list.Element
+	lru      *list.List
+	size     int64
+	inflight map[string]*fetch
+}
+
+type block struct {
+	name string
+	size int64
+}
+
+type fetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// New opens the cache in dir, keeping up to maxSize bytes of blocks. Blocks
+// stored by earlier runs are reused.
+func New(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:       dir,
+		maxSize:   maxSize,
+		blockSize: DefaultBlockSize,
+		readAhead: DefaultReadAhead,
+		fetchers:  make(chan struct{}, DefaultFetchers),
+		blocks:    make(map[string]*list.Element),
+		lru:       list.New(),
+		inflight:  make(map[string]*fetch),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type stored struct {
+		block
+		used time.Time
+	}
+	var found []stored
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), ".tmp-") {
+			os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+		found = append(found, stored{block{e.Name(), fi.Size()}, fi.ModTime()})
+	}
+	// Oldest first, so each is pushed in front of older ones.
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].used.Before(found[j].used)
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range found {
+		c.add(s.block)
+	}
+	c.evict()
+	return c, nil
+}
+
+// Returns the file name of a block.
+func blockName(f File, index int64) string {
+	sum := sha1.Sum([]byte(f.Key))
+	return hex.EncodeToString(sum[:]) + "-" + strconv.FormatInt(index, 10)
+}
+
+// Returns the size of a block, which is short at the end of the file.
+func (c *Cache) blockLen(f File, index int64) int64 {
+	n := f.Size - index*c.blockSize
+	if n > c.blockSize {
+		n = c.blockSize
+	}
+	return n
+}
+
+// Records a stored block as the most recently used. c.mu must be held.
+func (c *Cache) add(b block) {
+	if e, ok := c.blocks[b.name]; ok {
+		c.size -= e.Value.(block).size
+		c.lru.Remove(e)
+	}
+	c.blocks[b.name] = c.lru.PushFront(b)
+	c.size += b.size
+}
+
+// Removes the least recently used blocks until under the size limit. c.mu
+// must be held.
+func (c *Cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() != 0 {
+		b := c.lru.Remove(c.lru.Back()).(block)
+		delete(c.blocks, b.name)
+		c.size -= b.size
+		os.Remove(filepath.Join(c.dir, b.name))
+	}
+}
+
+// Size returns the total size of stored blocks.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Returns a stored block, marking it used.
+func (c *Cache) stored(name string, size int64) ([]byte, bool) {
+	c.mu.Lock()
+	e, ok := c.blocks[name]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	p := filepath.Join(c.dir, name)
+	data, err := os.ReadFile(p)
+	if err != nil || int64(len(data)) != size {
+		c.mu.Lock()
+		if e, ok := c.blocks[name]; ok {
+			c.lru.Remove(e)
+			delete(c.blocks, name)
+			c.size -= e.Value.(block).size
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	// Keeps the order of use across restarts.
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return data, true
+}
+
+// Returns the block, fetching it if necessary. Concurrent requests for a
+// block share one fetch. A shared fetch that fails is tried again, as it may
+// have failed for reasons of the reader it was for, like its request going
+// away.
+func (c *Cache) block(f File, index int64) ([]byte, error) {
+	name := blockName(f, index)
+	size := c.blockLen(f, index)
+	if data, ok := c.stored(name, size); ok {
+		return data, nil
+	}
+	data, shared, err := c.shared(f, index, name, size)
+	if err != nil && shared {
+		data, _, err = c.shared(f, index, name, size)
+	}
+	return data, err
+}
+
+// Fetches the block, or waits for the fetch of it already being made, which
+// is reported as shared.
+func (c *Cache) shared(f File, index int64, name string, size int64) ([]byte, bool, error) {
+	c.mu.Lock()
+	if ft, ok := c.inflight[name]; ok {
+		c.mu.Unlock()
+		<-ft.done
+		return ft.data, true, ft.err
+	}
+	ft := &fetch{done: make(chan struct{})}
+	c.inflight[name] = ft
+	c.mu.Unlock()
+	ft.data, ft.err = c.fetch(f, index, name, size)
+	c.mu.Lock()
+	delete(c.inflight, name)
+	c.mu.Unlock()
+	close(ft.done)
+	return ft.data, false, ft.err
+}
+
+func (c *Cache) fetch(f File, index int64, name string, size int64) ([]byte, error) {
+	rc, err := f.ReadRange(index*c.blockSize, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(rc, data); err != nil {
+		return nil, fmt.Errorf("reading block %d: %w", index, err)
+	}
+	if err := c.store(name, data); err == nil {
+		c.mu.Lock()
+		c.add(block{name, size})
+		c.evict()
+		c.mu.Unlock()
+	}
+	return data, nil
+}
+
+// Writes a block atomically.
+func (c *Cache) store(name string, data []byte) error {
+	f, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Fetches the blocks after index in the background, if they aren't stored
+// or being fetched already.
+func (c *Cache) prefetch(f File, index int64) {
+	for i := index + 1; i <= index+int64(c.readAhead) && i*c.blockSize < f.Size; i++ {
+		name := blockName(f, i)
+		c.mu.Lock()
+		_, stored := c.blocks[name]
+		_, fetching := c.inflight[name]
+		c.mu.Unlock()
+		if stored || fetching {
+			continue
+		}
+		select {
+		case c.fetchers <- struct{}{}:
+		default:
+			// Every fetcher is busy. The next read will try again.
+			return
+		}
+		go func(i int64) {
+			defer func() { <-c.fetchers }()
+			c.block(f, i)
+		}(i)
+	}
+}
+
+// Open returns a reader of the file through the cache.
+func (c *Cache) Open(f File) *Reader {
+	return &Reader{c: c, f: f, index: -1}
+}
+
+// Reader reads a file through the cache. It isn't safe for concurrent use.
+type Reader struct {
+	c   *Cache
+	f   File
+	off int64
+	// The block last read, kept for reads within it.
+	index int64
+	data  []byte
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.off >= r.f.Size {
+		return 0, io.EOF
+	}
+	index := r.off / r.c.blockSize
+	if index != r.index {
+		data, err := r.c.block(r.f, index)
+		if err != nil {
+			return 0, err
+		}
+		r.index, r.data = index, data
+		r.c.prefetch(r.f, index)
+	}
+	n := copy(p, r.data[r.off-index*r.c.blockSize:])
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.f.Size
+	}
+	if offset < 0 {
+		return r.off, errors.New("negative seek position")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// Close releases the block held by the reader.
+func (r *Reader) Close() error {
+	r.data = nil
+	r.index = -1
+	return nil
+}
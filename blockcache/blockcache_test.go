@@ -0,0 +1,171 @@
+package blockcache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A remote file that counts the range requests made of it.
+type source struct {
+	data     []byte
+	mu       sync.Mutex
+	requests map[int64]int
+}
+
+func (s *source) file(key string) File {
+	return File{
+		Key:  key,
+		Size: int64(len(s.data)),
+		ReadRange: func(off, length int64) (io.ReadCloser, error) {
+			s.mu.Lock()
+			s.requests[off]++
+			s.mu.Unlock()
+			return io.NopCloser(bytes.NewReader(s.data[off : off+length])), nil
+		},
+	}
+}
+
+func (s *source) count() (n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.requests {
+		n += c
+	}
+	return
+}
+
+func newSource(size int) *source {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return &source{data: data, requests: make(map[int64]int)}
+}
+
+func newTestCache(t *testing.T, dir string, maxSize int64) *Cache {
+	c, err := New(dir, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.blockSize = 100
+	c.readAhead = 2
+	return c
+}
+
+func TestReadThrough(t *testing.T) {
+	dir := t.TempDir()
+	src := newSource(1050)
+	c := newTestCache(t, dir, 1<<20)
+	r := c.Open(src.file("a"))
+	if _, err := r.Seek(250, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, src.data[250:]) {
+		t.Fatal(len(got), err)
+	}
+	// Blocks fetched ahead are shared with later readers, and every block
+	// was fetched only once.
+	waitFetches(c)
+	for off, n := range src.requests {
+		if n != 1 || off%100 != 0 {
+			t.Fatal(src.requests)
+		}
+	}
+	before := src.count()
+	got, _ = io.ReadAll(c.Open(src.file("a")))
+	if !bytes.Equal(got, src.data) || src.count() != before+2 {
+		t.Fatal(src.requests)
+	}
+
+	// Blocks survive a restart.
+	waitFetches(c)
+	before = src.count()
+	c = newTestCache(t, dir, 1<<20)
+	if got, _ = io.ReadAll(c.Open(src.file("a"))); !bytes.Equal(got, src.data) || src.count() != before {
+		t.Fatal(src.requests)
+	}
+	if c.Size() != 1050 {
+		t.Fatal(c.Size())
+	}
+}
+
+func TestEviction(t *testing.T) {
+	src := newSource(1000)
+	c := newTestCache(t, t.TempDir(), 350)
+	c.readAhead = 0
+	r := c.Open(src.file("a"))
+	buf := make([]byte, 1)
+	for _, off := range []int64{0, 100, 200, 300, 0} {
+		r.Seek(off, io.SeekStart)
+		r.Read(buf)
+	}
+	if c.Size() != 300 {
+		t.Fatal(c.Size())
+	}
+	// Block 0 was used most recently, so block 1 went first.
+	before := src.count()
+	for _, off := range []int64{0, 200, 300} {
+		r.Seek(off, io.SeekStart)
+		r.Read(buf)
+	}
+	if src.count() != before {
+		t.Fatal(src.requests)
+	}
+	r.Seek(100, io.SeekStart)
+	r.Read(buf)
+	if src.count() != before+1 {
+		t.Fatal(src.requests)
+	}
+}
+
+func TestSharedFetchFailure(t *testing.T) {
+	src := newSource(100)
+	c := newTestCache(t, t.TempDir(), 1<<20)
+	c.readAhead = 0
+	// The first reader's fetch fails after the second has come to share it,
+	// as it would if the first reader's request went away.
+	entered, fail := make(chan struct{}), make(chan struct{})
+	first := src.file("a")
+	first.ReadRange = func(off, length int64) (io.ReadCloser, error) {
+		close(entered)
+		<-fail
+		return nil, errors.New("gone")
+	}
+	firstDone := make(chan error)
+	go func() {
+		_, err := c.Open(first).Read(make([]byte, 1))
+		firstDone <- err
+	}()
+	<-entered
+	secondDone := make(chan error)
+	go func() {
+		_, err := c.Open(src.file("a")).Read(make([]byte, 1))
+		secondDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(fail)
+	if err := <-firstDone; err == nil {
+		t.Fatal("fetch didn't fail")
+	}
+	if err := <-secondDone; err != nil || src.count() != 1 {
+		t.Fatal(err, src.requests)
+	}
+}
+
+// Waits for background fetches to finish.
+func waitFetches(c *Cache) {
+	for i := 0; i < 100; i++ {
+		c.mu.Lock()
+		n := len(c.inflight)
+		c.mu.Unlock()
+		if n == 0 && len(c.fetchers) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,6 +2,7 @@ package dlna
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -45,19 +46,41 @@ func (cf ContentFeatures) String() (ret string) {
 	return strings.Join(params, ";")
 }
 
+// ParseNPTTime parses an npt-time, either as seconds ("12.345") or in
+// hours, minutes and seconds ("0:00:12.345"). Fractions are optional.
 func ParseNPTTime(s string) (time.Duration, error) {
-	var h, m, sec, ms time.Duration
-	n, err := fmt.Sscanf(s, "%d:%2d:%2d.%3d", &h, &m, &sec, &ms)
-	if err != nil {
-		return -1, err
+	invalid := fmt.Errorf("invalid npt time: %s", s)
+	var h, m, sec int64
+	whole, frac, _ := strings.Cut(s, ".")
+	parts := strings.Split(whole, ":")
+	var err error
+	switch len(parts) {
+	case 1:
+		if sec, err = strconv.ParseInt(parts[0], 10, 64); err != nil || sec < 0 {
+			return -1, invalid
+		}
+	case 3:
+		if h, err = strconv.ParseInt(parts[0], 10, 64); err != nil || h < 0 {
+			return -1, invalid
+		}
+		for i, v := range []*int64{&m, &sec} {
+			if len(parts[i+1]) != 2 {
+				return -1, invalid
+			}
+			if *v, err = strconv.ParseInt(parts[i+1], 10, 64); err != nil || *v < 0 || *v > 59 {
+				return -1, invalid
+			}
+		}
+	default:
+		return -1, invalid
 	}
-	if n < 3 {
-		return -1, fmt.Errorf("invalid npt time: %s", s)
+	ret := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+	for i, unit := 0, 100*time.Millisecond; i < len(frac); i, unit = i+1, unit/10 {
+		if frac[i] < '0' || frac[i] > '9' {
+			return -1, invalid
+		}
+		ret += time.Duration(frac[i]-'0') * unit
 	}
-	ret := time.Duration(h) * time.Hour
-	ret += time.Duration(m) * time.Minute
-	ret += sec * time.Second
-	ret += ms * time.Millisecond
 	return ret, nil
 }
 
@@ -79,6 +102,10 @@ type NPTRange struct {
 
 func ParseNPTRange(s string) (ret NPTRange, err error) {
 	ss := strings.SplitN(s, "-", 2)
+	if len(ss) != 2 {
+		err = fmt.Errorf("invalid npt range: %s", s)
+		return
+	}
 	if ss[0] != "" {
 		ret.Start, err = ParseNPTTime(ss[0])
 		if err != nil {
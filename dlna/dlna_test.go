@@ -2,6 +2,7 @@ package dlna
 
 import (
 	"testing"
+	"time"
 )
 
 func TestContentFeaturesString(t *testing.T) {
@@ -14,3 +15,32 @@ func TestContentFeaturesString(t *testing.T) {
 		t.Fatal(a)
 	}
 }
+
+func TestParseNPTTime(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"0":            0,
+		"12.5":         12500 * time.Millisecond,
+		"0:00:12":      12 * time.Second,
+		"01:02:03.456": time.Hour + 2*time.Minute + 3456*time.Millisecond,
+		"10:00:00.1":   10*time.Hour + 100*time.Millisecond,
+	} {
+		if got, err := ParseNPTTime(s); err != nil || got != want {
+			t.Errorf("ParseNPTTime(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "1:2:3", "0:60:00", "00:00", "1.x", "-1"} {
+		if _, err := ParseNPTTime(s); err == nil {
+			t.Errorf("ParseNPTTime(%q) succeeded", s)
+		}
+	}
+}
+
+func TestParseNPTRange(t *testing.T) {
+	r, err := ParseNPTRange("0:01:00-0:02:00.5")
+	if err != nil || r.Start != time.Minute || r.End != 2*time.Minute+500*time.Millisecond {
+		t.Fatal(r, err)
+	}
+	if r, err := ParseNPTRange("30-"); err != nil || r.Start != 30*time.Second || r.End != 0 {
+		t.Fatal(r, err)
+	}
+}
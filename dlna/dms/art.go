@@ -0,0 +1,213 @@
+package dms
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofly/alipan-dms/dlna"
+	"github.com/gofly/alipan-dms/id3"
+	"github.com/gofly/alipan-dms/mp4"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+const (
+	artPath = "/art"
+	// Largest ID3 tag or MP4 cover that will be read looking for embedded art.
+	maxArtTagSize = 8 << 20
+)
+
+// Images that provide the art for the folder containing them, in order of
+// preference. Matched case-insensitively.
+var folderArtNames = []string{
+	"cover.jpg", "cover.jpeg", "cover.png",
+	"folder.jpg", "folder.jpeg", "folder.png",
+	"front.jpg", "front.jpeg", "front.png",
+	"albumart.jpg", "album.jpg",
+	"poster.jpg", "poster.jpeg", "poster.png",
+}
+
+// Suffixes to a video's base name that give its sidecar images, in order of
+// preference. Matched case-insensitively.
+var sidecarArtSuffixes = []string{
+	"-poster.jpg", "-poster.png",
+	".jpg", ".jpeg", ".png",
+	"-thumb.jpg", "-thumb.png",
+}
+
+var errNoArt = errors.New("no art")
+
+func folderArt(dl *dirListing, dir string) (string, os.FileInfo) {
+	return dl.find(dir, folderArtNames)
+}
+
+// Returns the sidecar image for the video at filePath.
+func sidecarArt(dl *dirListing, filePath string) (string, os.FileInfo) {
+	base := strings.ToLower(path.Base(filePath))
+	base = strings.TrimSuffix(base, path.Ext(base))
+	names := make([]string, 0, len(sidecarArtSuffixes))
+	for _, suffix := range sidecarArtSuffixes {
+		names = append(names, base+suffix)
+	}
+	return dl.find(path.Dir(filePath), names)
+}
+
+// Picks the art for objects from sidecar and folder images, and pictures
+// embedded in the files.
+type artResolver struct {
+	backend    Backend
+	listings   *listingCache
+	thumbnails *thumbnailService
+	prober     *prober
+	// Opens images to make the art from.
+	openImage func(filePath string, fi os.FileInfo) io.ReadCloser
+}
+
+// Returns whether dir was recently found to have no folder art.
+func (ar *artResolver) knownMissing(dir string) bool {
+	dl, ok := ar.listings.cached(dir)
+	if !ok {
+		return false
+	}
+	p, _ := folderArt(dl, dir)
+	return p == ""
+}
+
+// Returns whether a video is known to have art, from its directory listing
+// and probed metadata.
+func (ar *artResolver) hasVideoArt(filePath string, mi mediaInfo) bool {
+	if mi.HasCover {
+		return true
+	}
+	dl, ok := ar.listings.cached(path.Dir(filePath))
+	if !ok {
+		return false
+	}
+	if p, _ := sidecarArt(dl, filePath); p != "" {
+		return true
+	}
+	p, _ := folderArt(dl, path.Dir(filePath))
+	return p != ""
+}
+
+func (ar *artResolver) embeddedArt(filePath string, fi os.FileInfo) (io.ReadCloser, error) {
+	if strings.EqualFold(path.Ext(filePath), ".mp3") {
+		rc, err := ar.backend.ReadStreamRange(filePath, 0, maxArtTagSize)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		pic, err := id3.ReadPicture(rc, maxArtTagSize)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(pic.Data)), nil
+	}
+	data, _, err := mp4.CoverArt(newBackendReaderAt(ar.backend, filePath, fi.Size()), fi.Size(), maxArtTagSize)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Returns the JPEG_TN thumbnail of the image file.
+func (ar *artResolver) imageFile(p string, fi os.FileInfo) ([]byte, time.Time, error) {
+	data, err := ar.thumbnails.get(fileKey(p, fi), jpegTN, func() (io.ReadCloser, error) {
+		return ar.openImage(p, fi), nil
+	})
+	if data != nil {
+		err = nil
+	}
+	return data, fi.ModTime(), err
+}
+
+// Returns the art for the file or folder at filePath as a JPEG_TN image.
+func (ar *artResolver) image(filePath string) ([]byte, time.Time, error) {
+	fi, err := ar.backend.Stat(filePath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if fi.IsDir() {
+		dl, err := ar.listings.get(filePath)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if p, imageFi := folderArt(dl, filePath); p != "" {
+			return ar.imageFile(p, imageFi)
+		}
+		return nil, time.Time{}, errNoArt
+	}
+	dir := path.Dir(filePath)
+	dl, err := ar.listings.get(dir)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if mimeTypeByBaseName(fi.Name()).IsVideo() {
+		if p, imageFi := sidecarArt(dl, filePath); p != "" {
+			return ar.imageFile(p, imageFi)
+		}
+	}
+	// Only files found to carry a picture are read for it.
+	if mi, _ := ar.prober.probe(probeFile{filePath, fi, mimeTypeByBaseName(fi.Name())}); mi.HasCover {
+		data, err := ar.thumbnails.get(fileKey(filePath, fi), jpegTN, func() (io.ReadCloser, error) {
+			return ar.embeddedArt(filePath, fi)
+		})
+		if err == nil {
+			return data, fi.ModTime(), nil
+		}
+	}
+	if p, imageFi := folderArt(dl, dir); p != "" {
+		return ar.imageFile(p, imageFi)
+	}
+	return nil, time.Time{}, errNoArt
+}
+
+func (s *Server) artURL(o object, host string) string {
+	return (&url.URL{
+		Scheme:   "http",
+		Host:     host,
+		Path:     artPath,
+		RawQuery: url.Values{"path": {o.Path}}.Encode(),
+	}).String()
+}
+
+// Returns the albumArtURI served for the object.
+func (s *Server) albumArtURI(o object, host string) *upnpav.AlbumArtURI {
+	return &upnpav.AlbumArtURI{
+		ProfileID: jpegTN.Name,
+		URI:       s.artURL(o, host),
+	}
+}
+
+// Sets the album art of a video item, and offers it as a JPEG_TN resource.
+func (s *Server) addVideoArt(item *upnpav.Item, o object, host string) {
+	item.AlbumArtURI = s.albumArtURI(o, host)
+	item.Res = append(item.Res, upnpav.Resource{
+		URL:          s.artURL(o, host),
+		ProtocolInfo: jpegTN.protocolInfo(),
+	})
+}
+
+func (s *Server) serveArt(w http.ResponseWriter, r *http.Request) {
+	o := object{path.Clean("/" + r.URL.Query().Get("path")), s.RootObjectPath}
+	data, modTime, err := s.art.image(o.FilePath())
+	if err == errNoArt {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.Logger.Printf("error serving art for %s: %s", o.Path, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set(dlna.ContentFeaturesDomain, dlna.ContentFeatures{ProfileName: jpegTN.Name}.String())
+	w.Header().Set(dlna.TransferModeDomain, "Interactive")
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+}
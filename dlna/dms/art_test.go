@@ -0,0 +1,182 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func testPNG(w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.Black)
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func testMP3WithArt(pic []byte) []byte {
+	frame := append([]byte("\x00image/png\x00\x03\x00"), pic...)
+	n := len(frame)
+	size := n + 10
+	b := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	b = append(b, 'A', 'P', 'I', 'C', byte(n>>24), byte(n>>16), byte(n>>8), byte(n), 0, 0)
+	b = append(b, frame...)
+	return append(b, 0xff, 0xfb)
+}
+
+func getArt(t *testing.T, s *Server, p string) (int, image.Image) {
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", artPath+"?path="+p, nil))
+	if rec.Code != 200 {
+		return rec.Code, nil
+	}
+	img, err := jpeg.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, img
+}
+
+func TestServeArt(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Music/Album/cover.png":   testPNG(400, 200),
+		"/Music/Album/01.flac":     []byte("fLaC"),
+		"/Music/Single/track.mp3":  testMP3WithArt(testPNG(20, 20)),
+		"/Music/Nothing/track.ogg": []byte("OggS"),
+	})
+	code, img := getArt(t, s, "/Music/Album")
+	if code != 200 || img.Bounds().Dx() != 160 || img.Bounds().Dy() != 80 {
+		t.Fatalf("folder art: %d %v", code, img)
+	}
+	code, img = getArt(t, s, "/Music/Album/01.flac")
+	if code != 200 || img.Bounds().Dx() != 160 {
+		t.Fatalf("item folder art: %d", code)
+	}
+	code, img = getArt(t, s, "/Music/Single/track.mp3")
+	if code != 200 || img.Bounds().Dx() != 20 {
+		t.Fatalf("embedded art: %d", code)
+	}
+	if code, _ = getArt(t, s, "/Music/Nothing/track.ogg"); code != 404 {
+		t.Fatalf("missing art: %d", code)
+	}
+}
+
+func TestAlbumArtURIInListing(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Music/Album/cover.jpg": testPNG(1, 1),
+		"/Music/Album/01.ogg":    []byte("OggS"),
+		"/Music/Bare/01.ogg":     []byte("OggS"),
+		"/Music/Bare/02.mp3":     testMP3WithArt(testPNG(1, 1)),
+		"/Music/Bare/03.mp3":     []byte("ID3\x03\x00\x00\x00\x00\x00\x00\xff\xfb"),
+	})
+	objs, err := s.services["ContentDirectory"].(*contentDirectoryService).readContainer(object{"/Music/Album", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := objs[0].(upnpav.Item)
+	if item.AlbumArtURI == nil || item.AlbumArtURI.ProfileID != "JPEG_TN" {
+		t.Fatalf("%#v", item.AlbumArtURI)
+	}
+	b, _ := xml.Marshal(item)
+	if !bytes.Contains(b, []byte(`<upnp:albumArtURI dlna:profileID="JPEG_TN">http://host/art?path=%2FMusic%2FAlbum%2F01.ogg</upnp:albumArtURI>`)) {
+		t.Fatal(string(b))
+	}
+	objs, err = s.services["ContentDirectory"].(*contentDirectoryService).readContainer(object{"/Music/Bare", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri := objs[0].(upnpav.Item).AlbumArtURI; uri != nil {
+		t.Fatalf("unexpected art %v", uri)
+	}
+	// Only files found to carry a picture get one from it.
+	if uri := objs[1].(upnpav.Item).AlbumArtURI; uri == nil {
+		t.Fatal("missing embedded art")
+	}
+	if uri := objs[2].(upnpav.Item).AlbumArtURI; uri != nil {
+		t.Fatalf("unexpected art %v", uri)
+	}
+	if code, _ := getArt(t, s, "/Music/Bare/03.mp3"); code != 404 {
+		t.Fatalf("missing art: %d", code)
+	}
+}
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := []byte{byte((8 + len(body)) >> 24), byte((8 + len(body)) >> 16), byte((8 + len(body)) >> 8), byte(8 + len(body))}
+	return append(append(b, typ...), body...)
+}
+
+func testMP4WithCover(pic []byte) []byte {
+	return bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("isom")),
+		mp4Box("moov", mp4Box("udta", mp4Box("meta", []byte{0, 0, 0, 0},
+			mp4Box("hdlr", make([]byte, 25)),
+			mp4Box("ilst", mp4Box("covr", mp4Box("data", []byte{0, 0, 0, 14, 0, 0, 0, 0}, pic)))))),
+		mp4Box("mdat", make([]byte, 64)),
+	}, nil)
+}
+
+func TestVideoArt(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Movies/a.mkv":            []byte("matroska"),
+		"/Movies/a-poster.jpg":     testPNG(300, 450),
+		"/Movies/b.mp4":            testMP4WithCover(testPNG(40, 60)),
+		"/Movies/c.mkv":            []byte("matroska"),
+		"/Movies/Sub/d.avi":        []byte("RIFF"),
+		"/Movies/Sub/poster.png":   testPNG(10, 10),
+		"/Movies/Other/e.mkv":      []byte("matroska"),
+		"/Movies/Other/readme.txt": []byte("hi"),
+	})
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	objs, err := cds.readContainer(object{"/Movies", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTitle := make(map[string]upnpav.Item)
+	for _, obj := range objs {
+		if item, ok := obj.(upnpav.Item); ok {
+			byTitle[item.Title] = item
+		}
+	}
+	for title, hasArt := range map[string]bool{"a": true, "b": true, "c": false} {
+		item := byTitle[title]
+		if (item.AlbumArtURI != nil) != hasArt {
+			t.Errorf("%s: art %v", title, item.AlbumArtURI)
+		}
+		if hasArt && item.Res[len(item.Res)-1].URL != item.AlbumArtURI.URI {
+			t.Errorf("%s: no JPEG_TN res", title)
+		}
+	}
+	for p, width := range map[string]int{"/Movies/a.mkv": 106, "/Movies/b.mp4": 40, "/Movies/Sub/d.avi": 10} {
+		code, img := getArt(t, s, p)
+		if code != 200 || img.Bounds().Dx() != width {
+			t.Errorf("%s: %d", p, code)
+		}
+	}
+	if code, _ := getArt(t, s, "/Movies/Other/e.mkv"); code != 404 {
+		t.Errorf("e.mkv: %d", code)
+	}
+}
+
+func TestBackendReaderAtEOF(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), readerAtBlockSize/5)
+	ra := newBackendReaderAt(memBackend{"/a.mp4": data}, "/a.mp4", int64(len(data)))
+	for _, size := range []int{16, readerAtBlockSize + 16} {
+		p := make([]byte, size)
+		n, err := ra.ReadAt(p, int64(len(data)-10))
+		if n != 10 || err != io.EOF || string(p[:n]) != "0123456789" {
+			t.Errorf("%d: %d, %v", size, n, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package dms
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// Backend is the file store served by the DMS. *gowebdav.Client satisfies it.
+type Backend interface {
+	ReadDir(path string) ([]os.FileInfo, error)
+	Stat(path string) (os.FileInfo, error)
+	ReadStream(path string) (io.ReadCloser, error)
+	ReadStreamRange(path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Size of the blocks a backendReaderAt fetches.
+const readerAtBlockSize = 64 << 10
+
+// Adapts range reads of a backend file to io.ReaderAt. Reads are rounded out
+// to blocks and the most recent blocks are kept, since parsers tend to read
+// many small headers close together. Not safe for concurrent use.
+type backendReaderAt struct {
+	backend Backend
+	path    string
+	size    int64
+	blocks  [4]readerAtBlock
+	next    int
+}
+
+type readerAtBlock struct {
+	off  int64
+	data []byte
+}
+
+func newBackendReaderAt(backend Backend, path string, size int64) *backendReaderAt {
+	ra := &backendReaderAt{backend: backend, path: path, size: size}
+	for i := range ra.blocks {
+		ra.blocks[i].off = -1
+	}
+	return ra
+}
+
+func (ra *backendReaderAt) block(off int64) ([]byte, error) {
+	for _, b := range ra.blocks {
+		if b.off == off {
+			return b.data, nil
+		}
+	}
+	n := int64(readerAtBlockSize)
+	if off+n > ra.size {
+		n = ra.size - off
+	}
+	rc, err := ra.backend.ReadStreamRange(ra.path, off, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data := make([]byte, n)
+	if _, err := io.ReadFull(rc, data); err != nil {
+		return nil, err
+	}
+	ra.blocks[ra.next] = readerAtBlock{off, data}
+	ra.next = (ra.next + 1) % len(ra.blocks)
+	return data, nil
+}
+
+// Reads larger than a block go straight to the backend.
+func (ra *backendReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off >= ra.size {
+		return 0, io.EOF
+	}
+	truncated := off+int64(len(p)) > ra.size
+	if truncated {
+		p = p[:ra.size-off]
+	}
+	if len(p) > readerAtBlockSize {
+		rc, err := ra.backend.ReadStreamRange(ra.path, off, int64(len(p)))
+		if err != nil {
+			return 0, err
+		}
+		defer rc.Close()
+		n, err = io.ReadFull(rc, p)
+		if err == nil && truncated {
+			err = io.EOF
+		}
+		return n, err
+	}
+	for n < len(p) {
+		blockOff := (off + int64(n)) / readerAtBlockSize * readerAtBlockSize
+		data, blockErr := ra.block(blockOff)
+		if blockErr != nil {
+			return n, blockErr
+		}
+		n += copy(p[n:], data[off+int64(n)-blockOff:])
+	}
+	if truncated {
+		err = io.EOF
+	}
+	return
+}
+
+// Streams a backend file as an io.ReadSeeker, for http.ServeContent. The
+// ranged read is opened lazily, and reopened after seeking.
+type backendReadSeeker struct {
+	backend Backend
+	path    string
+	size    int64
+	off     int64
+	rc      io.ReadCloser
+}
+
+func newBackendReadSeeker(backend Backend, path string, size int64) *backendReadSeeker {
+	return &backendReadSeeker{backend: backend, path: path, size: size}
+}
+
+func (rs *backendReadSeeker) Read(p []byte) (n int, err error) {
+	if rs.off >= rs.size {
+		return 0, io.EOF
+	}
+	if rs.rc == nil {
+		rs.rc, err = rs.backend.ReadStreamRange(rs.path, rs.off, rs.size-rs.off)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = rs.rc.Read(p)
+	rs.off += int64(n)
+	return
+}
+
+func (rs *backendReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += rs.off
+	case io.SeekEnd:
+		offset += rs.size
+	}
+	if offset < 0 {
+		return rs.off, errors.New("negative seek position")
+	}
+	if offset != rs.off {
+		rs.Close()
+		rs.off = offset
+	}
+	return offset, nil
+}
+
+func (rs *backendReadSeeker) Close() error {
+	if rs.rc == nil {
+		return nil
+	}
+	err := rs.rc.Close()
+	rs.rc = nil
+	return err
+}
@@ -0,0 +1,101 @@
+package dms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// The Samsung feature list, pointing the TV at the containers of all the
+// audio, video and image items.
+const samsungFeatureList = `<?xml version="1.0" encoding="UTF-8"?>
+<Features xmlns="urn:schemas-upnp-org:av:avs" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="urn:schemas-upnp-org:av:avs http://www.upnp.org/schemas/av/avs.xsd">
+<Feature name="samsung.com_BASICVIEW" version="1">
+<container id="4" type="object.item.audioItem"/>
+<container id="8" type="object.item.videoItem"/>
+<container id="B" type="object.item.imageItem"/>
+</Feature>
+</Features>`
+
+// Arguments of X_SetBookmark used. The CategoryType and RID it also gives
+// don't matter, as objects are bookmarked by their IDs.
+type setBookmark struct {
+	ObjectID  string
+	PosSecond int
+}
+
+// Positions, in seconds, that renderers stopped playing objects at, keyed by
+// object path.
+type bookmarkStore struct {
+	// Where the bookmarks are saved. They're only kept in memory if empty.
+	file  string
+	mu    sync.Mutex
+	marks map[string]int
+}
+
+// Loads the bookmarks saved in file, if there are any.
+func loadBookmarks(file string) (*bookmarkStore, error) {
+	bs := &bookmarkStore{file: file, marks: make(map[string]int)}
+	if file == "" {
+		return bs, nil
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return bs, nil
+	}
+	if err != nil {
+		return bs, err
+	}
+	if err := json.Unmarshal(data, &bs.marks); err != nil {
+		return bs, fmt.Errorf("reading %s: %w", file, err)
+	}
+	return bs, nil
+}
+
+func (bs *bookmarkStore) get(objPath string) (int, bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	pos, ok := bs.marks[objPath]
+	return pos, ok
+}
+
+// Bookmarks the position in seconds, where zero removes the bookmark, and
+// saves the bookmarks.
+func (bs *bookmarkStore) set(objPath string, pos int) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if pos > 0 {
+		bs.marks[objPath] = pos
+	} else {
+		delete(bs.marks, objPath)
+	}
+	if bs.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(bs.marks)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(bs.file), 0750); err != nil {
+		return err
+	}
+	tmp := bs.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, bs.file)
+}
+
+// Returns the Samsung dcmInfo of a video, giving the position to resume it
+// from, or empty if it's not bookmarked.
+func (s *Server) dcmInfo(o object, fi os.FileInfo) string {
+	pos, ok := s.bookmarks.get(o.Path)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("CREATIONDATE=%d,FOLDER=%s,BM=%d", fi.ModTime().Unix(), path.Base(path.Dir(o.Path)), pos)
+}
@@ -0,0 +1,59 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func TestBookmarks(t *testing.T) {
+	s := newTestServer(memBackend{"/TV/Show.mkv": nil})
+	file := filepath.Join(t.TempDir(), "state", "bookmarks.json")
+	var err error
+	if s.bookmarks, err = loadBookmarks(file); err != nil {
+		t.Fatal(err)
+	}
+	cds := s.services["ContentDirectory"]
+	r := httptest.NewRequest("POST", "/ctl", nil)
+	r.Header.Set("User-Agent", "SEC_HHP_[TV] Samsung")
+	for _, action := range []string{"X_GetFeatureList", "GetFeatureList"} {
+		resp, err := cds.Handle(action, nil, r)
+		if err != nil || !strings.Contains(resp[0][1], `<container id="8" type="object.item.videoItem"/>`) {
+			t.Fatal(action, resp, err)
+		}
+	}
+	setBookmark := func(pos string) {
+		args := `<u:X_SetBookmark><CategoryType>VIDEO</CategoryType><RID>0</RID><ObjectID>%2FTV%2FShow.mkv</ObjectID><PosSecond>` + pos + `</PosSecond></u:X_SetBookmark>`
+		if _, err := cds.Handle("X_SetBookmark", []byte(args), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dcmInfo := func() []byte {
+		objs, err := s.services["ContentDirectory"].(*contentDirectoryService).readContainer(object{"/TV", "/"}, "host", genericRenderer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := xml.Marshal(objs[0].(upnpav.Item))
+		return b
+	}
+	setBookmark("1234")
+	if b := dcmInfo(); !bytes.Contains(b, []byte("<sec:dcmInfo>CREATIONDATE=1500000000,FOLDER=TV,BM=1234</sec:dcmInfo>")) {
+		t.Fatal(string(b))
+	}
+	// The bookmark is kept.
+	if s.bookmarks, err = loadBookmarks(file); err != nil {
+		t.Fatal(err)
+	}
+	if pos, ok := s.bookmarks.get("/TV/Show.mkv"); !ok || pos != 1234 {
+		t.Fatal(pos, ok)
+	}
+	setBookmark("0")
+	if b := dcmInfo(); bytes.Contains(b, []byte("dcmInfo")) {
+		t.Fatal(string(b))
+	}
+}
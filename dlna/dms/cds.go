@@ -8,9 +8,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
 
 	"github.com/anacrolix/log"
 	"github.com/gofly/alipan-dms/dlna"
+	"github.com/gofly/alipan-dms/playlist"
+	"github.com/gofly/alipan-dms/series"
 	"github.com/gofly/alipan-dms/upnp"
 	"github.com/gofly/alipan-dms/upnpav"
 )
@@ -23,6 +26,15 @@ type browse struct {
 	RequestedCount int
 }
 
+type search struct {
+	ContainerID    string
+	SearchCriteria string
+	Filter         string
+	StartingIndex  int
+	RequestedCount int
+	SortCriteria   string
+}
+
 type contentDirectoryService struct {
 	*Server
 	upnp.Eventing
@@ -52,12 +64,12 @@ func (s *contentDirectoryService) updateIDString() string {
 
 // Turns the given entry and DMS host into a UPnP object. A nil object is
 // returned if the entry is not of interest.
-func (s *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, fileInfo os.FileInfo, host, userAgent string) (ret interface{}, err error) {
+func (s *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, fileInfo os.FileInfo, host string, rp *rendererProfile) (ret interface{}, err error) {
 	entryFilePath := cdsObject.FilePath()
-	// ignored, err := s.IgnorePath(entryFilePath)
-	// if err != nil || ignored {
-	// 	return
-	// }
+	ignored, err := s.IgnorePath(entryFilePath, fileInfo)
+	if err != nil || ignored {
+		return
+	}
 
 	obj := upnpav.Object{
 		ID:         cdsObject.ID(),
@@ -67,14 +79,35 @@ func (s *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, file
 	}
 	if fileInfo.IsDir() {
 		obj.Class = "object.container.storageFolder"
-		obj.Title = fileInfo.Name()
-		ret = upnpav.Container{Object: obj, ChildCount: 0}
+		title, _ := s.objectTitle(cdsObject, fileInfo)
+		obj.Title = rp.title(title)
+		obj.Searchable = 1
+		s.applyFolderMetadata(&obj, entryFilePath, rp)
+		if !s.art.knownMissing(entryFilePath) {
+			obj.AlbumArtURI = s.albumArtURI(cdsObject, host)
+		}
+		c := upnpav.Container{Object: obj}
+		if n, ok := s.childCount(cdsObject, rp); ok {
+			c.ChildCount = &n
+		}
+		if s.FolderPlaylists {
+			c.Res = append(c.Res, s.folderPlaylistRes(cdsObject, host))
+		}
+		ret = c
 		return
 	}
 	if !fileInfo.Mode().IsRegular() {
 		s.Logger.Printf("%s ignored: non-regular file", cdsObject.FilePath())
 		return
 	}
+	if playlist.Format(entryFilePath) != "" {
+		ret = s.playlistContainer(cdsObject, fileInfo, host, rp)
+		return
+	}
+	if playlist.StreamFormat(entryFilePath) != "" {
+		ret = s.streamItem(cdsObject, fileInfo, host, rp)
+		return
+	}
 	// mimeType, err := MimeTypeByPath(entryFilePath)
 	// if err != nil {
 	// 	return
@@ -90,42 +123,119 @@ func (s *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, file
 		s.Logger.Printf("%s ignored: non-media file (%s)", cdsObject.FilePath(), mimeType)
 		return
 	}
+	if s.hides(mimeType, rp) {
+		return
+	}
 
 	obj.Class = "object.item." + mimeType.Type() + "Item"
-	if obj.Title == "" {
-		obj.Title = fileInfo.Name()
+	title, year := s.objectTitle(cdsObject, fileInfo)
+	obj.Title = rp.title(title)
+	if year != 0 && mimeType.IsVideo() {
+		obj.Date = upnpav.Timestamp{Time: yearDate(year)}
+	}
+	var episode series.Episode
+	isEpisode := false
+	if mimeType.IsVideo() {
+		if episode, isEpisode = series.Parse(cdsObject.Path); isEpisode {
+			obj.Title = rp.title(episodeTitle(episode))
+		}
 	}
-
 	item := upnpav.Item{
 		Object: obj,
 		// Capacity: 1 for raw, 1 for icon, plus transcodes.
 		Res: make([]upnpav.Resource, 0, 2),
 	}
+	mi, _ := s.prober.cached(entryFilePath, fileInfo)
+	if mimeType.IsAudio() && (mi.HasCover || !s.art.knownMissing(path.Dir(entryFilePath))) {
+		item.AlbumArtURI = s.albumArtURI(cdsObject, host)
+	}
+	if !mi.Taken.IsZero() {
+		item.Date = upnpav.Timestamp{Time: mi.Taken}
+	}
 	item.Res = append(item.Res, upnpav.Resource{
-		URL: (&url.URL{
-			Scheme: s.WebdavURI.Scheme,
-			Host:   s.WebdavURI.Host,
-			Path:   entryFilePath,
-		}).String(),
-		ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", mimeType, dlna.ContentFeatures{
-			SupportRange: true,
+		URL: s.resURL(cdsObject, host),
+		ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", rp.mimeType(mimeType), dlna.ContentFeatures{
+			SupportTimeSeek: supportsTimeSeek(mimeType),
+			SupportRange:    true,
 		}.String()),
-		Size: uint64(fileInfo.Size()),
+		Size:       uint64(fileInfo.Size()),
+		Duration:   mi.duration(),
+		Resolution: mi.Resolution(),
 	})
+	if mimeType.IsImage() {
+		s.addThumbnails(&item, cdsObject, host, mimeType, mi)
+	}
+	if mimeType.IsVideo() {
+		s.addTranscode(&item, cdsObject, host, mi)
+		s.addHLS(&item, cdsObject, host, mimeType, mi)
+	}
+	if mimeType.IsVideo() {
+		item.DcmInfo = s.dcmInfo(cdsObject, fileInfo)
+	}
+	if isEpisode {
+		item.SeriesTitle = episode.Show
+		item.EpisodeSeason = episode.Season
+		item.EpisodeNumber = episode.Episode
+	}
+	if mimeType.IsVideo() {
+		s.applyVideoMetadata(&item, entryFilePath, rp)
+	} else if title, ok := s.metadataTitle(entryFilePath); ok {
+		item.Title = rp.title(title)
+	}
+	if mimeType.IsVideo() && s.art.hasVideoArt(entryFilePath, mi) {
+		s.addVideoArt(&item, cdsObject, host)
+	}
+	if dl, ok := s.listings.cached(path.Dir(entryFilePath)); ok && mimeType.IsVideo() {
+		s.addSubtitles(&item, cdsObject, subtitlesFor(dl, entryFilePath, s.SubtitleLanguages), host, rp)
+	}
 
 	ret = item
 	return
 }
 
 // Returns all the upnpav objects in a directory.
-func (s *contentDirectoryService) readContainer(o object, host, userAgent string) (ret []interface{}, err error) {
-	fis, err := s.webdavClient.ReadDir(o.Path)
+func (s *contentDirectoryService) readContainer(o object, host string, rp *rendererProfile) (ret []interface{}, err error) {
+	fis, err := s.Backend.ReadDir(o.Path)
 	if err != nil {
 		return
 	}
+	dl := s.listings.note(o.FilePath(), fis)
+	s.ignorer.note(o.FilePath(), fis)
+	var probes []probeFile
+	var subdirs []object
+	for _, fi := range fis {
+		child := object{path.Join(o.Path, fi.Name()), s.RootObjectPath}
+		if ignored, _ := s.IgnorePath(child.FilePath(), fi); ignored {
+			continue
+		}
+		if fi.IsDir() {
+			subdirs = append(subdirs, child)
+			continue
+		}
+		ct, ok := fi.(ContentType)
+		if !ok || !probeable(mimeType(ct.ContentType())) {
+			continue
+		}
+		probes = append(probes, probeFile{child.FilePath(), fi, mimeType(ct.ContentType())})
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.metadata.loadAll(o.FilePath(), dl, probeBudget)
+	}()
+	go func() {
+		defer wg.Done()
+		s.listForChildCounts(subdirs, childCountBudget)
+	}()
+	s.prober.probeAll(probes, probeBudget)
+	wg.Wait()
+	if fm := s.folderMetadata(o.FilePath()); fm != nil {
+		fis = fm.sort(fis)
+	}
 	for _, fi := range fis {
 		child := object{path.Join(o.Path, fi.Name()), s.RootObjectPath}
-		obj, err := s.cdsObjectToUpnpavObject(child, fi, host, userAgent)
+		obj, err := s.cdsObjectToUpnpavObject(child, fi, host, rp)
 		if err != nil {
 			s.Logger.Printf("error with %s: %s", child.FilePath(), err)
 			continue
@@ -142,14 +252,40 @@ func didlLite(chardata string) string {
 		` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
 		` xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/"` +
 		` xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"` +
-		` xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/">` +
+		` xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/"` +
+		` xmlns:sec="http://www.sec.co.kr/">` +
 		chardata +
 		`</DIDL-Lite>`
 }
 
+// Returns the response to a Browse or Search, given all the objects found.
+func (s *contentDirectoryService) result(objs []interface{}, startingIndex, requestedCount int) ([][2]string, error) {
+	totalMatches := len(objs)
+	objs = objs[func() (low int) {
+		low = startingIndex
+		if low > len(objs) {
+			low = len(objs)
+		}
+		return
+	}():]
+	if requestedCount != 0 && int(requestedCount) < len(objs) {
+		objs = objs[:requestedCount]
+	}
+	result, err := xml.Marshal(objs)
+	if err != nil {
+		return nil, err
+	}
+	return [][2]string{
+		{"Result", didlLite(string(result))},
+		{"NumberReturned", fmt.Sprint(len(objs))},
+		{"TotalMatches", fmt.Sprint(totalMatches)},
+		{"UpdateID", s.updateIDString()},
+	}, nil
+}
+
 func (s *contentDirectoryService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
 	host := r.Host
-	userAgent := r.UserAgent()
+	rp := s.renderer(r.Header)
 	switch action {
 	case "GetSystemUpdateID":
 		return [][2]string{
@@ -159,45 +295,79 @@ func (s *contentDirectoryService) Handle(action string, argsXML []byte, r *http.
 		return [][2]string{
 			{"SortCaps", "dc:title"},
 		}, nil
+	case "GetSearchCapabilities":
+		return [][2]string{
+			{"SearchCaps", searchCapabilities},
+		}, nil
 	case "Browse":
 		var browse browse
 		if err := xml.Unmarshal([]byte(argsXML), &browse); err != nil {
 			return nil, err
 		}
-		obj, err := s.objectFromID(browse.ObjectID)
-		if err != nil {
-			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
-		}
 		switch browse.BrowseFlag {
 		case "BrowseDirectChildren":
-			objs, err := s.readContainer(obj, host, userAgent)
-			if err != nil {
-				return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
-			}
-			totalMatches := len(objs)
-			objs = objs[func() (low int) {
-				low = browse.StartingIndex
-				if low > len(objs) {
-					low = len(objs)
+			var objs []interface{}
+			var err error
+			if vc, ok := virtualContainerByID(browse.ObjectID); ok {
+				objs, err = s.readVirtualContainer(browse.ObjectID, vc, searchAll{}, host, rp)
+			} else {
+				var obj object
+				if obj, err = s.objectFromID(browse.ObjectID); err != nil {
+					return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+				}
+				if playlist.Format(obj.Path) != "" {
+					objs, err = s.readPlaylist(obj, host, rp)
+				} else {
+					objs, err = s.readContainer(obj, host, rp)
 				}
-				return
-			}():]
-			if browse.RequestedCount != 0 && int(browse.RequestedCount) < len(objs) {
-				objs = objs[:browse.RequestedCount]
 			}
-			result, err := xml.Marshal(objs)
 			if err != nil {
-				return nil, err
+				return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
 			}
-			return [][2]string{
-				{"Result", didlLite(string(result))},
-				{"NumberReturned", fmt.Sprint(len(objs))},
-				{"TotalMatches", fmt.Sprint(totalMatches)},
-				{"UpdateID", s.updateIDString()},
-			}, nil
+			return s.result(objs, browse.StartingIndex, browse.RequestedCount)
 		default:
 			return nil, upnp.Errorf(upnp.ArgumentValueInvalidErrorCode, "unhandled browse flag: %v", browse.BrowseFlag)
 		}
+	case "X_GetFeatureList", "GetFeatureList":
+		return [][2]string{
+			{"FeatureList", samsungFeatureList},
+		}, nil
+	case "X_SetBookmark":
+		var sb setBookmark
+		if err := xml.Unmarshal(argsXML, &sb); err != nil {
+			return nil, upnp.Errorf(upnp.InvalidArgsErrorCode, err.Error())
+		}
+		obj, err := s.objectFromID(sb.ObjectID)
+		if err != nil {
+			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+		}
+		if err := s.bookmarks.set(obj.Path, sb.PosSecond); err != nil {
+			s.Logger.Printf("error saving bookmark of %s: %s", obj.Path, err)
+		}
+		return nil, nil
+	case "Search":
+		var search search
+		if err := xml.Unmarshal([]byte(argsXML), &search); err != nil {
+			return nil, err
+		}
+		sc, err := parseSearchCriteria(search.SearchCriteria)
+		if err != nil {
+			return nil, upnp.Errorf(upnpav.InvalidSearchCriteriaErrorCode, err.Error())
+		}
+		var objs []interface{}
+		if vc, ok := virtualContainerByID(search.ContainerID); ok {
+			objs, err = s.searchVirtualContainer(search.ContainerID, vc, sc, host, rp)
+		} else {
+			var obj object
+			if obj, err = s.objectFromID(search.ContainerID); err != nil {
+				return nil, upnp.Errorf(upnpav.NoSuchContainerErrorCode, err.Error())
+			}
+			objs, err = s.search(obj, sc, host, rp)
+		}
+		if err != nil {
+			return nil, upnp.Errorf(upnpav.NoSuchContainerErrorCode, err.Error())
+		}
+		return s.result(objs, search.StartingIndex, search.RequestedCount)
 	}
 	return nil, upnp.InvalidActionError
 }
@@ -230,11 +400,14 @@ func (o *object) IsRoot() bool {
 }
 
 // Returns the object's parent ObjectID. Fortunately it can be deduced from the
-// ObjectID (for now).
+// ObjectID (for now). The root directory's children are in the Folders view.
 func (o object) ParentID() string {
 	if o.IsRoot() {
 		return "-1"
 	}
 	o.Path = path.Dir(o.Path)
+	if o.IsRoot() {
+		return foldersID
+	}
 	return o.ID()
 }
@@ -0,0 +1,125 @@
+package dms
+
+import (
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gofly/alipan-dms/playlist"
+)
+
+const (
+	// Subdirectories that are listed to count their children, at most, each
+	// time a directory is read. The counts of the others become known as
+	// they're listed for other reasons, or crawled.
+	maxChildCountListings = 16
+	// How long reading a directory waits for those listings.
+	childCountBudget = 2 * time.Second
+)
+
+// Returns whether a renderer that hides what it can't play isn't sent items
+// of the type.
+func (s *Server) hides(mt mimeType, rp *rendererProfile) bool {
+	return rp.HideUnplayable && !rp.plays(mt) && !(mt.IsVideo() && s.transcoder != nil)
+}
+
+// Returns whether an entry of a directory is listed as a child of its
+// container: whether it's a folder, a playlist or stream link that isn't
+// known not to be one, or a media file, isn't ignored, and isn't hidden from
+// the renderer.
+func (s *Server) listsChild(filePath string, fi os.FileInfo, rp *rendererProfile) bool {
+	if ignored, err := s.IgnorePath(filePath, fi); err != nil || ignored {
+		return false
+	}
+	if fi.IsDir() {
+		return true
+	}
+	if !fi.Mode().IsRegular() {
+		return false
+	}
+	if playlist.Format(filePath) != "" {
+		entries, known := s.metadata.playlist(filePath, fi, false)
+		return !known || entries != nil
+	}
+	if playlist.StreamFormat(filePath) != "" {
+		mt, ok := s.linkedStreamType(filePath, fi)
+		return ok && !s.hides(mt, rp)
+	}
+	ct, ok := fi.(ContentType)
+	if !ok {
+		return false
+	}
+	mt := mimeType(ct.ContentType())
+	return mt.IsMedia() && !s.hides(mt, rp)
+}
+
+// Returns the number of children of a directory's container, if its listing
+// is cached or it's been crawled.
+func (s *Server) childCount(o object, rp *rendererProfile) (int, bool) {
+	if dl, ok := s.listings.cached(o.FilePath()); ok {
+		n := 0
+		for _, fi := range dl.fis {
+			if s.listsChild(path.Join(o.FilePath(), fi.Name()), fi, rp) {
+				n++
+			}
+		}
+		return n, true
+	}
+	// The index is already without what's ignored.
+	if d, ok := s.index.dir(o.Path); ok {
+		n := len(d.Dirs)
+		for _, f := range d.Files {
+			// Playlists are never hidden.
+			if playlist.Format(f.Name) != "" || !s.hides(f.MimeType, rp) {
+				n++
+			}
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+// Lists some of the subdirectories whose children can't otherwise be counted,
+// waiting at most budget. Listings that miss it finish in the background and
+// are cached.
+func (s *Server) listForChildCounts(dirs []object, budget time.Duration) {
+	var unknown []string
+	for _, o := range dirs {
+		if len(unknown) == maxChildCountListings {
+			break
+		}
+		if _, ok := s.listings.cached(o.FilePath()); ok {
+			continue
+		}
+		if _, ok := s.index.dir(o.Path); ok {
+			continue
+		}
+		unknown = append(unknown, o.FilePath())
+	}
+	if len(unknown) == 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, probeConcurrency)
+		for _, dir := range unknown {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(dir string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if _, err := s.listings.get(dir); err != nil {
+					s.Logger.Printf("error listing %s: %s", dir, err)
+				}
+			}(dir)
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(budget):
+	}
+}
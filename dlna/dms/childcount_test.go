@@ -0,0 +1,154 @@
+package dms
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+// Returns the child counts of the containers, by title, -1 if unknown.
+func childCounts(objs []interface{}) map[string]int {
+	counts := make(map[string]int)
+	for _, obj := range objs {
+		if c, ok := obj.(upnpav.Container); ok {
+			counts[c.Title] = -1
+			if c.ChildCount != nil {
+				counts[c.Title] = *c.ChildCount
+			}
+		}
+	}
+	return counts
+}
+
+func TestChildCount(t *testing.T) {
+	b := memBackend{
+		"/A/1.mkv":        nil,
+		"/A/2.mp3":        nil,
+		"/A/notes.txt":    nil,
+		"/A/.hidden.mkv":  nil,
+		"/A/Sub/3.mkv":    nil,
+		"/Empty/note.txt": nil,
+	}
+	for i := 0; i < maxChildCountListings+4; i++ {
+		b[fmt.Sprintf("/Many/%02d/x.mkv", i)] = nil
+	}
+	s := newTestServer(b)
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	objs, err := cds.readContainer(object{"/", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := childCounts(objs); c["A"] != 3 || c["Empty"] != 0 || c["Many"] != maxChildCountListings+4 {
+		t.Fatal(c)
+	}
+	// Just some of many subdirectories are listed each time.
+	objs, err = cds.readContainer(object{"/Many", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := 0
+	for _, n := range childCounts(objs) {
+		if n == -1 {
+			unknown++
+		} else if n != 1 {
+			t.Fatal(childCounts(objs))
+		}
+	}
+	if unknown != 4 {
+		t.Fatal(childCounts(objs))
+	}
+	result, err := xml.Marshal(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(result), `searchable="1" childCount="1"`) {
+		t.Fatal(string(result))
+	}
+
+	// Crawled directories are counted without listing them again.
+	s = newTestServer(b)
+	s.index.requestInterval = 0
+	if err := s.index.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := s.childCount(object{"/A", "/"}, genericRenderer); !ok || n != 3 {
+		t.Fatal(n, ok)
+	}
+	if _, ok := s.listings.cached("/A"); ok {
+		t.Fatal("listed")
+	}
+	cds = s.services["ContentDirectory"].(*contentDirectoryService)
+	objs, err = cds.readContainer(object{"/A", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Items aren't searchable.
+	result, _ = xml.Marshal(objs)
+	if !strings.Contains(string(result), `<item id="%2FA%2F1.mkv" parentID="%2FA" restricted="1">`) {
+		t.Fatal(string(result))
+	}
+}
+
+func TestVirtualChildCount(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Movies/Heat.mkv":                nil,
+		"/Shows/Lost/S01/Lost.S01E01.mkv": nil,
+		"/Shows/Lost/S01/Lost.S01E02.mkv": nil,
+		"/Shows/Lost/S02/Lost.S02E01.mkv": nil,
+		"/Music/Song.mp3":                 nil,
+	})
+	s.index.requestInterval = 0
+	if err := s.index.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	objs, err := cds.readVirtualContainer("0", views["0"], searchAll{}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := childCounts(objs); c["Videos"] != 4 || c["TV Shows"] != 1 || c["Music"] != 1 || c["Photos"] != 0 || c["Folders"] != 3 {
+		t.Fatal(c)
+	}
+	vc, _ := virtualContainerByID(tvShowID("Lost"))
+	objs, err = cds.readVirtualContainer(tvShowID("Lost"), vc, searchAll{}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := childCounts(objs); c["Season 1"] != 2 || c["Season 2"] != 1 {
+		t.Fatal(c)
+	}
+}
+
+func TestPlaylistChildCount(t *testing.T) {
+	b := memBackend{
+		"/Lists/Mix.m3u":   []byte("a.mp3\n"),
+		"/Lists/Best.pls":  []byte("[playlist]\nFile1=a.mp3\n"),
+		"/Lists/Live.m3u8": []byte("#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10,\nseg0.ts\n"),
+		"/Lists/a.mp3":     nil,
+	}
+	s := newTestServer(b)
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	// Once read, HLS playlists aren't counted.
+	if _, err := cds.readContainer(object{"/Lists", "/"}, "host", genericRenderer); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := s.childCount(object{"/Lists", "/"}, genericRenderer); !ok || n != 3 {
+		t.Fatal(n, ok)
+	}
+
+	s = newTestServer(b)
+	s.index.requestInterval = 0
+	if err := s.index.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := s.childCount(object{"/Lists", "/"}, genericRenderer); !ok || n != 3 {
+		t.Fatal(n, ok)
+	}
+	// Playlists aren't music.
+	if n, _ := s.virtualChildCount(views["music"], genericRenderer); n != 1 {
+		t.Fatal(n)
+	}
+}
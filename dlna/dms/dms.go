@@ -3,6 +3,7 @@ package dms
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/rand"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -12,13 +13,17 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/anacrolix/log"
+	"github.com/gofly/alipan-dms/blockcache"
 	"github.com/gofly/alipan-dms/soap"
 	"github.com/gofly/alipan-dms/ssdp"
+	"github.com/gofly/alipan-dms/title"
+	"github.com/gofly/alipan-dms/transcode"
 	"github.com/gofly/alipan-dms/upnp"
 	"github.com/gofly/alipan-dms/version"
 	"github.com/studio-b12/gowebdav"
@@ -135,9 +140,77 @@ type Server struct {
 	WebdavURI      *url.URL
 	WebdavUsername string
 	WebdavPassword string
+	// Defaults to a WebDAV client for WebdavURI, which follows download
+	// redirects to signed URLs itself.
+	Backend Backend
+	// The Referer sent when downloading from signed URLs. Defaults to the
+	// one aliyundrive requires.
+	DownloadReferer string
+	// Where on-disk caches are kept. Disk caching is disabled if empty.
+	CacheDir string
+	// Subtitle formats, like "srt", to serve to renderers whose User-Agent
+	// contains the key, overriding the defaults. An empty format serves
+	// subtitles unconverted.
+	SubtitleFormats map[string]string
+	// Languages, like "zh", whose sidecar subtitles are offered first, in
+	// order, so renderers that show the first one pick them.
+	SubtitleLanguages []string
+	// The command that transcodes videos for renderers that can't play
+	// them, like transcode.DefaultCommand. Transcoding is disabled if empty.
+	TranscodeCommand []string
+	// Concurrent transcodes. Defaults to 2.
+	MaxTranscodes int
+	// Largest total size of the on-disk read-ahead cache of streamed files.
+	// Defaults to 4GiB. The cache is disabled if negative, or if there's no
+	// CacheDir.
+	StreamCacheSize int64
+	// Limits on the reads of the backend at once, overall and for each client
+	// IP, which includes the stream cache's reads ahead. Reads over the
+	// limits wait. Zero is unlimited.
+	MaxStreams       int
+	MaxClientStreams int
+	// Limits on the bytes per second read from the backend, overall and for
+	// each client IP. Zero is unlimited.
+	StreamRate       int64
+	ClientStreamRate int64
+	// The rules that make titles of file and folder names, like
+	// title.DefaultRules, which are used if nil. If empty, names are shown
+	// as they are.
+	TitleRules []title.Rule
+	// Folders, by object path, whose files and subfolders are shown by their
+	// names as they are.
+	RawTitleFolders []string
+	// Whether an M3U of the items of each folder and playlist is served, and
+	// linked as their containers' resource, for renderers that play a whole
+	// folder.
+	FolderPlaylists bool
+	// Whether files and folders whose names start with a dot are served.
+	ShowHidden bool
+	// Patterns, in the syntax of .gitignore files, of the files and folders
+	// that aren't served, relative to the root object. DefaultIgnorePatterns
+	// are used if nil.
+	IgnorePatterns []string
+	// The DeviceIDs of the Windows Media receivers, like the Xbox, the
+	// X_MS_MediaReceiverRegistrar authorizes. Empty authorizes them all.
+	MediaReceivers []string
 	rootDescXML    []byte
 	rootDeviceUUID string
-	webdavClient   *gowebdav.Client
+	listings       *listingCache
+	ignorer        *ignorer
+	art            *artResolver
+	thumbnails     *thumbnailService
+	prober         *prober
+	metadata       *metadataStore
+	seekIndexes    *seekIndexCache
+	transcoder     *transcode.Transcoder
+	hlsSegments    *segmentCache
+	bookmarks      *bookmarkStore
+	index          *mediaIndex
+	// Nil if streams aren't cached.
+	streamCache *blockcache.Cache
+	streams     *streamScheduler
+	// Signs the URLs the stream relay is sent to by the playlists it relays.
+	relayKey []byte
 	// Time interval between SSPD announces
 	NotifyInterval time.Duration
 	closed         chan struct{}
@@ -185,6 +258,16 @@ func (s *Server) initMux(mux *http.ServeMux) {
 	})
 	handleSCPDs(mux)
 	mux.HandleFunc(serviceControlURL, s.serviceControlHandler)
+	mux.HandleFunc(resPath, s.serveResource)
+	mux.HandleFunc(artPath, s.serveArt)
+	mux.HandleFunc(subtitlePath, s.serveSubtitle)
+	mux.HandleFunc(thumbnailPath, s.serveThumbnail)
+	mux.HandleFunc(transcodePath, s.serveTranscode)
+	mux.HandleFunc(hlsPlaylistPath, s.serveHLSPlaylist)
+	mux.HandleFunc(hlsSegmentPath, s.serveHLSSegment)
+	mux.HandleFunc(indexStatusPath, s.serveIndexStatus)
+	mux.HandleFunc(folderPlaylistPath, s.serveFolderPlaylist)
+	mux.HandleFunc(streamPath, s.serveStream)
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 }
 
@@ -249,18 +332,85 @@ func (s *Server) Init() (err error) {
 		return
 	}
 	s.rootDescXML = append([]byte(`<?xml version="1.0"?>`), s.rootDescXML...)
-	s.webdavClient = gowebdav.NewClient(s.WebdavURI.String(), s.WebdavUsername, s.WebdavPassword)
+	if s.Backend == nil {
+		if s.DownloadReferer == "" {
+			s.DownloadReferer = defaultDownloadReferer
+		}
+		s.Backend = newRedirectBackend(
+			gowebdav.NewClient(s.WebdavURI.String(), s.WebdavUsername, s.WebdavPassword),
+			s.WebdavURI, s.WebdavUsername, s.WebdavPassword, s.DownloadReferer)
+	}
+	if s.TitleRules == nil {
+		s.TitleRules = title.DefaultRules
+	}
+	s.thumbnails = newThumbnailService(s.cacheSubdir("thumbnails"))
+	if s.IgnorePatterns == nil {
+		s.IgnorePatterns = DefaultIgnorePatterns
+	}
+	s.streams = newStreamScheduler(s.MaxStreams, s.MaxClientStreams, s.StreamRate, s.ClientStreamRate)
+	// The server's own reads wait their turn among the streams.
+	upstream := scheduledBackend{s.Backend, s.streams}
+	s.listings = newListingCache(s.Backend)
+	s.ignorer = newIgnorer(upstream, s.listings, (&object{"/", s.RootObjectPath}).FilePath(), s.ShowHidden, s.IgnorePatterns)
+	s.prober = newProber(upstream)
+	s.art = &artResolver{upstream, s.listings, s.thumbnails, s.prober, s.openImage}
+	s.metadata = newMetadataStore(upstream)
+	s.relayKey = make([]byte, 32)
+	if _, err = rand.Read(s.relayKey); err != nil {
+		return
+	}
+	s.seekIndexes = newSeekIndexCache(upstream)
+	var indexFile string
+	if dir := s.cacheSubdir("index"); dir != "" {
+		indexFile = filepath.Join(dir, "index.jsonl")
+	}
+	s.index = newMediaIndex(upstream, s.prober, s.metadata, s.ignorer, s.RootObjectPath, indexFile, s.Logger.WithNames("index"))
+	if len(s.TranscodeCommand) != 0 {
+		if s.MaxTranscodes == 0 {
+			s.MaxTranscodes = 2
+		}
+		s.transcoder = transcode.New(s.TranscodeCommand, s.MaxTranscodes)
+	}
+	s.hlsSegments = newSegmentCache(s.cacheSubdir("hls"))
+	var bookmarksFile string
+	if dir := s.cacheSubdir("state"); dir != "" {
+		bookmarksFile = filepath.Join(dir, "bookmarks.json")
+	}
+	if s.bookmarks, err = loadBookmarks(bookmarksFile); err != nil {
+		s.Logger.Printf("bookmarks lost: %s", err)
+		err = nil
+	}
+	if dir := s.cacheSubdir("streams"); dir != "" && s.StreamCacheSize >= 0 {
+		if s.StreamCacheSize == 0 {
+			s.StreamCacheSize = 4 << 30
+		}
+		if s.streamCache, err = blockcache.New(dir, s.StreamCacheSize); err != nil {
+			s.Logger.Printf("stream cache disabled: %s", err)
+			err = nil
+		}
+	}
 	s.Logger.Println("HTTP srv on", s.HTTPConn.Addr())
 	s.initMux(s.httpServeMux)
 	s.ssdpStopped = make(chan struct{})
 	return nil
 }
 
+// Returns the directory for a particular disk cache, or "" if disk caching is
+// disabled.
+func (s *Server) cacheSubdir(name string) string {
+	if s.CacheDir == "" {
+		return ""
+	}
+	return filepath.Join(s.CacheDir, name)
+}
+
 func (s *Server) Run() (err error) {
 	go func() {
 		s.doSSDP()
 		close(s.ssdpStopped)
 	}()
+	go s.hlsSegments.cleanLoop(s.closed)
+	go s.index.run(s.closed)
 	return s.serveHTTP()
 }
 
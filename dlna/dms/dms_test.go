@@ -0,0 +1,117 @@
+package dms
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// An in-memory Backend. Directories are implied by the file paths.
+type memBackend map[string][]byte
+
+type memFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) ModTime() time.Time { return time.Unix(1500000000, 0) }
+func (fi memFileInfo) IsDir() bool        { return fi.isDir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func (fi memFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi memFileInfo) ContentType() string {
+	if fi.isDir {
+		return ""
+	}
+	return mime.TypeByExtension(path.Ext(fi.name))
+}
+
+func (b memBackend) Stat(p string) (os.FileInfo, error) {
+	p = path.Clean(p)
+	if data, ok := b[p]; ok {
+		return memFileInfo{path.Base(p), int64(len(data)), false}, nil
+	}
+	for name := range b {
+		if p == "/" || strings.HasPrefix(name, p+"/") {
+			return memFileInfo{path.Base(p), 0, true}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (b memBackend) ReadDir(p string) (ret []os.FileInfo, err error) {
+	p = path.Clean(p)
+	seen := make(map[string]bool)
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for name, data := range b {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := name[len(prefix):]
+		child, _, isDir := strings.Cut(rest, "/")
+		if seen[child] {
+			continue
+		}
+		seen[child] = true
+		ret = append(ret, memFileInfo{child, int64(len(data)), isDir})
+	}
+	if len(ret) == 0 {
+		return nil, os.ErrNotExist
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name() < ret[j].Name() })
+	return
+}
+
+func (b memBackend) ReadStream(p string) (io.ReadCloser, error) {
+	data, ok := b[path.Clean(p)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b memBackend) ReadStreamRange(p string, offset, length int64) (io.ReadCloser, error) {
+	data, ok := b[path.Clean(p)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func newTestServer(b memBackend) *Server {
+	s := &Server{
+		FriendlyName:   "test",
+		RootObjectPath: "/",
+		WebdavURI:      &url.URL{Scheme: "http", Host: "webdav"},
+		Backend:        b,
+		Logger:         log.Default,
+	}
+	if err := s.Init(); err != nil {
+		panic(err)
+	}
+	return s
+}
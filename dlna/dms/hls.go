@@ -0,0 +1,319 @@
+package dms
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+const (
+	hlsPlaylistPath = "/hls/index.m3u8"
+	hlsSegmentPath  = "/hls/segment.ts"
+	// The nominal length of each segment.
+	hlsSegmentLength = 10 * time.Second
+	// Cached segments that go unused this long are removed.
+	hlsSegmentTTL    = time.Hour
+	hlsCleanInterval = 10 * time.Minute
+	// How long making a segment may take.
+	hlsSegmentTimeout = 2 * time.Minute
+)
+
+// Returns whether an HLS stream can be made of the video. Transport streams
+// are cut at keyframes, and anything else goes through the transcoder.
+// Either way the duration must be known to list the segments.
+func (s *Server) canHLS(mt mimeType, mi mediaInfo) bool {
+	return mi.Duration > 0 && (isMPEGTS(mt) || s.transcoder != nil && mt.IsVideo())
+}
+
+func (s *Server) hlsURL(o object, host string) string {
+	return (&url.URL{
+		Scheme:   "http",
+		Host:     host,
+		Path:     hlsPlaylistPath,
+		RawQuery: url.Values{"path": {o.Path}}.Encode(),
+	}).String()
+}
+
+// Offers the HLS playlist of a video item as a resource.
+func (s *Server) addHLS(item *upnpav.Item, o object, host string, mt mimeType, mi mediaInfo) {
+	if !s.canHLS(mt, mi) {
+		return
+	}
+	item.Res = append(item.Res, upnpav.Resource{
+		URL:          s.hlsURL(o, host),
+		ProtocolInfo: "http-get:*:application/vnd.apple.mpegurl:*",
+		Duration:     mi.duration(),
+	})
+}
+
+// Returns the video to stream over HLS from the request, and its duration.
+func (s *Server) hlsSource(w http.ResponseWriter, r *http.Request) (o object, fi os.FileInfo, mt mimeType, length time.Duration, ok bool) {
+	o = object{path.Clean("/" + r.URL.Query().Get("path")), s.RootObjectPath}
+	fi, err := s.Backend.Stat(o.FilePath())
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	mt = fileMimeType(fi)
+	if isMPEGTS(mt) {
+		si, err := s.seekIndexes.get(o.FilePath(), fi, mt)
+		if err != nil {
+			s.Logger.Printf("error indexing %s for HLS: %s", o.Path, err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		length = si.duration()
+	} else {
+		mi, _ := s.prober.cached(o.FilePath(), fi)
+		length = mi.Duration
+	}
+	if !s.canHLS(mt, mediaInfo{Duration: length}) {
+		http.Error(w, "can't stream over HLS", http.StatusNotFound)
+		return
+	}
+	return o, fi, mt, length, true
+}
+
+// Serves the playlist of segments of a video.
+func (s *Server) serveHLSPlaylist(w http.ResponseWriter, r *http.Request) {
+	o, fi, _, length, ok := s.hlsSource(w, r)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-MEDIA-SEQUENCE:0\n")
+	// Cuts at keyframes make segments vary around the nominal length.
+	fmt.Fprintf(&buf, "#EXT-X-TARGETDURATION:%d\n", int(hlsSegmentLength/time.Second)+1)
+	for n := 0; time.Duration(n)*hlsSegmentLength < length; n++ {
+		segment := hlsSegmentLength
+		if rest := length - time.Duration(n)*hlsSegmentLength; rest < segment {
+			segment = rest
+		}
+		fmt.Fprintf(&buf, "#EXTINF:%.3f,\n%s?%s\n", segment.Seconds(), path.Base(hlsSegmentPath), url.Values{
+			"path": {o.Path},
+			"n":    {strconv.Itoa(n)},
+		}.Encode())
+	}
+	buf.WriteString("#EXT-X-ENDLIST\n")
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(buf.Bytes()))
+}
+
+// Serves a segment of a video, making it if it isn't cached.
+func (s *Server) serveHLSSegment(w http.ResponseWriter, r *http.Request) {
+	o, fi, mt, length, ok := s.hlsSource(w, r)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	start := time.Duration(n) * hlsSegmentLength
+	if err != nil || n < 0 || start >= length {
+		http.NotFound(w, r)
+		return
+	}
+	filePath := o.FilePath()
+	key := fmt.Sprintf("%s\x00%d", fileKey(filePath, fi), n)
+	data, err := s.hlsSegments.get(key, func() ([]byte, error) {
+		// Everyone asking for the segment waits on this, so it isn't for
+		// any one client, and doesn't end when the first goes away.
+		ctx, cancel := context.WithTimeout(context.Background(), hlsSegmentTimeout)
+		defer cancel()
+		go func() {
+			select {
+			case <-s.closed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if isMPEGTS(mt) {
+			return s.cutTSSegment(ctx, filePath, fi, mt, start, length)
+		}
+		segment := hlsSegmentLength
+		if start+segment > length {
+			segment = length - start
+		}
+		out, err := s.transcoder.Start(ctx, s.transcodeJob(o, start, segment, ""))
+		if err != nil {
+			return nil, err
+		}
+		defer out.Close()
+		return io.ReadAll(out)
+	})
+	if err != nil {
+		s.Logger.Printf("error making HLS segment %d of %s: %s", n, o.Path, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(data))
+}
+
+// Returns the packets of a transport stream from start to the next segment,
+// cut at keyframes and led by the program tables, so that players can start
+// decoding at any segment.
+func (s *Server) cutTSSegment(ctx context.Context, filePath string, fi os.FileInfo, mt mimeType, start, length time.Duration) ([]byte, error) {
+	si, err := s.seekIndexes.get(filePath, fi, mt)
+	if err != nil {
+		return nil, err
+	}
+	ti, ok := si.(*tsSeekIndex)
+	if !ok {
+		return nil, errors.New("not a transport stream")
+	}
+	// The end of a segment is found the same way as the start of the next.
+	from, err := ti.cut(start, hlsSegmentLength)
+	if err != nil {
+		return nil, err
+	}
+	to := fi.Size()
+	if next := start + hlsSegmentLength; next < length {
+		if to, err = ti.cut(next, hlsSegmentLength); err != nil {
+			return nil, err
+		}
+	}
+	if to <= from {
+		return nil, nil
+	}
+	tables, err := ti.programTables()
+	if err != nil {
+		return nil, err
+	}
+	rs := s.openStream(ctx, "", filePath, fi)
+	defer rs.Close()
+	if _, err := rs.Seek(from, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(rs, to-from))
+	if err != nil {
+		return nil, err
+	}
+	return append(tables[:len(tables):len(tables)], ti.stream.Packets(data)...), nil
+}
+
+// Keeps made HLS segments on disk, and makes each only once at a time.
+type segmentCache struct {
+	// Segments aren't kept if empty.
+	dir      string
+	mu       sync.Mutex
+	inflight map[string]*segmentCall
+}
+
+type segmentCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newSegmentCache(dir string) *segmentCache {
+	return &segmentCache{
+		dir:      dir,
+		inflight: make(map[string]*segmentCall),
+	}
+}
+
+func (sc *segmentCache) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(sc.dir, hex.EncodeToString(sum[:])+".ts")
+}
+
+// Returns the segment for key, calling produce if it isn't cached or being made
+// already.
+func (sc *segmentCache) get(key string, produce func() ([]byte, error)) ([]byte, error) {
+	if sc.dir != "" {
+		p := sc.path(key)
+		if data, err := os.ReadFile(p); err == nil {
+			// The modification time marks when it was last used.
+			now := time.Now()
+			os.Chtimes(p, now, now)
+			return data, nil
+		}
+	}
+	sc.mu.Lock()
+	if c, ok := sc.inflight[key]; ok {
+		sc.mu.Unlock()
+		<-c.done
+		return c.data, c.err
+	}
+	c := &segmentCall{done: make(chan struct{})}
+	sc.inflight[key] = c
+	sc.mu.Unlock()
+	c.data, c.err = produce()
+	if c.err == nil && sc.dir != "" {
+		sc.put(key, c.data)
+	}
+	sc.mu.Lock()
+	delete(sc.inflight, key)
+	sc.mu.Unlock()
+	close(c.done)
+	return c.data, c.err
+}
+
+// Writes the segment atomically. Failing to cache isn't an error.
+func (sc *segmentCache) put(key string, data []byte) {
+	if err := os.MkdirAll(sc.dir, 0750); err != nil {
+		return
+	}
+	f, err := os.CreateTemp(sc.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), sc.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Removes segments unused for longer than maxAge.
+func (sc *segmentCache) clean(maxAge time.Duration) {
+	if sc.dir == "" {
+		return
+	}
+	entries, err := os.ReadDir(sc.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil || fi.Mode()&fs.ModeType != 0 {
+			continue
+		}
+		if time.Since(fi.ModTime()) > maxAge {
+			os.Remove(filepath.Join(sc.dir, e.Name()))
+		}
+	}
+}
+
+// Cleans the cache periodically until closed.
+func (sc *segmentCache) cleanLoop(closed <-chan struct{}) {
+	t := time.NewTicker(hlsCleanInterval)
+	defer t.Stop()
+	for {
+		sc.clean(hlsSegmentTTL)
+		select {
+		case <-t.C:
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package dms
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gofly/alipan-dms/transcode"
+)
+
+func getHLS(t *testing.T, s *Server, target string) string {
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	if rec.Code != 200 {
+		t.Fatal(target, rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+// Returns the segment URIs in a playlist.
+func playlistSegments(playlist string) (ret []string) {
+	sc := bufio.NewScanner(strings.NewReader(playlist))
+	for sc.Scan() {
+		if line := sc.Text(); line != "" && !strings.HasPrefix(line, "#") {
+			ret = append(ret, line)
+		}
+	}
+	return
+}
+
+func TestHLSRemux(t *testing.T) {
+	ts := testTS(2000)
+	s := newTestServer(memBackend{"/Videos/clip.ts": ts})
+	s.hlsSegments = newSegmentCache(t.TempDir())
+
+	playlist := getHLS(t, s, "/hls/index.m3u8?path=%2FVideos%2Fclip.ts")
+	segments := playlistSegments(playlist)
+	if !strings.HasPrefix(playlist, "#EXTM3U\n") || !strings.HasSuffix(playlist, "#EXT-X-ENDLIST\n") || len(segments) != 2 {
+		t.Fatal(playlist)
+	}
+	if !strings.Contains(playlist, "#EXTINF:10.000,\nsegment.ts?n=0&path=%2FVideos%2Fclip.ts\n#EXTINF:9.900,\n") {
+		t.Fatal(playlist)
+	}
+	var joined []byte
+	for _, seg := range segments {
+		joined = append(joined, getHLS(t, s, "/hls/"+seg)...)
+	}
+	if !bytes.Equal(joined, ts) {
+		t.Fatal("segments don't make up the stream")
+	}
+	// Served from the cache once made, while the file appears unchanged.
+	s.Backend = memBackend{"/Videos/clip.ts": make([]byte, len(ts))}
+	if got := getHLS(t, s, "/hls/"+segments[1]); got != string(ts[len(ts)-len(got):]) {
+		t.Fatal("segment not cached")
+	}
+	s.hlsSegments.clean(0)
+	if entries, _ := os.ReadDir(s.hlsSegments.dir); len(entries) != 0 {
+		t.Fatal(entries)
+	}
+}
+
+func TestHLSKeyframes(t *testing.T) {
+	// A PAT pointing to a PMT on PID 0x1000 that lists H.264 on PID 0x100.
+	pat := make([]byte, 188)
+	copy(pat, []byte{0x47, 0x40, 0x00, 0x10, 0, 0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00})
+	pmt := make([]byte, 188)
+	copy(pmt, []byte{0x47, 0x50, 0x00, 0x10, 0, 0x02, 0xb0, 18, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0, 0x1b, 0xe1, 0x00, 0xf0, 0})
+	tables := append(pat, pmt...)
+	ts := append(tables, testTS(2000)...)
+	// Keyframes half a second into each segment.
+	for _, i := range []int{52, 1052} {
+		ts[i*188+5] |= 0x40
+	}
+	// The same packets as M2TS, each after a 4-byte timestamp, are cut into
+	// the same segments.
+	var m2ts []byte
+	for i := 0; i < len(ts); i += 188 {
+		m2ts = append(append(m2ts, 0, 0, byte(i/188>>8), byte(i/188)), ts[i:i+188]...)
+	}
+	s := newTestServer(memBackend{"/Videos/clip.ts": ts, "/Videos/clip.m2ts": m2ts})
+	for _, name := range []string{"clip.ts", "clip.m2ts"} {
+		segments := playlistSegments(getHLS(t, s, "/hls/index.m3u8?path=%2FVideos%2F"+name))
+		if len(segments) != 2 {
+			t.Fatal(name, segments)
+		}
+		for i, want := range [][]byte{ts[52*188 : 1052*188], ts[1052*188:]} {
+			if got := getHLS(t, s, "/hls/"+segments[i]); got != string(tables)+string(want) {
+				t.Errorf("%s segment %d: %d bytes", name, i, len(got))
+			}
+		}
+	}
+}
+
+func TestHLSTranscode(t *testing.T) {
+	s := newTestServer(memBackend{"/Videos/movie.mp4": []byte("movie")})
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", "/hls/index.m3u8?path=%2FVideos%2Fmovie.mp4", nil))
+	if rec.Code != 404 {
+		t.Fatal("HLS offered without a transcoder", rec.Code)
+	}
+
+	s.transcoder = transcode.New([]string{"sh", "-c", `echo "$0+$1"`, transcode.StartArg, transcode.LengthArg}, 1)
+	fi, _ := s.Backend.Stat("/Videos/movie.mp4")
+	s.prober.infos[fileKey("/Videos/movie.mp4", fi)] = mediaInfo{Duration: 25e9}
+	segments := playlistSegments(getHLS(t, s, "/hls/index.m3u8?path=%2FVideos%2Fmovie.mp4"))
+	if len(segments) != 3 {
+		t.Fatal(segments)
+	}
+	if got := getHLS(t, s, "/hls/"+segments[2]); got != "20.000+5.000\n" {
+		t.Fatal(got)
+	}
+	// Segments are made for everyone waiting on them, whoever asked first.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec = httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", "/hls/"+segments[1], nil).WithContext(ctx))
+	if got := getHLS(t, s, "/hls/"+segments[1]); rec.Code != 200 || got != "10.000+10.000\n" {
+		t.Fatal(rec.Code, got)
+	}
+}
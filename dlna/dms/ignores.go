@@ -0,0 +1,160 @@
+package dms
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gofly/alipan-dms/ignore"
+)
+
+// The file in a folder with patterns, like a .gitignore, of what's ignored in
+// it.
+const ignoreFileName = ".dlnaignore"
+
+// DefaultIgnorePatterns ignore the thumbnails, recycle bins and unfinished
+// downloads NASes and download tools leave around.
+var DefaultIgnorePatterns = []string{
+	"@eaDir/",
+	`\#recycle/`,
+	"$RECYCLE.BIN/",
+	"*.part",
+	"*.crdownload",
+	"*.!qB",
+}
+
+// Decides which files and folders aren't served: hidden ones, those matching
+// global patterns, and those the .dlnaignore files of the folders they're in
+// ignore.
+type ignorer struct {
+	backend  Backend
+	listings *listingCache
+	// The file path of the root object, which global patterns are relative to.
+	root       string
+	showHidden bool
+	global     *ignore.Rules
+	mu         sync.Mutex
+	// The rules of the directories as they were last listed, nil for those
+	// without a .dlnaignore. They're kept, rather than expiring like
+	// listings, so that items from the media index don't need their folders
+	// listed again.
+	dirs map[string]*ignore.Rules
+	// Keyed by fileKey, nil if unreadable.
+	files map[string]*ignore.Rules
+}
+
+func newIgnorer(backend Backend, listings *listingCache, root string, showHidden bool, patterns []string) *ignorer {
+	return &ignorer{
+		backend:    backend,
+		listings:   listings,
+		root:       root,
+		showHidden: showHidden,
+		global:     ignore.Parse(strings.Join(patterns, "\n")),
+		dirs:       make(map[string]*ignore.Rules),
+		files:      make(map[string]*ignore.Rules),
+	}
+}
+
+// Records the rules of a directory from a listing of it that was made anyway,
+// reading its .dlnaignore if it's changed.
+func (ig *ignorer) note(dir string, fis []os.FileInfo) *ignore.Rules {
+	var rules *ignore.Rules
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.EqualFold(fi.Name(), ignoreFileName) {
+			continue
+		}
+		p := path.Join(dir, fi.Name())
+		key := fileKey(p, fi)
+		ig.mu.Lock()
+		r, ok := ig.files[key]
+		ig.mu.Unlock()
+		if !ok {
+			data, err := readSidecar(ig.backend, p, fi)
+			if err == nil {
+				r = ignore.Parse(string(bytes.TrimPrefix(data, []byte("\ufeff"))))
+			}
+			ig.mu.Lock()
+			ig.files[key] = r
+			ig.mu.Unlock()
+		}
+		rules = r
+		break
+	}
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	ig.dirs[dir] = rules
+	return rules
+}
+
+// Records the patterns of a directory's .dlnaignore, empty if it has none,
+// that were known from before.
+func (ig *ignorer) set(dir, text string) {
+	var rules *ignore.Rules
+	if text != "" {
+		rules = ignore.Parse(text)
+	}
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	ig.dirs[dir] = rules
+}
+
+// Returns the rules of the .dlnaignore in a directory, nil if it has none.
+func (ig *ignorer) dirRules(dir string) (*ignore.Rules, error) {
+	ig.mu.Lock()
+	rules, ok := ig.dirs[dir]
+	ig.mu.Unlock()
+	if ok {
+		return rules, nil
+	}
+	dl, err := ig.listings.get(dir)
+	if err != nil {
+		return nil, err
+	}
+	return ig.note(dir, dl.fis), nil
+}
+
+// Returns whether the file or folder is ignored, or is in a folder that is.
+// Rules in deeper folders take precedence, and a file can't be re-included if
+// its folder is ignored.
+func (ig *ignorer) ignored(filePath string, isDir bool) (bool, error) {
+	filePath = path.Clean(filePath)
+	if filePath == ig.root || ig.root != "/" && !strings.HasPrefix(filePath, ig.root+"/") {
+		return false, nil
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(filePath, ig.root), "/")
+	names := strings.Split(rel, "/")
+	// The rules of each folder from the root down.
+	var rules []*ignore.Rules
+	dir := ig.root
+	for i, name := range names {
+		r, err := ig.dirRules(dir)
+		if err != nil {
+			return false, err
+		}
+		rules = append(rules, r)
+		isDir := isDir || i+1 < len(names)
+		ignored := !ig.showHidden && strings.HasPrefix(name, ".")
+		if ign, ok := ig.global.Match(strings.Join(names[:i+1], "/"), isDir); ok {
+			ignored = ign
+		}
+		for j, r := range rules {
+			if ign, ok := r.Match(strings.Join(names[j:i+1], "/"), isDir); ok {
+				ignored = ign
+			}
+		}
+		if ignored {
+			return true, nil
+		}
+		dir = path.Join(dir, name)
+	}
+	return false, nil
+}
+
+// IgnorePath returns whether the file or folder at the path isn't served:
+// whether it's hidden, matches the IgnorePatterns, or a .dlnaignore of the
+// folders it's in ignores it.
+func (s *Server) IgnorePath(filePath string, fi os.FileInfo) (bool, error) {
+	return s.ignorer.ignored(filePath, fi.IsDir())
+}
@@ -0,0 +1,57 @@
+package dms
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIgnores(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Movies/heat.mkv":                  nil,
+		"/Movies/.hidden.mkv":               nil,
+		"/Movies/@eaDir/heat.mkv/thumb.jpg": nil,
+		"/Movies/.dlnaignore":               []byte("*.sample.mkv\nextras/\n!keep.sample.mkv\n"),
+		"/Movies/heat.sample.mkv":           nil,
+		"/Movies/keep.sample.mkv":           nil,
+		"/Movies/extras/making.mkv":         nil,
+		"/Movies/Sub/.dlnaignore":           []byte("!*.sample.mkv\n"),
+		"/Movies/Sub/sub.sample.mkv":        nil,
+		"/Music/song.mp3":                   nil,
+	})
+	s.index.requestInterval = 0
+	if err := s.index.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	objs, err := cds.readContainer(object{"/Movies", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, obj := range objs {
+		titles = append(titles, upnpavObject(obj).Title)
+	}
+	if len(titles) != 3 || titles[0] != "Sub" || titles[1] != "heat" || titles[2] != "keep sample" {
+		t.Fatal(titles)
+	}
+	var indexed []string
+	s.index.walk("/", func(p string, fi os.FileInfo) {
+		indexed = append(indexed, p)
+	})
+	want := []string{"/Movies", "/Movies/Sub", "/Movies/Sub/sub.sample.mkv", "/Movies/heat.mkv", "/Movies/keep.sample.mkv", "/Music", "/Music/song.mp3"}
+	if len(indexed) != len(want) {
+		t.Fatal(indexed)
+	}
+	for i := range want {
+		if indexed[i] != want[i] {
+			t.Fatal(indexed)
+		}
+	}
+
+	s = newTestServer(memBackend{"/.hidden/a.mkv": nil, "/b.mkv": nil})
+	s.ignorer = newIgnorer(s.Backend, s.listings, "/", true, nil)
+	objs, err = s.services["ContentDirectory"].(*contentDirectoryService).readContainer(object{"/", "/"}, "host", genericRenderer)
+	if err != nil || len(objs) != 2 {
+		t.Fatal(objs, err)
+	}
+}
@@ -0,0 +1,630 @@
+package dms
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+	"github.com/gofly/alipan-dms/playlist"
+)
+
+const (
+	// How often the backend is crawled again.
+	indexInterval = 30 * time.Minute
+	// How long after a failed crawl it's tried again.
+	indexRetryInterval = 5 * time.Minute
+	// The least time between the crawler's requests to the backend, so it
+	// doesn't compete with streaming.
+	indexRequestInterval = 200 * time.Millisecond
+	indexStatusPath      = "/index"
+)
+
+var errIndexStopped = errors.New("indexing stopped")
+
+// A directory as the crawler last saw it.
+type indexDir struct {
+	// The object path.
+	Path string `json:"path"`
+	// The crawl that listed it.
+	Gen   int           `json:"-"`
+	Dirs  []indexSubdir `json:"dirs,omitempty"`
+	Files []indexFile   `json:"files,omitempty"`
+	// The patterns of its .dlnaignore, if it has one.
+	Ignore string `json:"ignore,omitempty"`
+}
+
+type indexSubdir struct {
+	Name    string    `json:"name"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// Returns the object paths of the directory's subdirectories.
+func (d *indexDir) subdirs() (ret []string) {
+	for _, sub := range d.Dirs {
+		ret = append(ret, path.Join(d.Path, sub.Name))
+	}
+	return
+}
+
+// A media file in an indexed directory.
+type indexFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	MimeType mimeType  `json:"type"`
+	// Nil if the file isn't probeable.
+	Info *mediaInfo `json:"info,omitempty"`
+}
+
+// An indexed file's details as an os.FileInfo.
+type indexFileInfo struct {
+	f *indexFile
+}
+
+func (fi indexFileInfo) Name() string        { return fi.f.Name }
+func (fi indexFileInfo) Size() int64         { return fi.f.Size }
+func (fi indexFileInfo) ModTime() time.Time  { return fi.f.ModTime }
+func (fi indexFileInfo) IsDir() bool         { return false }
+func (fi indexFileInfo) Mode() os.FileMode   { return 0644 }
+func (fi indexFileInfo) Sys() interface{}    { return nil }
+func (fi indexFileInfo) ContentType() string { return string(fi.f.MimeType) }
+
+// An indexed subdirectory as an os.FileInfo.
+type indexDirInfo struct {
+	d *indexSubdir
+}
+
+func (fi indexDirInfo) Name() string       { return fi.d.Name }
+func (fi indexDirInfo) Size() int64        { return 0 }
+func (fi indexDirInfo) ModTime() time.Time { return fi.d.ModTime }
+func (fi indexDirInfo) IsDir() bool        { return true }
+func (fi indexDirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (fi indexDirInfo) Sys() interface{}   { return nil }
+
+// A line of the index file: a directory, or the end of a crawl.
+type indexRecord struct {
+	Gen       int       `json:"gen"`
+	Dir       *indexDir `json:"dir,omitempty"`
+	Completed time.Time `json:"completed,omitempty"`
+}
+
+// A media file found by the index.
+type indexEntry struct {
+	// The object path.
+	Path     string
+	Info     os.FileInfo
+	MimeType mimeType
+}
+
+// Returns the UPnP class of the entry's object.
+func (e indexEntry) class() string {
+	switch {
+	case playlist.Format(e.Path) != "":
+		return "object.container.playlistContainer"
+	case playlist.StreamFormat(e.Path) != "":
+		t := e.MimeType.Type()
+		return "object.item." + t + "Item." + t + "Broadcast"
+	}
+	return "object.item." + e.MimeType.Type() + "Item"
+}
+
+// How far the crawler has got.
+type indexProgress struct {
+	// The current or last crawl.
+	Gen      int  `json:"gen"`
+	Crawling bool `json:"crawling"`
+	// Directories listed by the current crawl, and waiting to be.
+	Listed  int `json:"listed"`
+	Pending int `json:"pending"`
+	// In the whole index.
+	Dirs  int `json:"dirs"`
+	Files int `json:"files"`
+	// When the last crawl completed.
+	Completed time.Time `json:"completed"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// Knows all the media files of the backend, and their probed metadata, by
+// crawling it in the background. Each directory is appended to the index file
+// as it's listed, so a crawl that's interrupted resumes where it left off.
+// The file is rewritten without the directories that have gone when a crawl
+// completes.
+type mediaIndex struct {
+	backend        Backend
+	prober         *prober
+	metadata       *metadataStore
+	ignorer        *ignorer
+	rootObjectPath string
+	// Not saved if empty.
+	file            string
+	requestInterval time.Duration
+	logger          log.Logger
+
+	mu sync.Mutex
+	// Keyed by object path.
+	dirs         map[string]*indexDir
+	progress     indexProgress
+	completedGen int
+	// The files of dirs, sorted by path. Nil when it needs making again.
+	entries []indexEntry
+	// Appended to during a crawl.
+	log *os.File
+}
+
+func newMediaIndex(backend Backend, prober *prober, metadata *metadataStore, ignorer *ignorer, rootObjectPath, file string, logger log.Logger) *mediaIndex {
+	return &mediaIndex{
+		backend:         backend,
+		prober:          prober,
+		metadata:        metadata,
+		ignorer:         ignorer,
+		rootObjectPath:  rootObjectPath,
+		file:            file,
+		requestInterval: indexRequestInterval,
+		logger:          logger,
+		dirs:            make(map[string]*indexDir),
+	}
+}
+
+func (mi *mediaIndex) filePath(objPath string) string {
+	o := object{objPath, mi.rootObjectPath}
+	return o.FilePath()
+}
+
+// Loads the index file, if there is one, and tells the prober the metadata in
+// it and the ignorer the directories' rules.
+func (mi *mediaIndex) load() error {
+	if mi.file == "" {
+		return nil
+	}
+	f, err := os.Open(mi.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var rec indexRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			// A line cut short by a crash.
+			continue
+		}
+		if rec.Gen > mi.progress.Gen {
+			mi.progress.Gen = rec.Gen
+		}
+		if rec.Dir != nil {
+			rec.Dir.Gen = rec.Gen
+			mi.dirs[rec.Dir.Path] = rec.Dir
+		} else {
+			mi.completedGen = rec.Gen
+			mi.progress.Completed = rec.Completed
+		}
+	}
+	for _, d := range mi.dirs {
+		mi.ignorer.set(mi.filePath(d.Path), d.Ignore)
+		for i := range d.Files {
+			if f := &d.Files[i]; f.Info != nil {
+				mi.prober.remember(mi.filePath(path.Join(d.Path, f.Name)), indexFileInfo{f}, *f.Info)
+			}
+		}
+	}
+	mi.entries = nil
+	mi.countLocked()
+	return sc.Err()
+}
+
+// Updates the counts of dirs and files in the progress.
+func (mi *mediaIndex) countLocked() {
+	mi.progress.Dirs = len(mi.dirs)
+	mi.progress.Files = 0
+	for _, d := range mi.dirs {
+		mi.progress.Files += len(d.Files)
+	}
+}
+
+// Waits between requests to the backend.
+func (mi *mediaIndex) pace(stop <-chan struct{}) error {
+	select {
+	case <-stop:
+		return errIndexStopped
+	default:
+	}
+	select {
+	case <-stop:
+		return errIndexStopped
+	case <-time.After(mi.requestInterval):
+		return nil
+	}
+}
+
+func (mi *mediaIndex) appendLocked(rec indexRecord) {
+	if mi.log == nil {
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err == nil {
+		_, err = mi.log.Write(append(data, '\n'))
+	}
+	if err != nil {
+		mi.logger.Printf("error saving index: %s", err)
+	}
+}
+
+// Lists a directory, probing the media files that are new or changed since
+// it was last listed.
+func (mi *mediaIndex) list(dir string, old *indexDir, gen int, stop <-chan struct{}) (*indexDir, error) {
+	if err := mi.pace(stop); err != nil {
+		return nil, err
+	}
+	fis, err := mi.backend.ReadDir(mi.filePath(dir))
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]*indexFile)
+	if old != nil {
+		for i := range old.Files {
+			known[old.Files[i].Name] = &old.Files[i]
+		}
+	}
+	d := &indexDir{Path: dir, Gen: gen}
+	if rules := mi.ignorer.note(mi.filePath(dir), fis); rules != nil {
+		d.Ignore = rules.String()
+	}
+	for _, fi := range fis {
+		p := path.Join(dir, fi.Name())
+		if ignored, err := mi.ignorer.ignored(mi.filePath(p), fi.IsDir()); err != nil || ignored {
+			continue
+		}
+		if fi.IsDir() {
+			d.Dirs = append(d.Dirs, indexSubdir{fi.Name(), fi.ModTime()})
+			continue
+		}
+		mt := fileMimeType(fi)
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		if playlist.Format(fi.Name()) != "" {
+			if ok, err := mi.listsPlaylist(p, fi, known[fi.Name()], stop); err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+		} else if playlist.StreamFormat(fi.Name()) != "" {
+			var ok bool
+			if mt, ok, err = mi.indexedStream(p, fi, known[fi.Name()], stop); err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+		} else if !mt.IsMedia() {
+			continue
+		}
+		f := indexFile{Name: fi.Name(), Size: fi.Size(), ModTime: fi.ModTime(), MimeType: mt}
+		if k, ok := known[f.Name]; ok && k.Size == f.Size && k.ModTime.Equal(f.ModTime) && k.MimeType == mt {
+			f.Info = k.Info
+		} else if probeable(mt) {
+			info, ok := mi.prober.cached(mi.filePath(p), fi)
+			if !ok {
+				if err := mi.pace(stop); err != nil {
+					return nil, err
+				}
+				if info, err = mi.prober.probe(probeFile{mi.filePath(p), fi, mt}); err != nil {
+					mi.logger.Printf("error probing %s: %s", p, err)
+				}
+			}
+			f.Info = &info
+		}
+		d.Files = append(d.Files, f)
+	}
+	return d, nil
+}
+
+// Returns whether a playlist is indexed: whether it's a playlist of tracks,
+// rather than of an HLS stream. It's read unless it's unchanged since it was
+// last indexed.
+func (mi *mediaIndex) listsPlaylist(p string, fi os.FileInfo, old *indexFile, stop <-chan struct{}) (bool, error) {
+	if old != nil && old.Size == fi.Size() && old.ModTime.Equal(fi.ModTime()) {
+		return true, nil
+	}
+	entries, known := mi.metadata.playlist(mi.filePath(p), fi, false)
+	if !known {
+		if err := mi.pace(stop); err != nil {
+			return false, err
+		}
+		entries, _ = mi.metadata.playlist(mi.filePath(p), fi, true)
+	}
+	return entries != nil, nil
+}
+
+// Returns the MIME-type of the stream a .strm or .url file links to, which
+// it's indexed as, or false if it doesn't link to one. It's read unless it's
+// unchanged since it was last indexed.
+func (mi *mediaIndex) indexedStream(p string, fi os.FileInfo, old *indexFile, stop <-chan struct{}) (mimeType, bool, error) {
+	if old != nil && old.Size == fi.Size() && old.ModTime.Equal(fi.ModTime()) {
+		return old.MimeType, true, nil
+	}
+	u, known := mi.metadata.stream(mi.filePath(p), fi, false)
+	if !known {
+		if err := mi.pace(stop); err != nil {
+			return "", false, err
+		}
+		u, _ = mi.metadata.stream(mi.filePath(p), fi, true)
+	}
+	return streamMimeType(u), u != "", nil
+}
+
+// Crawls the backend, continuing the last crawl if it didn't complete.
+// Directories that can't be listed keep what was known of them.
+func (mi *mediaIndex) crawl(stop <-chan struct{}) (err error) {
+	mi.mu.Lock()
+	if mi.completedGen == mi.progress.Gen {
+		mi.progress.Gen++
+	}
+	gen := mi.progress.Gen
+	mi.progress.Crawling = true
+	mi.progress.Listed = 0
+	mi.progress.LastError = ""
+	if mi.file != "" {
+		var openErr error
+		if openErr = os.MkdirAll(filepath.Dir(mi.file), 0755); openErr == nil {
+			mi.log, openErr = os.OpenFile(mi.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		}
+		if openErr != nil {
+			mi.logger.Printf("error saving index: %s", openErr)
+		}
+	}
+	mi.mu.Unlock()
+	defer func() {
+		mi.mu.Lock()
+		defer mi.mu.Unlock()
+		if mi.log != nil {
+			mi.log.Close()
+			mi.log = nil
+		}
+		mi.progress.Crawling = false
+		mi.progress.Pending = 0
+		if err != nil {
+			mi.progress.LastError = err.Error()
+		}
+	}()
+
+	queue := []string{"/"}
+	for len(queue) != 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		mi.mu.Lock()
+		old := mi.dirs[dir]
+		mi.progress.Pending = len(queue)
+		mi.mu.Unlock()
+		if old != nil && old.Gen == gen {
+			// Listed before the crawl was interrupted.
+			queue = append(queue, old.subdirs()...)
+			continue
+		}
+		d, err := mi.list(dir, old, gen, stop)
+		if err == errIndexStopped {
+			return err
+		}
+		if err != nil {
+			if old == nil {
+				if dir == "/" {
+					return err
+				}
+				mi.logger.Printf("error indexing %s: %s", dir, err)
+				continue
+			}
+			mi.logger.Printf("error indexing %s, keeping what's known: %s", dir, err)
+			keep := *old
+			keep.Gen = gen
+			d = &keep
+		}
+		mi.mu.Lock()
+		mi.dirs[dir] = d
+		mi.entries = nil
+		mi.progress.Listed++
+		mi.countLocked()
+		mi.appendLocked(indexRecord{Gen: gen, Dir: d})
+		mi.mu.Unlock()
+		queue = append(queue, d.subdirs()...)
+	}
+
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	for p, d := range mi.dirs {
+		if d.Gen != gen {
+			delete(mi.dirs, p)
+		}
+	}
+	mi.entries = nil
+	mi.countLocked()
+	mi.completedGen = gen
+	mi.progress.Completed = time.Now()
+	if err := mi.saveLocked(); err != nil {
+		mi.logger.Printf("error saving index: %s", err)
+	}
+	return nil
+}
+
+// Rewrites the index file with just the current directories, marking the
+// crawl complete.
+func (mi *mediaIndex) saveLocked() error {
+	if mi.file == "" {
+		return nil
+	}
+	if mi.log != nil {
+		mi.log.Close()
+		mi.log = nil
+	}
+	tmp := mi.file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	paths := make([]string, 0, len(mi.dirs))
+	for p := range mi.dirs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		enc.Encode(indexRecord{Gen: mi.completedGen, Dir: mi.dirs[p]})
+	}
+	enc.Encode(indexRecord{Gen: mi.completedGen, Completed: mi.progress.Completed})
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, mi.file)
+}
+
+// Loads the index, then crawls the backend every indexInterval until
+// stopped, starting straight away if the last crawl didn't complete.
+func (mi *mediaIndex) run(stop <-chan struct{}) {
+	if err := mi.load(); err != nil {
+		mi.logger.Printf("error loading index: %s", err)
+	}
+	mi.mu.Lock()
+	wait := time.Until(mi.progress.Completed.Add(indexInterval))
+	if mi.completedGen != mi.progress.Gen {
+		wait = 0
+	}
+	mi.mu.Unlock()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		started := time.Now()
+		err := mi.crawl(stop)
+		if err == errIndexStopped {
+			return
+		}
+		if err != nil {
+			mi.logger.Printf("error indexing: %s", err)
+			wait = indexRetryInterval
+			continue
+		}
+		p := mi.status()
+		mi.logger.Printf("indexed %d media files in %d directories in %s", p.Files, p.Dirs, time.Since(started))
+		wait = indexInterval
+	}
+}
+
+func (mi *mediaIndex) status() indexProgress {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	return mi.progress
+}
+
+func (mi *mediaIndex) all() []indexEntry {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	if mi.entries == nil {
+		entries := make([]indexEntry, 0, mi.progress.Files)
+		for _, d := range mi.dirs {
+			for i := range d.Files {
+				f := &d.Files[i]
+				entries = append(entries, indexEntry{path.Join(d.Path, f.Name), indexFileInfo{f}, f.MimeType})
+			}
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+		mi.entries = entries
+	}
+	return mi.entries
+}
+
+// Returns the directory at the object path as it was last crawled, if it
+// has been.
+func (mi *mediaIndex) dir(p string) (*indexDir, bool) {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	d, ok := mi.dirs[p]
+	return d, ok
+}
+
+// Calls visit with each subdirectory and media file under dir, by path, and
+// returns true, if a crawl has completed. Otherwise it returns false without
+// visiting anything.
+func (mi *mediaIndex) walk(dir string, visit func(objPath string, fi os.FileInfo)) bool {
+	type child struct {
+		path string
+		fi   os.FileInfo
+	}
+	var children []child
+	mi.mu.Lock()
+	if mi.completedGen == 0 {
+		mi.mu.Unlock()
+		return false
+	}
+	for p, d := range mi.dirs {
+		if dir != "/" && p != dir && !strings.HasPrefix(p, dir+"/") {
+			continue
+		}
+		for i := range d.Dirs {
+			children = append(children, child{path.Join(p, d.Dirs[i].Name), indexDirInfo{&d.Dirs[i]}})
+		}
+		for i := range d.Files {
+			children = append(children, child{path.Join(p, d.Files[i].Name), indexFileInfo{&d.Files[i]}})
+		}
+	}
+	mi.mu.Unlock()
+	sort.Slice(children, func(i, j int) bool { return children[i].path < children[j].path })
+	for _, c := range children {
+		visit(c.path, c.fi)
+	}
+	return true
+}
+
+// Returns the entries whose items derive from the class, by path.
+func (mi *mediaIndex) items(class string) (ret []indexEntry) {
+	for _, e := range mi.all() {
+		if derivesFrom(e.class(), class) {
+			ret = append(ret, e)
+		}
+	}
+	return
+}
+
+// Returns the n most recently modified entries whose items derive from the
+// class, newest first.
+func (mi *mediaIndex) recent(class string, n int) []indexEntry {
+	entries := mi.items(class)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Info.ModTime().After(entries[j].Info.ModTime())
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// Serves the indexer's progress as JSON.
+func (s *Server) serveIndexStatus(w http.ResponseWriter, r *http.Request) {
+	data, err := json.MarshalIndent(s.index.status(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s\n", data)
+}
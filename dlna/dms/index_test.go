@@ -0,0 +1,111 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/anacrolix/log"
+)
+
+// Counts directory listings, and stops the crawl after a number of them.
+type countingBackend struct {
+	memBackend
+	mu        sync.Mutex
+	listed    []string
+	stopAfter int
+	stop      chan struct{}
+}
+
+func (b *countingBackend) ReadDir(p string) ([]os.FileInfo, error) {
+	b.mu.Lock()
+	b.listed = append(b.listed, p)
+	if len(b.listed) == b.stopAfter {
+		close(b.stop)
+	}
+	b.mu.Unlock()
+	return b.memBackend.ReadDir(p)
+}
+
+func testIndex(b Backend, file string) *mediaIndex {
+	mi := newMediaIndex(b, newProber(b), newMetadataStore(b), newIgnorer(b, newListingCache(b), "/", false, nil), "/", file, log.Default)
+	mi.requestInterval = 0
+	if err := mi.load(); err != nil {
+		panic(err)
+	}
+	return mi
+}
+
+func TestIndexResume(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "index", "index.jsonl")
+	b := &countingBackend{
+		memBackend: memBackend{
+			"/A/1.mp4":     nil,
+			"/A/B/2.mkv":   nil,
+			"/C/3.ogg":     nil,
+			"/C/D/4.jpg":   nil,
+			"/C/D/not.txt": nil,
+		},
+		stopAfter: 3,
+		stop:      make(chan struct{}),
+	}
+	if err := testIndex(b, file).crawl(b.stop); err != errIndexStopped {
+		t.Fatal(err)
+	}
+	// Starting again lists just what wasn't.
+	b.listed, b.stopAfter = nil, 0
+	mi := testIndex(b, file)
+	if p := mi.status(); p.Gen != 1 || p.Dirs != 3 {
+		t.Fatalf("%+v", p)
+	}
+	if err := mi.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.listed) != 2 {
+		t.Fatal(b.listed)
+	}
+	if p := mi.status(); p.Gen != 1 || p.Dirs != 5 || p.Files != 4 || p.Completed.IsZero() {
+		t.Fatalf("%+v", p)
+	}
+
+	// The completed index is loaded whole, with its metadata.
+	mi = testIndex(b, file)
+	if len(mi.all()) != 4 || mi.all()[0].Path != "/A/1.mp4" {
+		t.Fatal(mi.all())
+	}
+	if _, ok := mi.prober.cached("/A/1.mp4", mi.all()[0].Info); !ok {
+		t.Fatal("metadata not loaded")
+	}
+	data, _ := os.ReadFile(file)
+	if n := bytes.Count(data, []byte("\n")); n != 6 {
+		t.Fatalf("%d lines:\n%s", n, data)
+	}
+
+	// The next crawl forgets what's gone.
+	delete(b.memBackend, "/C/D/4.jpg")
+	delete(b.memBackend, "/C/D/not.txt")
+	if err := mi.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	if p := mi.status(); p.Gen != 2 || p.Dirs != 4 || p.Files != 3 {
+		t.Fatalf("%+v", p)
+	}
+}
+
+func TestIndexStatus(t *testing.T) {
+	s := newTestServer(memBackend{"/a.mp4": nil})
+	s.index.requestInterval = 0
+	if err := s.index.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", "/index", nil))
+	var p indexProgress
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil || p.Files != 1 || p.Crawling {
+		t.Fatal(rec.Body.String(), err)
+	}
+}
@@ -0,0 +1,86 @@
+package dms
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long directory listings are reused for sidecar lookups.
+const listingTTL = 10 * time.Minute
+
+// A directory listing, for finding files that accompany others.
+type dirListing struct {
+	fis []os.FileInfo
+	// Keyed by lower case name.
+	byName  map[string]os.FileInfo
+	expires time.Time
+}
+
+// Returns the path and info of the first file in names that's present. Names
+// must be lower case.
+func (dl *dirListing) find(dir string, names []string) (string, os.FileInfo) {
+	for _, name := range names {
+		if fi, ok := dl.byName[name]; ok && !fi.IsDir() {
+			return path.Join(dir, fi.Name()), fi
+		}
+	}
+	return "", nil
+}
+
+// Remembers recent directory listings, so that sidecar files can be found
+// without listing their directory again.
+type listingCache struct {
+	backend Backend
+	mu      sync.Mutex
+	dirs    map[string]*dirListing
+}
+
+func newListingCache(backend Backend) *listingCache {
+	return &listingCache{
+		backend: backend,
+		dirs:    make(map[string]*dirListing),
+	}
+}
+
+// Records a listing of dir that was made anyway.
+func (lc *listingCache) note(dir string, fis []os.FileInfo) *dirListing {
+	dl := &dirListing{
+		fis:     fis,
+		byName:  make(map[string]os.FileInfo, len(fis)),
+		expires: time.Now().Add(listingTTL),
+	}
+	for _, fi := range fis {
+		dl.byName[strings.ToLower(fi.Name())] = fi
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.dirs[dir] = dl
+	return dl
+}
+
+// Returns the listing of dir if it's known.
+func (lc *listingCache) cached(dir string) (*dirListing, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	dl, ok := lc.dirs[dir]
+	if ok && time.Now().After(dl.expires) {
+		delete(lc.dirs, dir)
+		return nil, false
+	}
+	return dl, ok
+}
+
+// Returns the listing of dir, reading it if it's not known.
+func (lc *listingCache) get(dir string) (*dirListing, error) {
+	if dl, ok := lc.cached(dir); ok {
+		return dl, nil
+	}
+	fis, err := lc.backend.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return lc.note(dir, fis), nil
+}
@@ -0,0 +1,350 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofly/alipan-dms/nfo"
+	"github.com/gofly/alipan-dms/playlist"
+	"github.com/gofly/alipan-dms/series"
+	subs "github.com/gofly/alipan-dms/subtitle"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+const (
+	// The file in a folder that overrides the titles and order of its
+	// entries.
+	folderMetadataName = "metadata.json"
+	// Largest sidecar that's read.
+	maxSidecarSize = 1 << 20
+)
+
+// NFOs that describe the folder they're in, in order of preference. Matched
+// case-insensitively.
+var folderNFONames = []string{"tvshow.nfo", "movie.nfo"}
+
+// A folder's metadata.json, like
+//
+//	{"titles": {"a.mkv": "The First"}, "order": ["b.mkv", "a.mkv"]}
+type folderMetadata struct {
+	// Titles of the folder's files and subfolders, keyed by name.
+	Titles map[string]string `json:"titles"`
+	// Names of files and subfolders in the order they're listed. The rest
+	// follow, in the order they'd otherwise be.
+	Order []string `json:"order"`
+}
+
+// Returns the entries sorted by the Order.
+func (fm *folderMetadata) sort(fis []os.FileInfo) []os.FileInfo {
+	if len(fm.Order) == 0 {
+		return fis
+	}
+	byName := make(map[string]os.FileInfo, len(fis))
+	for _, fi := range fis {
+		byName[fi.Name()] = fi
+	}
+	ret := make([]os.FileInfo, 0, len(fis))
+	for _, name := range fm.Order {
+		if fi, ok := byName[name]; ok {
+			ret = append(ret, fi)
+			delete(byName, name)
+		}
+	}
+	for _, fi := range fis {
+		if _, ok := byName[fi.Name()]; ok {
+			ret = append(ret, fi)
+		}
+	}
+	return ret
+}
+
+// Returns the NFO of the video at filePath: one with the same base name, or
+// else the folder's movie.nfo.
+func videoNFO(dl *dirListing, filePath string) (string, os.FileInfo) {
+	base := strings.ToLower(path.Base(filePath))
+	base = strings.TrimSuffix(base, path.Ext(base))
+	return dl.find(path.Dir(filePath), []string{base + ".nfo", "movie.nfo"})
+}
+
+// Reads NFO and metadata.json sidecars, playlists, and the files that link to
+// streams, through the backend, and remembers them. Files that can't be
+// parsed are remembered as such until they change.
+type metadataStore struct {
+	backend Backend
+	mu      sync.Mutex
+	// Keyed by fileKey, nil if unreadable.
+	nfos      map[string]*nfo.Info
+	folders   map[string]*folderMetadata
+	playlists map[string][]playlist.Entry
+	// Empty if unreadable.
+	streams map[string]string
+}
+
+func newMetadataStore(backend Backend) *metadataStore {
+	return &metadataStore{
+		backend:   backend,
+		nfos:      make(map[string]*nfo.Info),
+		folders:   make(map[string]*folderMetadata),
+		playlists: make(map[string][]playlist.Entry),
+		streams:   make(map[string]string),
+	}
+}
+
+// Reads a small file that accompanies others.
+func readSidecar(backend Backend, filePath string, fi os.FileInfo) ([]byte, error) {
+	if fi.Size() > maxSidecarSize {
+		return nil, fmt.Errorf("%s is too large", filePath)
+	}
+	rc, err := backend.ReadStream(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxSidecarSize))
+}
+
+// Returns the NFO at filePath, reading it if it's not known and read is set.
+func (ms *metadataStore) nfo(filePath string, fi os.FileInfo, read bool) (*nfo.Info, bool) {
+	key := fileKey(filePath, fi)
+	ms.mu.Lock()
+	info, ok := ms.nfos[key]
+	ms.mu.Unlock()
+	if ok || !read {
+		return info, ok
+	}
+	data, err := readSidecar(ms.backend, filePath, fi)
+	if err == nil {
+		info, err = nfo.Parse(bytes.NewReader(data))
+	}
+	if err != nil {
+		info = nil
+	}
+	ms.mu.Lock()
+	ms.nfos[key] = info
+	ms.mu.Unlock()
+	return info, true
+}
+
+// Returns the metadata.json at filePath, reading it if it's not known and
+// read is set.
+func (ms *metadataStore) folder(filePath string, fi os.FileInfo, read bool) (*folderMetadata, bool) {
+	key := fileKey(filePath, fi)
+	ms.mu.Lock()
+	fm, ok := ms.folders[key]
+	ms.mu.Unlock()
+	if ok || !read {
+		return fm, ok
+	}
+	data, err := readSidecar(ms.backend, filePath, fi)
+	if err == nil {
+		fm = new(folderMetadata)
+		err = json.Unmarshal(data, fm)
+	}
+	if err != nil {
+		fm = nil
+	}
+	ms.mu.Lock()
+	ms.folders[key] = fm
+	ms.mu.Unlock()
+	return fm, true
+}
+
+// Returns the entries of the playlist at filePath, reading it if it's not
+// known and read is set. The entries of a playlist that's empty aren't nil.
+func (ms *metadataStore) playlist(filePath string, fi os.FileInfo, read bool) ([]playlist.Entry, bool) {
+	key := fileKey(filePath, fi)
+	ms.mu.Lock()
+	entries, ok := ms.playlists[key]
+	ms.mu.Unlock()
+	if ok || !read {
+		return entries, ok
+	}
+	data, err := readSidecar(ms.backend, filePath, fi)
+	if err == nil {
+		// Old M3Us are often in a legacy encoding.
+		text, _ := subs.DecodeText(data)
+		entries, err = playlist.Parse(text, playlist.Format(filePath))
+	}
+	if err == nil && entries == nil {
+		entries = []playlist.Entry{}
+	} else if err != nil {
+		entries = nil
+	}
+	ms.mu.Lock()
+	ms.playlists[key] = entries
+	ms.mu.Unlock()
+	return entries, true
+}
+
+// Returns the URL the .strm or .url file at filePath links to, reading it if
+// it's not known and read is set.
+func (ms *metadataStore) stream(filePath string, fi os.FileInfo, read bool) (string, bool) {
+	key := fileKey(filePath, fi)
+	ms.mu.Lock()
+	u, ok := ms.streams[key]
+	ms.mu.Unlock()
+	if ok || !read {
+		return u, ok
+	}
+	data, err := readSidecar(ms.backend, filePath, fi)
+	if err == nil {
+		text, _ := subs.DecodeText(data)
+		u, _ = playlist.ParseStream(text, playlist.StreamFormat(filePath))
+	}
+	ms.mu.Lock()
+	ms.streams[key] = u
+	ms.mu.Unlock()
+	return u, true
+}
+
+// Reads the sidecars, playlists and stream links in a directory listing that
+// aren't already known, waiting at most budget. Reads that miss it finish in
+// the background.
+func (ms *metadataStore) loadAll(dir string, dl *dirListing, budget time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, probeConcurrency)
+		for _, fi := range dl.fis {
+			name := strings.ToLower(fi.Name())
+			var read func(string, os.FileInfo)
+			switch {
+			case fi.IsDir():
+				continue
+			case strings.HasSuffix(name, ".nfo"):
+				read = func(p string, fi os.FileInfo) { ms.nfo(p, fi, true) }
+			case name == folderMetadataName:
+				read = func(p string, fi os.FileInfo) { ms.folder(p, fi, true) }
+			case playlist.Format(name) != "":
+				read = func(p string, fi os.FileInfo) { ms.playlist(p, fi, true) }
+			case playlist.StreamFormat(name) != "":
+				read = func(p string, fi os.FileInfo) { ms.stream(p, fi, true) }
+			default:
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(p string, fi os.FileInfo) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				read(p, fi)
+			}(path.Join(dir, fi.Name()), fi)
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(budget):
+	}
+}
+
+// Returns the metadata.json of a directory, if its listing and the file are
+// known.
+func (s *Server) folderMetadata(dir string) *folderMetadata {
+	dl, ok := s.listings.cached(dir)
+	if !ok {
+		return nil
+	}
+	p, fi := dl.find(dir, []string{folderMetadataName})
+	if p == "" {
+		return nil
+	}
+	fm, _ := s.metadata.folder(p, fi, false)
+	return fm
+}
+
+// Returns the title the parent folder's metadata.json gives a file or
+// folder.
+func (s *Server) metadataTitle(filePath string) (string, bool) {
+	fm := s.folderMetadata(path.Dir(filePath))
+	if fm == nil {
+		return "", false
+	}
+	title, ok := fm.Titles[path.Base(filePath)]
+	return title, ok && title != ""
+}
+
+// Returns the known NFO found by find in the listing of dir.
+func (s *Server) cachedNFO(dir string, find func(*dirListing) (string, os.FileInfo)) *nfo.Info {
+	dl, ok := s.listings.cached(dir)
+	if !ok {
+		return nil
+	}
+	p, fi := find(dl)
+	if p == "" {
+		return nil
+	}
+	info, _ := s.metadata.nfo(p, fi, false)
+	return info
+}
+
+// Sets what an NFO says of the object, besides its title.
+func applyNFO(obj *upnpav.Object, info *nfo.Info) {
+	if !info.Premiered.IsZero() {
+		obj.Date = upnpav.Timestamp{Time: info.Premiered}
+	} else if info.Year != 0 {
+		obj.Date = upnpav.Timestamp{Time: yearDate(info.Year)}
+	}
+	if info.Plot != "" {
+		obj.Description = info.Plot
+	}
+	if len(info.Genres) != 0 {
+		obj.Genre = strings.Join(info.Genres, ", ")
+	}
+	obj.Actors = info.Actors
+	if info.Rating != 0 {
+		obj.Rating = fmt.Sprintf("%.1f", info.Rating)
+	}
+}
+
+// Applies the sidecar metadata of a folder: its tvshow.nfo or movie.nfo, and
+// its title in its parent's metadata.json.
+func (s *Server) applyFolderMetadata(obj *upnpav.Object, filePath string, rp *rendererProfile) {
+	if info := s.cachedNFO(filePath, func(dl *dirListing) (string, os.FileInfo) {
+		return dl.find(filePath, folderNFONames)
+	}); info != nil {
+		applyNFO(obj, info)
+		if info.Title != "" {
+			obj.Title = rp.title(info.Title)
+		}
+	}
+	if title, ok := s.metadataTitle(filePath); ok {
+		obj.Title = rp.title(title)
+	}
+}
+
+// Applies the sidecar metadata of a video: its NFO, which can make it an
+// episode, and its title in its folder's metadata.json.
+func (s *Server) applyVideoMetadata(item *upnpav.Item, filePath string, rp *rendererProfile) {
+	if info := s.cachedNFO(path.Dir(filePath), func(dl *dirListing) (string, os.FileInfo) {
+		return videoNFO(dl, filePath)
+	}); info != nil {
+		applyNFO(&item.Object, info)
+		if info.Kind == nfo.Episode && info.Episode != 0 {
+			ep := series.Episode{Show: item.SeriesTitle, Season: info.Season, Episode: info.Episode, Title: info.Title}
+			if info.ShowTitle != "" {
+				ep.Show = info.ShowTitle
+			}
+			if ep.Season == 0 {
+				ep.Season = 1
+			}
+			if ep.Show != "" {
+				item.Title = rp.title(episodeTitle(ep))
+				item.SeriesTitle, item.EpisodeSeason, item.EpisodeNumber = ep.Show, ep.Season, ep.Episode
+			}
+		} else if info.Title != "" {
+			item.Title = rp.title(info.Title)
+		}
+	}
+	if title, ok := s.metadataTitle(filePath); ok {
+		item.Title = rp.title(title)
+	}
+}
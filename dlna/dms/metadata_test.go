@@ -0,0 +1,73 @@
+package dms
+
+import (
+	"testing"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func TestSidecarMetadata(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Movies/Heat/heat.1995.mkv": nil,
+		"/Movies/Heat/heat.1995.nfo": []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<movie>
+  <title>Heat</title>
+  <premiered>1995-12-15</premiered>
+  <plot>A group of professional bank robbers.</plot>
+  <genre>Crime</genre>
+  <genre>Drama</genre>
+  <actor><name>Robert De Niro</name><order>1</order></actor>
+  <actor><name>Al Pacino</name><order>0</order></actor>
+  <rating>8.3</rating>
+</movie>`),
+		"/Movies/Heat/extra.mkv":   nil,
+		"/Movies/Heat/trailer.mkv": nil,
+		"/Movies/Heat/metadata.json": []byte(`{
+  "titles": {"extra.mkv": "Making Of"},
+  "order": ["trailer.mkv", "heat.1995.mkv"]
+}`),
+		"/Shows/Lost/tvshow.nfo":   []byte(`<tvshow><title>LOST</title></tvshow>`),
+		"/Shows/Lost/pilot.mkv":    nil,
+		"/Shows/Lost/pilot.nfo":    []byte(`<episodedetails><title>Pilot</title><showtitle>Lost</showtitle><season>1</season><episode>1</episode></episodedetails>`),
+		"/Shows/Lost/broken.nfo":   []byte(`<episodedetails>`),
+		"/Shows/Lost/broken.mkv":   nil,
+		"/Shows/Lost/metadata.txt": nil,
+	})
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	read := func(dir string) []upnpav.Object {
+		objs, err := cds.readContainer(object{dir, "/"}, "host", genericRenderer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ret []upnpav.Object
+		for _, obj := range objs {
+			ret = append(ret, upnpavObject(obj))
+		}
+		return ret
+	}
+
+	objs := read("/Movies/Heat")
+	if len(objs) != 3 || objs[0].Title != "trailer" || objs[1].Title != "Heat" || objs[2].Title != "Making Of" {
+		t.Fatal(objs)
+	}
+	heat := objs[1]
+	if heat.Date.Format("2006-01-02") != "1995-12-15" || heat.Description != "A group of professional bank robbers." ||
+		heat.Genre != "Crime, Drama" || heat.Rating != "8.3" ||
+		len(heat.Actors) != 2 || heat.Actors[0] != "Al Pacino" {
+		t.Fatal(heat)
+	}
+
+	objs = read("/Shows/Lost")
+	for _, obj := range objs {
+		if obj.ID == "%2FShows%2FLost%2Fpilot.mkv" && obj.Title != "Lost S01E01 Pilot" {
+			t.Fatal(obj.Title)
+		}
+		if obj.ID == "%2FShows%2FLost%2Fbroken.mkv" && obj.Title != "broken" {
+			t.Fatal(obj.Title)
+		}
+	}
+	// The show's folder is described by its tvshow.nfo once it's been listed.
+	if objs = read("/Shows"); len(objs) != 1 || objs[0].Title != "LOST" {
+		t.Fatal(objs)
+	}
+}
@@ -24,6 +24,11 @@ func init() {
 	if err := mime.AddExtensionType(".ogg", "audio/ogg"); err != nil {
 		log.Printf("Could not register audio/ogg MIME type: %s", err)
 	}
+	for _, ext := range []string{".ts", ".m2ts", ".mts"} {
+		if err := mime.AddExtensionType(ext, "video/mp2t"); err != nil {
+			log.Printf("Could not register video/mp2t MIME type: %s", err)
+		}
+	}
 }
 
 // Example: "video/mpeg"
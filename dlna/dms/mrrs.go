@@ -1,27 +1,85 @@
 package dms
 
 import (
+	"encoding/xml"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gofly/alipan-dms/upnp"
 )
 
+// Receivers ask whether they're authorized over and over, so the answers are
+// logged at most this often.
+const mediaReceiverLogInterval = time.Minute
+
 type mediaReceiverRegistrarService struct {
 	*Server
 	upnp.Eventing
+	mu sync.Mutex
+	// When an answer was last logged.
+	logged time.Time
+}
+
+// Arguments of IsAuthorized and IsValidated. A nil DeviceID wasn't given.
+type mediaReceiverDevice struct {
+	DeviceID *string
+}
+
+type registerDevice struct {
+	RegistrationReqMsg *string
+}
+
+// Returns whether the device may browse the server. An empty DeviceID asks
+// about the server as a whole.
+func (mrrs *mediaReceiverRegistrarService) authorized(deviceID string) bool {
+	if deviceID == "" || len(mrrs.MediaReceivers) == 0 {
+		return true
+	}
+	for _, id := range mrrs.MediaReceivers {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+func (mrrs *mediaReceiverRegistrarService) shouldLog(now time.Time) bool {
+	mrrs.mu.Lock()
+	defer mrrs.mu.Unlock()
+	if now.Sub(mrrs.logged) < mediaReceiverLogInterval {
+		return false
+	}
+	mrrs.logged = now
+	return true
 }
 
 func (mrrs *mediaReceiverRegistrarService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
 	switch action {
 	case "IsAuthorized", "IsValidated":
+		var args mediaReceiverDevice
+		if err := xml.Unmarshal(argsXML, &args); err != nil || args.DeviceID == nil {
+			return nil, upnp.Errorf(upnp.InvalidArgsErrorCode, "Invalid Args")
+		}
+		ok := mrrs.authorized(*args.DeviceID)
+		if *args.DeviceID != "" && mrrs.shouldLog(time.Now()) {
+			mrrs.Logger.Printf("media receiver %q at %s authorized: %v", *args.DeviceID, r.RemoteAddr, ok)
+		}
+		result := "0"
+		if ok {
+			result = "1"
+		}
 		return [][2]string{
-			{"Result", "1"},
+			{"Result", result},
 		}, nil
 	case "RegisterDevice":
+		var args registerDevice
+		if err := xml.Unmarshal(argsXML, &args); err != nil || args.RegistrationReqMsg == nil {
+			return nil, upnp.Errorf(upnp.InvalidArgsErrorCode, "Invalid Args")
+		}
 		return [][2]string{
 			{"RegistrationRespMsg", mrrs.rootDeviceUUID},
 		}, nil
-		//		return nil, nil
 	default:
 		return nil, upnp.InvalidActionError
 	}
@@ -0,0 +1,57 @@
+package dms
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofly/alipan-dms/upnp"
+)
+
+func TestMediaReceiverRegistrar(t *testing.T) {
+	s := newTestServer(memBackend{"/a.mp4": nil})
+	s.MediaReceivers = []string{"xbox-1"}
+	mrrs := s.services["X_MS_MediaReceiverRegistrar"]
+	r := httptest.NewRequest("POST", "/ctl", nil)
+	for _, tc := range []struct {
+		action, deviceID, result string
+	}{
+		{"IsAuthorized", "", "1"},
+		{"IsAuthorized", "xbox-1", "1"},
+		{"IsValidated", "xbox-1", "1"},
+		{"IsAuthorized", "xbox-2", "0"},
+		{"IsValidated", "xbox-2", "0"},
+	} {
+		args := "<u:" + tc.action + "><DeviceID>" + tc.deviceID + "</DeviceID></u:" + tc.action + ">"
+		resp, err := mrrs.Handle(tc.action, []byte(args), r)
+		if err != nil || len(resp) != 1 || resp[0] != [2]string{"Result", tc.result} {
+			t.Errorf("%s %q: %v %v", tc.action, tc.deviceID, resp, err)
+		}
+	}
+	_, err := mrrs.Handle("IsAuthorized", []byte("<u:IsAuthorized></u:IsAuthorized>"), r)
+	if e, ok := err.(*upnp.Error); !ok || e.Code != upnp.InvalidArgsErrorCode {
+		t.Fatal(err)
+	}
+	resp, err := mrrs.Handle("RegisterDevice", []byte("<u:RegisterDevice><RegistrationReqMsg>AAEC</RegistrationReqMsg></u:RegisterDevice>"), r)
+	if err != nil || resp[0] != [2]string{"RegistrationRespMsg", s.rootDeviceUUID} {
+		t.Fatal(resp, err)
+	}
+}
+
+func TestMediaReceiverLogging(t *testing.T) {
+	var mrrs mediaReceiverRegistrarService
+	now := time.Now()
+	for _, tc := range []struct {
+		after time.Duration
+		log   bool
+	}{
+		{0, true},
+		{time.Second, false},
+		{mediaReceiverLogInterval - time.Second, false},
+		{mediaReceiverLogInterval, true},
+	} {
+		if got := mrrs.shouldLog(now.Add(tc.after)); got != tc.log {
+			t.Errorf("after %s: %v", tc.after, got)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package dms
+
+// Containers Windows Media Player and the Xbox browse by well-known IDs,
+// rather than finding them in the root. They're found by searching the root.
+var msContainers = map[string]virtualContainer{
+	"1":  {title: "Music", parentID: "0", children: []string{"4"}},
+	"2":  {title: "Video", parentID: "0", children: []string{"8"}},
+	"3":  {title: "Pictures", parentID: "0", children: []string{"B"}},
+	"4":  {title: "All Music", parentID: "1", itemClass: "object.item.audioItem"},
+	"8":  {title: "All Video", parentID: "2", itemClass: "object.item.videoItem"},
+	"B":  {title: "All Pictures", parentID: "3", itemClass: "object.item.imageItem"},
+	"15": {title: "Videos", parentID: "0", itemClass: "object.item.videoItem"},
+	"16": {title: "Pictures", parentID: "0", itemClass: "object.item.imageItem"},
+}
@@ -0,0 +1,262 @@
+package dms
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gofly/alipan-dms/dlna"
+	"github.com/gofly/alipan-dms/playlist"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+// Serves a generated M3U of a folder's items, if FolderPlaylists is set.
+const folderPlaylistPath = "/playlist.m3u"
+
+const m3uMimeType = "audio/x-mpegurl"
+
+var errNotPlaylist = errors.New("not a playlist")
+
+// Returns the object a playlist entry refers to by path, relative to the
+// playlist's object, or false if it refers to something outside the root, or
+// on another drive.
+func playlistEntryObject(pl object, location string) (object, bool) {
+	p := strings.ReplaceAll(location, `\`, "/")
+	if strings.Contains(p, ":") {
+		// Like "C:/Music/a.mp3", or "file:///".
+		return object{}, false
+	}
+	if !path.IsAbs(p) {
+		p = path.Join(path.Dir(pl.Path), p)
+	}
+	p = path.Clean(p)
+	if strings.HasPrefix(p, "/..") {
+		return object{}, false
+	}
+	return object{p, pl.RootObjectPath}, true
+}
+
+// Returns the file or folder an entry refers to, if it's found in the
+// listing that list returns of its directory.
+func playlistEntryFile(pl object, location string, list func(dir string) (*dirListing, bool)) (object, os.FileInfo, bool) {
+	o, ok := playlistEntryObject(pl, location)
+	if !ok {
+		return o, nil, false
+	}
+	dl, ok := list(path.Dir(o.FilePath()))
+	if !ok {
+		return o, nil, false
+	}
+	fi, ok := dl.byName[strings.ToLower(path.Base(o.Path))]
+	if !ok {
+		return o, nil, false
+	}
+	// Use the name's case in the backend.
+	o.Path = path.Join(path.Dir(o.Path), fi.Name())
+	return o, fi, true
+}
+
+// Returns the container of a playlist file. Nil is returned if the file is
+// known not to be a playlist of tracks.
+func (s *contentDirectoryService) playlistContainer(o object, fi os.FileInfo, host string, rp *rendererProfile) interface{} {
+	entries, known := s.metadata.playlist(o.FilePath(), fi, false)
+	if known && entries == nil {
+		return nil
+	}
+	title, _ := s.objectTitle(o, fi)
+	c := upnpav.Container{Object: upnpav.Object{
+		ID:         o.ID(),
+		ParentID:   o.ParentID(),
+		Restricted: 1,
+		Class:      "object.container.playlistContainer",
+		Title:      rp.title(title),
+		Date:       upnpav.Timestamp{Time: fi.ModTime()},
+	}}
+	if s.FolderPlaylists {
+		c.Res = append(c.Res, s.folderPlaylistRes(o, host))
+	}
+	if !known {
+		return c
+	}
+	// Counted if the directories of the entries have been listed.
+	n := 0
+	for _, e := range entries {
+		if playlist.IsURL(e.Location) {
+			if _, ok := playlistEntryURL(e.Location); ok {
+				n++
+			}
+			continue
+		}
+		eo, ok := playlistEntryObject(o, e.Location)
+		if !ok {
+			continue
+		}
+		dl, ok := s.listings.cached(path.Dir(eo.FilePath()))
+		if !ok {
+			return c
+		}
+		if _, ok := dl.byName[strings.ToLower(path.Base(eo.Path))]; ok {
+			n++
+		}
+	}
+	c.ChildCount = &n
+	return c
+}
+
+// Returns the URL of a playlist entry, if it's one renderers can be sent to:
+// HTTP directly, or HTTPS through the relay.
+func playlistEntryURL(location string) (*url.URL, bool) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u, true
+	}
+	return nil, false
+}
+
+// Returns the item of a playlist entry that's a URL. Renderers are sent to
+// HTTP URLs directly, and HTTPS ones through the relay, as they often can't
+// fetch those themselves.
+func (s *contentDirectoryService) playlistURLItem(pl object, i int, e playlist.Entry, host string, rp *rendererProfile) (upnpav.Item, bool) {
+	u, ok := playlistEntryURL(e.Location)
+	if !ok {
+		return upnpav.Item{}, false
+	}
+	mt := mimeType(mime.TypeByExtension(path.Ext(u.Path)))
+	if !mt.IsMedia() {
+		// Most often a radio station.
+		mt = "audio/mpeg"
+	}
+	title := e.Title
+	if title == "" {
+		title = path.Base(u.Path)
+	}
+	res := upnpav.Resource{
+		URL:          e.Location,
+		ProtocolInfo: fmt.Sprintf("http-get:*:%s:*", rp.mimeType(mt)),
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		res.URL = s.streamURL(pl, host, url.Values{"entry": {strconv.Itoa(i)}})
+	}
+	if e.Duration > 0 {
+		res.Duration = dlna.FormatNPTTime(e.Duration)
+	}
+	return upnpav.Item{
+		Object: upnpav.Object{
+			ID:         pl.ID() + "#" + strconv.Itoa(i),
+			ParentID:   pl.ID(),
+			Restricted: 1,
+			Class:      "object.item." + mt.Type() + "Item",
+			Title:      rp.title(title),
+		},
+		Res: []upnpav.Resource{res},
+	}, true
+}
+
+// Returns the objects of the entries of a playlist that are found. Entries
+// that are paths are resolved through the backend.
+func (s *contentDirectoryService) readPlaylist(o object, host string, rp *rendererProfile) (ret []interface{}, err error) {
+	dl, err := s.listings.get(path.Dir(o.FilePath()))
+	if err != nil {
+		return
+	}
+	fi, ok := dl.byName[strings.ToLower(path.Base(o.Path))]
+	if !ok || fi.IsDir() || playlist.Format(o.Path) == "" {
+		return nil, errNotPlaylist
+	}
+	if ignored, err := s.IgnorePath(o.FilePath(), fi); err != nil || ignored {
+		return nil, os.ErrNotExist
+	}
+	entries, _ := s.metadata.playlist(o.FilePath(), fi, true)
+	if entries == nil {
+		return nil, errNotPlaylist
+	}
+	list := func(dir string) (*dirListing, bool) {
+		dl, err := s.listings.get(dir)
+		return dl, err == nil
+	}
+	for i, e := range entries {
+		if playlist.IsURL(e.Location) {
+			if item, ok := s.playlistURLItem(o, i, e, host, rp); ok {
+				ret = append(ret, item)
+			}
+			continue
+		}
+		child, fi, ok := playlistEntryFile(o, e.Location, list)
+		if !ok {
+			continue
+		}
+		obj, err := s.cdsObjectToUpnpavObject(child, fi, host, rp)
+		if err != nil || obj == nil {
+			continue
+		}
+		if item, ok := obj.(upnpav.Item); ok {
+			item.ParentID = o.ID()
+			if e.Title != "" {
+				item.Title = rp.title(e.Title)
+			}
+			obj = item
+		}
+		ret = append(ret, obj)
+	}
+	return
+}
+
+// Returns the resource of the generated M3U of a folder or playlist.
+func (s *Server) folderPlaylistRes(o object, host string) upnpav.Resource {
+	return upnpav.Resource{
+		URL: (&url.URL{
+			Scheme:   "http",
+			Host:     host,
+			Path:     folderPlaylistPath,
+			RawQuery: url.Values{"path": {o.Path}}.Encode(),
+		}).String(),
+		ProtocolInfo: "http-get:*:" + m3uMimeType + ":*",
+	}
+}
+
+// Serves an M3U of the items in a folder or playlist, as the renderer would
+// browse them.
+func (s *Server) serveFolderPlaylist(w http.ResponseWriter, r *http.Request) {
+	if !s.FolderPlaylists {
+		http.NotFound(w, r)
+		return
+	}
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	o := object{path.Clean("/" + r.URL.Query().Get("path")), s.RootObjectPath}
+	rp := s.renderer(r.Header)
+	var objs []interface{}
+	var err error
+	if playlist.Format(o.Path) != "" {
+		objs, err = cds.readPlaylist(o, r.Host, rp)
+	} else {
+		objs, err = cds.readContainer(o, r.Host, rp)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var entries []playlist.Entry
+	for _, obj := range objs {
+		item, ok := obj.(upnpav.Item)
+		if !ok || len(item.Res) == 0 {
+			continue
+		}
+		e := playlist.Entry{Location: item.Res[0].URL, Title: item.Title}
+		if d, err := dlna.ParseNPTTime(item.Res[0].Duration); err == nil {
+			e.Duration = d
+		}
+		entries = append(entries, e)
+	}
+	w.Header().Set("Content-Type", m3uMimeType+"; charset=utf-8")
+	w.Write(playlist.WriteM3U(entries))
+}
@@ -0,0 +1,85 @@
+package dms
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func TestPlaylists(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Music/Album/01.mp3": nil,
+		"/Music/Album/02.mp3": nil,
+		"/Music/Mix.m3u": []byte("#EXTM3U\n#EXTINF:200,First Song\nAlbum\\01.MP3\n" +
+			"missing.mp3\n/Music/Album/02.mp3\n#EXTINF:-1,Radio\nhttp://radio.example.com/stream\n" +
+			"https://radio.example.com/secure.aac\nrtsp://radio.example.com/live\n"),
+		"/Music/Best.pls":  []byte("[playlist]\nFile1=Album/02.mp3\nTitle1=Second\nNumberOfEntries=1\n"),
+		"/Music/Live.m3u8": []byte("#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10,\nseg0.ts\n"),
+	})
+	s.FolderPlaylists = true
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	objs, err := cds.readContainer(object{"/Music", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlists := make(map[string]upnpav.Container)
+	for _, obj := range objs {
+		c := obj.(upnpav.Container)
+		playlists[c.Title] = c
+	}
+	// HLS playlists aren't playlists of tracks.
+	if _, ok := playlists["Live"]; ok || len(playlists) != 3 || playlists["Mix"].Class != "object.container.playlistContainer" || playlists["Mix"].Searchable != 0 {
+		t.Fatal(objs)
+	}
+	if c := playlists["Mix"]; c.ChildCount == nil || *c.ChildCount != 4 || len(c.Res) != 1 || !strings.Contains(c.Res[0].URL, "/playlist.m3u?path=%2FMusic%2FMix.m3u") {
+		t.Fatal(c)
+	}
+
+	objs, err = cds.readPlaylist(object{"/Music/Mix.m3u", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 4 {
+		t.Fatal(objs)
+	}
+	first, second, radio, secure := objs[0].(upnpav.Item), objs[1].(upnpav.Item), objs[2].(upnpav.Item), objs[3].(upnpav.Item)
+	if first.Title != "First Song" || first.ID != "%2FMusic%2FAlbum%2F01.mp3" || first.ParentID != "%2FMusic%2FMix.m3u" {
+		t.Fatal(first)
+	}
+	if second.Title != "02" {
+		t.Fatal(second)
+	}
+	if radio.Title != "Radio" || radio.Class != "object.item.audioItem" || radio.Res[0].URL != "http://radio.example.com/stream" {
+		t.Fatal(radio)
+	}
+	// HTTPS entries are relayed.
+	if secure.Res[0].URL != "http://host/stream?entry=4&path=%2FMusic%2FMix.m3u" || !strings.HasPrefix(secure.Res[0].ProtocolInfo, "http-get:*:audio/") {
+		t.Fatal(secure)
+	}
+	// Only entries that are URLs are.
+	for _, target := range []string{"/stream?entry=0&path=%2FMusic%2FMix.m3u", "/stream?entry=9&path=%2FMusic%2FMix.m3u", "/stream?path=%2FMusic%2FMix.m3u"} {
+		rec := httptest.NewRecorder()
+		s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != 404 {
+			t.Fatal(target, rec.Code)
+		}
+	}
+	if _, err := cds.readPlaylist(object{"/Music/Live.m3u8", "/"}, "host", genericRenderer); err == nil {
+		t.Fatal("HLS playlist read")
+	}
+	objs, err = cds.readPlaylist(object{"/Music/Best.pls", "/"}, "host", genericRenderer)
+	if err != nil || len(objs) != 1 || upnpavObject(objs[0]).Title != "Second" {
+		t.Fatal(objs, err)
+	}
+
+	req := httptest.NewRequest("GET", "/playlist.m3u?path=%2FMusic%2FAlbum", nil)
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, req)
+	if rec.Code != 200 || rec.Body.String() != "#EXTM3U\n"+
+		"#EXTINF:-1,01\nhttp://example.com/res?path=%2FMusic%2FAlbum%2F01.mp3\n"+
+		"#EXTINF:-1,02\nhttp://example.com/res?path=%2FMusic%2FAlbum%2F02.mp3\n" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,228 @@
+package dms
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofly/alipan-dms/dlna"
+	"github.com/gofly/alipan-dms/exif"
+	"github.com/gofly/alipan-dms/id3"
+	"github.com/gofly/alipan-dms/mp4"
+	"github.com/gofly/alipan-dms/mpegts"
+)
+
+const (
+	// How much of a JPEG is read looking for its EXIF header and frame size.
+	exifProbeSize = 256 << 10
+	// Concurrent probes while listing a directory.
+	probeConcurrency = 8
+	// How long a directory listing waits for probes before falling back to
+	// what's known. Probes that miss the budget finish in the background.
+	probeBudget = 3 * time.Second
+)
+
+// Metadata read from the contents of a file.
+type mediaInfo struct {
+	// When a photo was taken.
+	Taken time.Time
+	// EXIF orientation, zero if unknown.
+	Orientation int
+	// Stored dimensions.
+	Width, Height int
+	// Whether an MP4 or MP3 carries its own picture.
+	HasCover bool
+	// Of a video, zero if unknown.
+	Duration time.Duration
+}
+
+// Returns the duration as used in res@duration.
+func (mi mediaInfo) duration() string {
+	if mi.Duration <= 0 {
+		return ""
+	}
+	return dlna.FormatNPTTime(mi.Duration)
+}
+
+// Returns the resolution as used in res@resolution, as the image is displayed.
+func (mi mediaInfo) Resolution() string {
+	w, h := mi.displaySize()
+	if w == 0 || h == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", w, h)
+}
+
+// Returns the dimensions of the image as it's displayed.
+func (mi mediaInfo) displaySize() (int, int) {
+	if mi.Orientation >= 5 {
+		return mi.Height, mi.Width
+	}
+	return mi.Width, mi.Height
+}
+
+// Returns a key that changes when the file does.
+func fileKey(filePath string, fi os.FileInfo) string {
+	return fmt.Sprintf("%s\x00%d\x00%d", filePath, fi.Size(), fi.ModTime().UnixNano())
+}
+
+// Returns whether metadata can be probed from files of the MIME-type.
+func probeable(mt mimeType) bool {
+	return mt == "image/jpeg" || isMP4(mt) || isMPEGTS(mt) || hasEmbeddedArt(mt)
+}
+
+func isMP4(mt mimeType) bool {
+	switch mt {
+	case "video/mp4", "video/quicktime", "video/x-m4v":
+		return true
+	}
+	return false
+}
+
+// Returns whether files of the MIME-type may carry their own picture.
+func hasEmbeddedArt(mt mimeType) bool {
+	switch mt {
+	case "audio/mpeg", "audio/mp4", "audio/x-m4a":
+		return true
+	}
+	return isMP4(mt)
+}
+
+type probeFile struct {
+	path     string
+	fi       os.FileInfo
+	mimeType mimeType
+}
+
+// Reads metadata from file headers using range reads, and remembers it.
+type prober struct {
+	backend Backend
+	mu      sync.Mutex
+	infos   map[string]mediaInfo
+}
+
+func newProber(backend Backend) *prober {
+	return &prober{
+		backend: backend,
+		infos:   make(map[string]mediaInfo),
+	}
+}
+
+func (p *prober) cached(filePath string, fi os.FileInfo) (mediaInfo, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mi, ok := p.infos[fileKey(filePath, fi)]
+	return mi, ok
+}
+
+// Records metadata known from elsewhere, like the index.
+func (p *prober) remember(filePath string, fi os.FileInfo, mi mediaInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.infos[fileKey(filePath, fi)] = mi
+}
+
+// Probes the file. Failures are remembered as empty metadata, so they aren't
+// retried until the file changes.
+func (p *prober) probe(f probeFile) (mi mediaInfo, err error) {
+	if mi, ok := p.cached(f.path, f.fi); ok {
+		return mi, nil
+	}
+	switch {
+	case f.mimeType == "image/jpeg":
+		mi, err = p.probeJPEG(f.path)
+	case isMP4(f.mimeType), f.mimeType == "audio/mp4", f.mimeType == "audio/x-m4a":
+		mi, err = p.probeMP4(f.path, f.fi.Size())
+	case f.mimeType == "audio/mpeg":
+		mi, err = p.probeID3(f.path)
+	case isMPEGTS(f.mimeType):
+		mi, err = p.probeTS(f.path, f.fi.Size())
+	}
+	p.mu.Lock()
+	p.infos[fileKey(f.path, f.fi)] = mi
+	p.mu.Unlock()
+	return
+}
+
+func (p *prober) probeJPEG(filePath string) (mi mediaInfo, err error) {
+	rc, err := p.backend.ReadStreamRange(filePath, 0, exifProbeSize)
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	info, err := exif.DecodeJPEG(rc)
+	if info == nil {
+		return
+	}
+	mi = mediaInfo{
+		Taken:       info.DateTimeOriginal,
+		Orientation: info.Orientation,
+		Width:       info.Width,
+		Height:      info.Height,
+	}
+	return
+}
+
+func (p *prober) probeMP4(filePath string, size int64) (mi mediaInfo, err error) {
+	ra := newBackendReaderAt(p.backend, filePath, size)
+	// Files without a movie header may still have cover art.
+	mi.Duration, _ = mp4.Duration(ra, size)
+	if _, err = mp4.Find(ra, size, "moov", "udta", "meta", "ilst", "covr"); err == nil {
+		mi.HasCover = true
+	} else if errors.Is(err, mp4.ErrNotFound) {
+		err = nil
+	}
+	return
+}
+
+func (p *prober) probeID3(filePath string) (mi mediaInfo, err error) {
+	rc, err := p.backend.ReadStreamRange(filePath, 0, maxArtTagSize)
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	if _, err = id3.ReadPicture(rc, maxArtTagSize); err == nil {
+		mi.HasCover = true
+	} else if errors.Is(err, id3.ErrNoTag) || errors.Is(err, id3.ErrNoPicture) {
+		err = nil
+	}
+	return
+}
+
+func (p *prober) probeTS(filePath string, size int64) (mi mediaInfo, err error) {
+	stream, err := mpegts.Open(newBackendReaderAt(p.backend, filePath, size), size)
+	if err != nil {
+		return
+	}
+	mi.Duration, err = stream.Duration()
+	return
+}
+
+// Probes files that aren't already known, waiting at most budget.
+func (p *prober) probeAll(files []probeFile, budget time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, probeConcurrency)
+		for _, f := range files {
+			if _, ok := p.cached(f.path, f.fi); ok {
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(f probeFile) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				p.probe(f)
+			}(f)
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(budget):
+	}
+}
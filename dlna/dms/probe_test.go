@@ -0,0 +1,70 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+// Returns a w x h JPEG with an EXIF segment holding orientation 6 and a
+// DateTimeOriginal.
+func testJPEGWithExif(w, h int, taken string) []byte {
+	var img bytes.Buffer
+	jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, w, h)), nil)
+	var tiff bytes.Buffer
+	be := binary.BigEndian
+	tiff.WriteString("MM\x00\x2a\x00\x00\x00\x08")
+	// IFD0: orientation and the Exif IFD pointer.
+	binary.Write(&tiff, be, uint16(2))
+	tiff.Write([]byte{0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 6, 0, 0})
+	tiff.Write([]byte{0x87, 0x69, 0, 4, 0, 0, 0, 1, 0, 0, 0, 38})
+	binary.Write(&tiff, be, uint32(0))
+	// Exif IFD at 38, with the date after it at 56.
+	binary.Write(&tiff, be, uint16(1))
+	tiff.Write([]byte{0x90, 0x03, 0, 2, 0, 0, 0, 20, 0, 0, 0, 56})
+	binary.Write(&tiff, be, uint32(0))
+	tiff.WriteString(taken + "\x00")
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var b bytes.Buffer
+	b.Write(img.Bytes()[:2])
+	b.Write([]byte{0xff, 0xe1})
+	binary.Write(&b, be, uint16(len(app1)+2))
+	b.Write(app1)
+	b.Write(img.Bytes()[2:])
+	return b.Bytes()
+}
+
+func TestPhotoExifMetadata(t *testing.T) {
+	s := newTestServer(memBackend{"/Photos/IMG_0001.jpg": testJPEGWithExif(320, 160, "2019:05:04 12:34:56")})
+	objs, err := s.services["ContentDirectory"].(*contentDirectoryService).readContainer(object{"/Photos", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := objs[0].(upnpav.Item)
+	if !item.Date.Equal(time.Date(2019, 5, 4, 12, 34, 56, 0, time.Local)) {
+		t.Fatal(item.Date)
+	}
+	// The resolution is as displayed, like the thumbnails rotated upright.
+	if item.Res[0].Resolution != "160x320" {
+		t.Fatal(item.Res[0].Resolution)
+	}
+	if item.Res[2].Resolution != "80x160" {
+		t.Fatal(item.Res[2].Resolution)
+	}
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", strings.TrimPrefix(item.Res[2].URL, "http://host"), nil))
+	cfg, err := jpeg.DecodeConfig(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 80 || cfg.Height != 160 {
+		t.Fatal(cfg)
+	}
+}
@@ -0,0 +1,273 @@
+package dms
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// The Referer the aliyundrive CDN requires of downloads.
+	defaultDownloadReferer = "https://www.aliyundrive.com/"
+	// How long signed URLs are used when they don't say when they expire.
+	signedURLTTL = 5 * time.Minute
+	// Signed URLs are resolved again this long before they expire.
+	signedURLMargin = time.Minute
+	// Times a stream is reopened after failing partway.
+	maxStreamResumes = 3
+	// How long connecting to an upstream server and waiting for its response
+	// headers may take. Response bodies, which can be long streams, aren't
+	// limited.
+	upstreamTimeout = 30 * time.Second
+)
+
+// Query parameters that give when a signed URL expires, as a Unix time.
+var signedURLExpiryParams = []string{"x-oss-expires", "Expires", "expires"}
+
+// Reads files from a WebDAV gateway that redirects downloads to signed URLs.
+// The redirects are followed here rather than by the HTTP client, so the
+// signed URLs can be reused until they expire, and requested with the headers
+// the CDN requires.
+type redirectBackend struct {
+	Backend
+	root     *url.URL
+	username string
+	password string
+	referer  string
+	client   *http.Client
+
+	mu   sync.Mutex
+	urls map[string]signedURL
+}
+
+type signedURL struct {
+	url     string
+	expires time.Time
+}
+
+// Returns whether the URL can still be used, and isn't about to expire.
+func (su signedURL) usable(now time.Time) bool {
+	return now.Before(su.expires.Add(-signedURLMargin))
+}
+
+// Returns a transport for requests to upstream servers, which gives up on
+// those that don't respond.
+func newUpstreamTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = upstreamTimeout
+	return t
+}
+
+func newRedirectBackend(webdav Backend, root *url.URL, username, password, referer string) *redirectBackend {
+	return &redirectBackend{
+		Backend:  webdav,
+		root:     root,
+		username: username,
+		password: password,
+		referer:  referer,
+		client: &http.Client{
+			// Not http.Client.Timeout, which would cut off streams.
+			Transport: newUpstreamTransport(),
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		urls: make(map[string]signedURL),
+	}
+}
+
+// Returns when a signed URL expires.
+func signedURLExpiry(u *url.URL, now time.Time) time.Time {
+	q := u.Query()
+	for _, param := range signedURLExpiryParams {
+		if sec, err := strconv.ParseInt(q.Get(param), 10, 64); err == nil && sec > 0 {
+			return time.Unix(sec, 0)
+		}
+	}
+	return now.Add(signedURLTTL)
+}
+
+func (rb *redirectBackend) webdavURL(filePath string) string {
+	u := *rb.root
+	u.Path = path.Join("/", u.Path, filePath)
+	return u.String()
+}
+
+// Returns the response to a GET of the range, from the signed URL if one is
+// known, and otherwise from the gateway.
+func (rb *redirectBackend) get(filePath string, offset, length int64) (*http.Response, error) {
+	if su, ok := rb.lookup(filePath); ok {
+		resp, err := rb.do(su.url, false, offset, length)
+		if err == nil && resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusGone {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		rb.forget(filePath)
+	}
+	resp, err := rb.do(rb.webdavURL(filePath), true, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusFound, http.StatusMovedPermanently, http.StatusSeeOther, http.StatusTemporaryRedirect:
+	default:
+		return resp, nil
+	}
+	resp.Body.Close()
+	loc, err := resp.Location()
+	if err != nil {
+		return nil, err
+	}
+	rb.remember(filePath, signedURL{loc.String(), signedURLExpiry(loc, time.Now())})
+	return rb.do(loc.String(), false, offset, length)
+}
+
+// Returns the usable signed URL of the file, forgetting it if it isn't.
+func (rb *redirectBackend) lookup(filePath string) (signedURL, bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	su, ok := rb.urls[filePath]
+	if ok && !su.usable(time.Now()) {
+		delete(rb.urls, filePath)
+		return signedURL{}, false
+	}
+	return su, ok
+}
+
+// Records the signed URL of the file, and forgets those no longer usable, so
+// the URLs of files read once don't pile up.
+func (rb *redirectBackend) remember(filePath string, su signedURL) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	now := time.Now()
+	for p, old := range rb.urls {
+		if !old.usable(now) {
+			delete(rb.urls, p)
+		}
+	}
+	rb.urls[filePath] = su
+}
+
+func (rb *redirectBackend) forget(filePath string) {
+	rb.mu.Lock()
+	delete(rb.urls, filePath)
+	rb.mu.Unlock()
+}
+
+func (rb *redirectBackend) do(u string, gateway bool, offset, length int64) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if gateway {
+		if rb.username != "" {
+			req.SetBasicAuth(rb.username, rb.password)
+		}
+	} else if rb.referer != "" {
+		req.Header.Set("Referer", rb.referer)
+	}
+	if length >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return rb.client.Do(req)
+}
+
+// Opens the range, where a negative length reads to the end.
+func (rb *redirectBackend) open(filePath string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := rb.get(filePath, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// The range was ignored.
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if length < 0 {
+			return resp.Body, nil
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, length), resp.Body}, nil
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("GET %s: %s", filePath, resp.Status)
+}
+
+func (rb *redirectBackend) ReadStream(filePath string) (io.ReadCloser, error) {
+	return rb.ReadStreamRange(filePath, 0, -1)
+}
+
+// Reads the range, reopening it where it left off if the connection fails,
+// which is how signed URLs expiring mid-stream show up.
+func (rb *redirectBackend) ReadStreamRange(filePath string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := rb.open(filePath, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &resumingReader{rb: rb, path: filePath, off: offset, remaining: length, rc: rc}, nil
+}
+
+type resumingReader struct {
+	rb   *redirectBackend
+	path string
+	off  int64
+	// Negative when reading to the end.
+	remaining int64
+	rc        io.ReadCloser
+	resumes   int
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		if r.remaining == 0 {
+			return 0, io.EOF
+		}
+		if r.remaining > 0 && int64(len(p)) > r.remaining {
+			p = p[:r.remaining]
+		}
+		n, err := r.rc.Read(p)
+		r.off += int64(n)
+		if r.remaining > 0 {
+			r.remaining -= int64(n)
+		}
+		switch {
+		case err == nil, err == io.EOF && r.remaining < 0:
+			return n, err
+		case n > 0:
+			// The error comes up again on the next read.
+			return n, nil
+		case err == io.EOF:
+			err = io.ErrUnexpectedEOF
+		}
+		if r.resumes >= maxStreamResumes {
+			return 0, err
+		}
+		r.resumes++
+		r.rc.Close()
+		r.rb.forget(r.path)
+		rc, openErr := r.rb.open(r.path, r.off, r.remaining)
+		if openErr != nil {
+			return 0, fmt.Errorf("%w, then resuming: %v", err, openErr)
+		}
+		r.rc = rc
+	}
+}
+
+func (r *resumingReader) Close() error {
+	return r.rc.Close()
+}
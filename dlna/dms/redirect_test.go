@@ -0,0 +1,145 @@
+package dms
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stands in for a WebDAV gateway that redirects downloads to a CDN, which
+// checks the Referer and the signature of the URL.
+type redirectGateway struct {
+	data []byte
+
+	mu       sync.Mutex
+	sig      int
+	resolves int
+	// Bytes of the next download to send before cutting the connection.
+	cutAfter int
+}
+
+func (g *redirectGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if strings.HasPrefix(r.URL.Path, "/dav/") {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		g.resolves++
+		expires := time.Now().Add(time.Hour).Unix()
+		http.Redirect(w, r, fmt.Sprintf("/cdn/movie.mkv?sig=%d&x-oss-expires=%d", g.sig, expires), http.StatusFound)
+		return
+	}
+	if r.Referer() != defaultDownloadReferer || r.URL.Query().Get("sig") != fmt.Sprint(g.sig) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if g.cutAfter > 0 {
+		w = &cutWriter{w, g.cutAfter}
+		g.cutAfter = 0
+		// The URL expires while it's being read.
+		g.sig++
+	}
+	http.ServeContent(w, r, "movie.mkv", time.Time{}, bytes.NewReader(g.data))
+}
+
+type cutWriter struct {
+	http.ResponseWriter
+	left int
+}
+
+func (cw *cutWriter) Write(p []byte) (int, error) {
+	if len(p) > cw.left {
+		p = p[:cw.left]
+	}
+	n, err := cw.ResponseWriter.Write(p)
+	cw.left -= n
+	if err == nil && cw.left == 0 {
+		err = errors.New("cut")
+	}
+	return n, err
+}
+
+func TestRedirectBackend(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	g := &redirectGateway{data: data}
+	ts := httptest.NewServer(g)
+	defer ts.Close()
+	root, _ := url.Parse(ts.URL + "/dav")
+	rb := newRedirectBackend(nil, root, "user", "pass", defaultDownloadReferer)
+	read := func(offset, length int64) []byte {
+		rc, err := rb.ReadStreamRange("/Videos/movie.mkv", offset, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	if got := read(5, 10); string(got) != "5678901234" {
+		t.Fatalf("%q", got)
+	}
+	// The signed URL is reused.
+	read(100, 10)
+	if g.resolves != 1 {
+		t.Fatal(g.resolves)
+	}
+	// A rejected signed URL is resolved again.
+	g.sig++
+	if got := read(20, 3); string(got) != "012" {
+		t.Fatalf("%q", got)
+	}
+	if g.resolves != 2 {
+		t.Fatal(g.resolves)
+	}
+	// A stream that fails partway is resumed where it left off.
+	g.cutAfter = 30000
+	if got := read(0, -1); !bytes.Equal(got, data) {
+		t.Fatal(len(got))
+	}
+	if g.resolves != 3 {
+		t.Fatal(g.resolves)
+	}
+}
+
+func TestSignedURLExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	u, _ := url.Parse("https://cdn.example/f?x-oss-expires=2000&x-oss-signature=abc")
+	if got := signedURLExpiry(u, now); !got.Equal(time.Unix(2000, 0)) {
+		t.Fatal(got)
+	}
+	u, _ = url.Parse("https://cdn.example/f")
+	if got := signedURLExpiry(u, now); !got.Equal(now.Add(signedURLTTL)) {
+		t.Fatal(got)
+	}
+}
+
+func TestSignedURLsForgotten(t *testing.T) {
+	rb := newRedirectBackend(nil, &url.URL{}, "", "", "")
+	now := time.Now()
+	rb.urls["/old"] = signedURL{"https://cdn.example/old", now.Add(-time.Minute)}
+	rb.urls["/expiring"] = signedURL{"https://cdn.example/expiring", now.Add(signedURLMargin / 2)}
+	rb.urls["/fresh"] = signedURL{"https://cdn.example/fresh", now.Add(time.Hour)}
+	if _, ok := rb.lookup("/expiring"); ok || len(rb.urls) != 2 {
+		t.Fatal(rb.urls)
+	}
+	rb.remember("/new", signedURL{"https://cdn.example/new", now.Add(time.Hour)})
+	if _, ok := rb.urls["/old"]; ok || len(rb.urls) != 2 {
+		t.Fatal(rb.urls)
+	}
+	if su, ok := rb.lookup("/fresh"); !ok || su.url != "https://cdn.example/fresh" {
+		t.Fatal(su, ok)
+	}
+}
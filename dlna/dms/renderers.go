@@ -0,0 +1,171 @@
+package dms
+
+import (
+	"net/http"
+	"strings"
+
+	subs "github.com/gofly/alipan-dms/subtitle"
+)
+
+// Header some renderers, like Sony's, identify themselves with.
+const avClientInfoHeader = "X-AV-Client-Info"
+
+// The quirks of a kind of renderer.
+type rendererProfile struct {
+	Name string
+	// Substrings of the User-Agent or X-AV-Client-Info headers that identify
+	// the renderer.
+	match []string
+	// Titles are cut to this many characters. Zero is unlimited.
+	MaxTitleLength int
+	// MIME-types the renderer knows by other names.
+	MimeTypes map[mimeType]mimeType
+	// The format subtitles are served in. Empty serves them as they are.
+	SubtitleFormat string
+	// MIME-types, or prefixes of them like "audio/", the renderer plays.
+	// Nil plays everything.
+	Plays []string
+	// Leave items the renderer can't play out of listings, unless they can be
+	// transcoded.
+	HideUnplayable bool
+}
+
+// Known renderers, in order of precedence.
+var rendererProfiles = []*rendererProfile{
+	{
+		Name:  "VLC",
+		match: []string{"VLC"},
+	},
+	{
+		Name:  "Kodi",
+		match: []string{"Kodi", "XBMC"},
+	},
+	{
+		Name:  "BubbleUPnP",
+		match: []string{"BubbleUPnP"},
+	},
+	{
+		Name:           "Samsung",
+		match:          []string{"SEC_HHP", "Samsung"},
+		MimeTypes:      map[mimeType]mimeType{"video/x-matroska": "video/x-mkv"},
+		SubtitleFormat: subs.SRT,
+	},
+	{
+		Name:           "LG webOS",
+		match:          []string{"webOS", "LG"},
+		SubtitleFormat: subs.SRT,
+	},
+	{
+		Name:           "Sony Bravia",
+		match:          []string{"BRAVIA", `cn="Sony`},
+		MaxTitleLength: 80,
+		MimeTypes:      map[mimeType]mimeType{"video/mp2t": "video/vnd.dlna.mpeg-tts"},
+		SubtitleFormat: subs.SRT,
+	},
+	{
+		// The Xbox 360 plays little besides Windows Media and MP4, and offers
+		// everything listed whether it can play it or not.
+		Name:           "Xbox",
+		match:          []string{"Xbox"},
+		MaxTitleLength: 64,
+		MimeTypes:      map[mimeType]mimeType{"video/x-msvideo": "video/avi"},
+		SubtitleFormat: subs.SRT,
+		Plays: []string{
+			"video/mp4", "video/x-ms-wmv", "video/avi", "video/x-msvideo", "video/quicktime",
+			"audio/mpeg", "audio/x-ms-wma", "audio/mp4", "audio/wav",
+			"image/jpeg", "image/png", "image/gif",
+		},
+		HideUnplayable: true,
+	},
+	{
+		Name:           "Windows Media Player",
+		match:          []string{"Windows-Media-Player", "WMFSDK"},
+		MimeTypes:      map[mimeType]mimeType{"video/x-msvideo": "video/avi"},
+		SubtitleFormat: subs.SRT,
+		Plays: []string{
+			"video/mp4", "video/x-ms-wmv", "video/avi", "video/x-msvideo", "video/mpeg",
+			"video/mp2t", "video/quicktime", "video/x-matroska",
+			"audio/", "image/",
+		},
+		HideUnplayable: true,
+	},
+	{
+		Name:           "Chromecast",
+		match:          []string{"CrKey", "Chromecast"},
+		SubtitleFormat: subs.VTT,
+	},
+}
+
+// The profile of renderers that aren't recognized. SRT subtitles are served,
+// as nearly all renderers can display them.
+var genericRenderer = &rendererProfile{
+	Name:           "generic",
+	SubtitleFormat: subs.SRT,
+}
+
+// Returns the profile of the renderer that sent the headers.
+func matchRenderer(h http.Header) *rendererProfile {
+	userAgent := h.Get("User-Agent")
+	clientInfo := h.Get(avClientInfoHeader)
+	for _, rp := range rendererProfiles {
+		for _, m := range rp.match {
+			if strings.Contains(userAgent, m) || strings.Contains(clientInfo, m) {
+				return rp
+			}
+		}
+	}
+	return genericRenderer
+}
+
+// Returns the profile of the renderer that sent the headers, with the
+// configured subtitle format for its User-Agent.
+func (s *Server) renderer(h http.Header) *rendererProfile {
+	rp := matchRenderer(h)
+	userAgent := h.Get("User-Agent")
+	// The longest match wins, so the choice doesn't depend on map order.
+	match := -1
+	var format string
+	for ua, f := range s.SubtitleFormats {
+		if len(ua) > match && strings.Contains(userAgent, ua) {
+			match, format = len(ua), f
+		}
+	}
+	if match >= 0 && format != rp.SubtitleFormat {
+		custom := *rp
+		custom.SubtitleFormat = format
+		rp = &custom
+	}
+	return rp
+}
+
+// Returns the MIME-type the renderer knows mt by.
+func (rp *rendererProfile) mimeType(mt mimeType) mimeType {
+	if alias, ok := rp.MimeTypes[mt]; ok {
+		return alias
+	}
+	return mt
+}
+
+func (rp *rendererProfile) plays(mt mimeType) bool {
+	if rp.Plays == nil {
+		return true
+	}
+	for _, p := range rp.Plays {
+		if string(mt) == p || strings.HasSuffix(p, "/") && strings.HasPrefix(string(mt), p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the title cut to the length the renderer displays.
+func (rp *rendererProfile) title(title string) string {
+	if rp.MaxTitleLength <= 0 {
+		return title
+	}
+	runes := []rune(title)
+	if len(runes) <= rp.MaxTitleLength {
+		return title
+	}
+	return string(runes[:rp.MaxTitleLength-1]) + "…"
+}
@@ -0,0 +1,64 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func TestMatchRenderer(t *testing.T) {
+	for _, tc := range []struct {
+		userAgent, clientInfo, name string
+	}{
+		{"SEC_HHP_[TV] Samsung Q7 Series/1.0", "", "Samsung"},
+		{"Linux/3.10.19-32.afro.4 UPnP/1.0 LGE WebOSTV/1.0", "", "LG webOS"},
+		{"UPnP/1.0", `av=5.0; cn="Sony Corporation"; mn="KDL-40EX720"; mv="1.7";`, "Sony Bravia"},
+		{"Xbox/2.0.17559.0 UPnP/1.0 Xbox/2.0.17559.0", "", "Xbox"},
+		{"Microsoft-Windows/10.0 UPnP/1.0 Windows-Media-Player/12.0.19041.1", "", "Windows Media Player"},
+		{"Kodi/19.4 (Linux; Android 9) Android/9.0.0 Sys_CPU/armv8l App_Bitness/32 Version/19.4-(19.4.0)-Git:20220307-d8d6ffc5a3", "", "Kodi"},
+		{"BubbleUPnP UPnP/1.1", "", "BubbleUPnP"},
+		{"", "", "generic"},
+	} {
+		h := http.Header{}
+		h.Set("User-Agent", tc.userAgent)
+		h.Set(avClientInfoHeader, tc.clientInfo)
+		if got := matchRenderer(h).Name; got != tc.name {
+			t.Errorf("%q, %q: got %s, want %s", tc.userAgent, tc.clientInfo, got, tc.name)
+		}
+	}
+}
+
+func TestRendererProfiles(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Videos/A rather long title for a film that goes on and on and on and on and on and on.mp4": nil,
+		"/Videos/movie.mkv":  nil,
+		"/Videos/movie.rmvb": nil,
+	})
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	read := func(userAgent string) (titles []string, b []byte) {
+		objs, err := cds.readContainer(object{"/Videos", "/"}, "host", s.renderer(http.Header{"User-Agent": {userAgent}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range objs {
+			item := obj.(upnpav.Item)
+			titles = append(titles, item.Title)
+			xb, _ := xml.Marshal(item)
+			b = append(b, xb...)
+		}
+		return
+	}
+	titles, b := read("SEC_HHP_[TV] Samsung")
+	if len(titles) != 3 || !bytes.Contains(b, []byte("http-get:*:video/x-mkv:")) {
+		t.Fatal(titles, string(b))
+	}
+	// The Xbox can't play Matroska or RealMedia, and there's no transcoder.
+	titles, _ = read("Xbox/2.0.17559.0")
+	if len(titles) != 1 || len([]rune(titles[0])) != 64 || !strings.HasSuffix(titles[0], "…") {
+		t.Fatal(titles)
+	}
+}
@@ -0,0 +1,89 @@
+package dms
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/gofly/alipan-dms/blockcache"
+	"github.com/gofly/alipan-dms/dlna"
+)
+
+const resPath = "/res"
+
+// Returns the MIME-type of a file, preferring what the backend reports.
+func fileMimeType(fi os.FileInfo) mimeType {
+	if ct, ok := fi.(ContentType); ok && ct.ContentType() != "" {
+		return mimeType(ct.ContentType())
+	}
+	return mimeTypeByBaseName(fi.Name())
+}
+
+func (s *Server) resURL(o object, host string) string {
+	return (&url.URL{
+		Scheme:   "http",
+		Host:     host,
+		Path:     resPath,
+		RawQuery: url.Values{"path": {o.Path}}.Encode(),
+	}).String()
+}
+
+// Streams an item's file from the backend.
+func (s *Server) serveResource(w http.ResponseWriter, r *http.Request) {
+	o := object{path.Clean("/" + r.URL.Query().Get("path")), s.RootObjectPath}
+	filePath := o.FilePath()
+	fi, err := s.Backend.Stat(filePath)
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	mt := fileMimeType(fi)
+	rp := s.renderer(r.Header)
+	if r.Header.Get("getCaptionInfo.sec") == "1" && mt.IsVideo() {
+		sts, err := s.subtitles(filePath)
+		if err != nil {
+			s.Logger.Printf("error finding subtitles for %s: %s", o.Path, err)
+		} else if len(sts) != 0 {
+			w.Header().Set("CaptionInfo.sec", s.subtitleURL(o, sts[0].as(rp.SubtitleFormat), r.Host))
+		}
+	}
+	w.Header().Set("Content-Type", rp.mimeType(mt).String())
+	w.Header().Set(dlna.ContentFeaturesDomain, dlna.ContentFeatures{
+		SupportTimeSeek: supportsTimeSeek(mt),
+		SupportRange:    true,
+	}.String())
+	if mt.IsImage() {
+		w.Header().Set(dlna.TransferModeDomain, "Interactive")
+	} else {
+		w.Header().Set(dlna.TransferModeDomain, "Streaming")
+	}
+	if r.Header.Get(dlna.TimeSeekRangeDomain) != "" {
+		s.serveTimeSeek(w, r, filePath, fi, mt)
+		return
+	}
+	rs := s.openStream(r.Context(), requestClient(r), filePath, fi)
+	defer rs.Close()
+	http.ServeContent(w, r, "", fi.ModTime(), rs)
+}
+
+// Returns a reader of a file being streamed to a client, scheduled among the
+// client's other streams. Through the read-ahead cache, if there is one, it's
+// the cache's fetches of the file that are scheduled, including those ahead
+// of the reader, and reads of what's cached aren't limited.
+func (s *Server) openStream(ctx context.Context, client string, filePath string, fi os.FileInfo) io.ReadSeekCloser {
+	if s.streamCache == nil {
+		return s.streams.stream(ctx, client, newBackendReadSeeker(s.Backend, filePath, fi.Size()))
+	}
+	return s.streamCache.Open(blockcache.File{
+		Key:  fileKey(filePath, fi),
+		Size: fi.Size(),
+		ReadRange: func(off, length int64) (io.ReadCloser, error) {
+			return s.streams.open(ctx, client, func() (io.ReadCloser, error) {
+				return s.Backend.ReadStreamRange(filePath, off, length)
+			})
+		},
+	})
+}
@@ -0,0 +1,39 @@
+package dms
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofly/alipan-dms/blockcache"
+)
+
+func TestStreamCache(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	s := newTestServer(memBackend{"/Videos/movie.mkv": data})
+	var err error
+	if s.streamCache, err = blockcache.New(t.TempDir(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	get := func() string {
+		req := httptest.NewRequest("GET", "/res?path=%2FVideos%2Fmovie.mkv", nil)
+		req.Header.Set("Range", "bytes=5000-5009")
+		rec := httptest.NewRecorder()
+		s.httpServeMux.ServeHTTP(rec, req)
+		if rec.Code != 206 {
+			t.Fatal(rec.Code)
+		}
+		return rec.Body.String()
+	}
+	if got := get(); got != "0123456789" {
+		t.Fatal(got)
+	}
+	if s.streamCache.Size() != int64(len(data)) {
+		t.Fatal(s.streamCache.Size())
+	}
+	// The cached block is served while the file appears unchanged.
+	s.Backend = memBackend{"/Videos/movie.mkv": make([]byte, len(data))}
+	if got := get(); got != "0123456789" {
+		t.Fatal(got)
+	}
+}
@@ -0,0 +1,324 @@
+package dms
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Reads of a throttled stream are split into chunks no larger than this, so
+// streams sharing a rate take turns.
+const streamChunkSize = 32 << 10
+
+// Limits the streams open to the backend, and the rate they're read at, both
+// overall and for each client IP. Streams over the limits wait their turn in
+// order. Every read of the backend is scheduled, including the stream
+// cache's fetches and the server's own reads.
+type streamScheduler struct {
+	maxStreams       int
+	maxClientStreams int
+	clientRate       int64
+
+	mu      sync.Mutex
+	active  int
+	clients map[string]*streamClient
+	queue   list.List
+	// Nil if the overall rate isn't limited.
+	bucket *tokenBucket
+}
+
+type streamClient struct {
+	// Empty for the server's own streams.
+	ip     string
+	active int
+	// Streams open or waiting to open.
+	refs int
+	// Nil if the client's rate isn't limited.
+	bucket *tokenBucket
+}
+
+type streamWaiter struct {
+	client  *streamClient
+	ready   chan struct{}
+	granted bool
+}
+
+// Zero limits are unlimited, and rates are in bytes per second.
+func newStreamScheduler(maxStreams, maxClientStreams int, rate, clientRate int64) *streamScheduler {
+	return &streamScheduler{
+		maxStreams:       maxStreams,
+		maxClientStreams: maxClientStreams,
+		clientRate:       clientRate,
+		clients:          make(map[string]*streamClient),
+		bucket:           newTokenBucket(rate),
+	}
+}
+
+func (ss *streamScheduler) unlimited() bool {
+	return ss.maxStreams <= 0 && ss.maxClientStreams <= 0 && ss.bucket == nil && ss.clientRate <= 0
+}
+
+// Returns the IP address a request came from.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// The query parameter naming the client a request the server makes to
+// itself, like the transcoder's reads, is made for.
+const clientParam = "client"
+
+// Returns the client a request is made for: the IP address it came from,
+// unless it came from the server itself, which names the client with
+// clientParam. The server's own requests that aren't for any one client, like
+// making shared HLS segments, give "".
+func requestClient(r *http.Request) string {
+	ip := clientIP(r)
+	remote := net.ParseIP(ip)
+	if remote == nil {
+		return ip
+	}
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	if !remote.IsLoopback() && (local == nil || !local.IP.Equal(remote)) {
+		return ip
+	}
+	return r.URL.Query().Get(clientParam)
+}
+
+// Wraps a stream for a client so that it waits for a turn before its first
+// read, is throttled, and gives up its turn when closed. Streams for no
+// client count only towards the overall limits.
+func (ss *streamScheduler) stream(ctx context.Context, client string, rs io.ReadSeekCloser) io.ReadSeekCloser {
+	if ss.unlimited() {
+		return rs
+	}
+	return &scheduledStream{ReadCloser: rs, ss: ss, client: ss.ref(client), ctx: ctx}
+}
+
+// Opens a read of the backend for a client once it may start another, and
+// throttles it. It gives up its turn when closed. Reads through the stream
+// cache are scheduled this way, each range fetched being a stream of its own.
+func (ss *streamScheduler) open(ctx context.Context, client string, open func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	if ss.unlimited() {
+		return open()
+	}
+	c := ss.ref(client)
+	if err := ss.acquire(ctx, c); err != nil {
+		ss.unref(c)
+		return nil, err
+	}
+	rc, err := open()
+	if err != nil {
+		ss.release(c)
+		ss.unref(c)
+		return nil, err
+	}
+	return &scheduledStream{ReadCloser: rc, ss: ss, client: c, ctx: ctx, started: true}, nil
+}
+
+// Returns the client, keeping it until unref.
+func (ss *streamScheduler) ref(client string) *streamClient {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	c, ok := ss.clients[client]
+	if !ok {
+		c = &streamClient{ip: client}
+		if client != "" {
+			c.bucket = newTokenBucket(ss.clientRate)
+		}
+		ss.clients[c.ip] = c
+	}
+	c.refs++
+	return c
+}
+
+func (ss *streamScheduler) canStart(c *streamClient) bool {
+	return (ss.maxStreams <= 0 || ss.active < ss.maxStreams) &&
+		(ss.maxClientStreams <= 0 || c.ip == "" || c.active < ss.maxClientStreams)
+}
+
+func (ss *streamScheduler) start(c *streamClient) {
+	ss.active++
+	c.active++
+}
+
+// Waits until the client may open another stream.
+func (ss *streamScheduler) acquire(ctx context.Context, c *streamClient) error {
+	ss.mu.Lock()
+	// Waiters are only left queued when they can't start, so starting now
+	// doesn't jump the queue.
+	if ss.canStart(c) {
+		ss.start(c)
+		ss.mu.Unlock()
+		return nil
+	}
+	w := &streamWaiter{client: c, ready: make(chan struct{})}
+	e := ss.queue.PushBack(w)
+	ss.mu.Unlock()
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if w.granted {
+		ss.releaseLocked(c)
+	} else {
+		ss.queue.Remove(e)
+	}
+	return ctx.Err()
+}
+
+func (ss *streamScheduler) release(c *streamClient) {
+	ss.mu.Lock()
+	ss.releaseLocked(c)
+	ss.mu.Unlock()
+}
+
+// Ends a stream, and starts the waiting streams that now can, in order.
+func (ss *streamScheduler) releaseLocked(c *streamClient) {
+	ss.active--
+	c.active--
+	for e := ss.queue.Front(); e != nil; {
+		if ss.maxStreams > 0 && ss.active >= ss.maxStreams {
+			break
+		}
+		next := e.Next()
+		if w := e.Value.(*streamWaiter); ss.canStart(w.client) {
+			ss.start(w.client)
+			w.granted = true
+			close(w.ready)
+			ss.queue.Remove(e)
+		}
+		e = next
+	}
+}
+
+func (ss *streamScheduler) unref(c *streamClient) {
+	ss.mu.Lock()
+	c.refs--
+	if c.refs == 0 {
+		delete(ss.clients, c.ip)
+	}
+	ss.mu.Unlock()
+}
+
+type scheduledStream struct {
+	// An io.ReadSeekCloser if it's a stream.
+	io.ReadCloser
+	ss      *streamScheduler
+	client  *streamClient
+	ctx     context.Context
+	started bool
+	closed  bool
+}
+
+func (s *scheduledStream) Read(p []byte) (int, error) {
+	if !s.started {
+		if err := s.ss.acquire(s.ctx, s.client); err != nil {
+			return 0, err
+		}
+		s.started = true
+	}
+	if len(p) > streamChunkSize {
+		p = p[:streamChunkSize]
+	}
+	n, err := s.ReadCloser.Read(p)
+	for _, b := range []*tokenBucket{s.client.bucket, s.ss.bucket} {
+		if waitErr := b.wait(s.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (s *scheduledStream) Seek(offset int64, whence int) (int64, error) {
+	return s.ReadCloser.(io.Seeker).Seek(offset, whence)
+}
+
+func (s *scheduledStream) Close() error {
+	if !s.closed {
+		s.closed = true
+		if s.started {
+			s.ss.release(s.client)
+		}
+		s.ss.unref(s.client)
+	}
+	return s.ReadCloser.Close()
+}
+
+// Schedules the server's own reads of a backend, like probes and indexing,
+// among the streams, as those of no client.
+type scheduledBackend struct {
+	Backend
+	ss *streamScheduler
+}
+
+func (b scheduledBackend) ReadStream(path string) (io.ReadCloser, error) {
+	return b.ss.open(context.Background(), "", func() (io.ReadCloser, error) {
+		return b.Backend.ReadStream(path)
+	})
+}
+
+func (b scheduledBackend) ReadStreamRange(path string, offset, length int64) (io.ReadCloser, error) {
+	return b.ss.open(context.Background(), "", func() (io.ReadCloser, error) {
+		return b.Backend.ReadStreamRange(path, offset, length)
+	})
+}
+
+// Limits a rate of bytes per second, allowing bursts of a tenth of a second.
+// Bytes taken beyond what's available are owed, and waited for.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Returns nil if the rate is unlimited.
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := float64(rate) / 10
+	if burst < streamChunkSize {
+		burst = streamChunkSize
+	}
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Takes n bytes, waiting until they're paid for.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	owed := -b.tokens
+	b.mu.Unlock()
+	if owed <= 0 {
+		return nil
+	}
+	t := time.NewTimer(time.Duration(owed / b.rate * float64(time.Second)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
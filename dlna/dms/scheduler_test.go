@@ -0,0 +1,203 @@
+package dms
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+	"github.com/gofly/alipan-dms/blockcache"
+)
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+func testStream(ss *streamScheduler, ip string, data []byte) (io.ReadSeekCloser, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return ss.stream(ctx, ip, nopSeekCloser{bytes.NewReader(data)}), cancel
+}
+
+// Reads a byte in the background, reporting when it's done.
+func readByte(rs io.Reader) chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := rs.Read(make([]byte, 1))
+		done <- err
+	}()
+	return done
+}
+
+func TestStreamSchedulerLimits(t *testing.T) {
+	ss := newStreamScheduler(2, 1, 0, 0)
+	a1, _ := testStream(ss, "10.0.0.1", []byte("a1"))
+	a2, cancelA2 := testStream(ss, "10.0.0.1", []byte("a2"))
+	b1, _ := testStream(ss, "10.0.0.2", []byte("b1"))
+	c1, _ := testStream(ss, "10.0.0.3", []byte("c1"))
+	if err := <-readByte(a1); err != nil {
+		t.Fatal(err)
+	}
+	// Over the client's limit.
+	a2Done := readByte(a2)
+	// Another client isn't held up by it.
+	if err := <-readByte(b1); err != nil {
+		t.Fatal(err)
+	}
+	// Over the overall limit.
+	c1Done := readByte(c1)
+	select {
+	case <-a2Done:
+		t.Fatal("client limit exceeded")
+	case <-c1Done:
+		t.Fatal("overall limit exceeded")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// A request that goes away leaves the queue.
+	cancelA2()
+	if err := <-a2Done; err != context.Canceled {
+		t.Fatal(err)
+	}
+	a2.Close()
+	b1.Close()
+	if err := <-c1Done; err != nil {
+		t.Fatal(err)
+	}
+	a1.Close()
+	c1.Close()
+	if ss.active != 0 || len(ss.clients) != 0 || ss.queue.Len() != 0 {
+		t.Fatal(ss.active, ss.clients, ss.queue.Len())
+	}
+}
+
+func TestStreamSchedulerRate(t *testing.T) {
+	data := make([]byte, 160<<10)
+	ss := newStreamScheduler(0, 0, 0, 320<<10)
+	rs, _ := testStream(ss, "10.0.0.1", data)
+	defer rs.Close()
+	started := time.Now()
+	n, err := io.Copy(io.Discard, rs)
+	if err != nil || n != int64(len(data)) {
+		t.Fatal(n, err)
+	}
+	// The burst is free, and the rest takes 0.4s.
+	if elapsed := time.Since(started); elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatal(elapsed)
+	}
+}
+
+func TestRequestClient(t *testing.T) {
+	for _, c := range []struct {
+		remote, local, target string
+		want                  string
+	}{
+		{"10.0.0.5:1234", "10.0.0.1:1338", "/res?client=10.0.0.9", "10.0.0.5"},
+		{"127.0.0.1:1234", "127.0.0.1:1338", "/res?client=10.0.0.9", "10.0.0.9"},
+		{"10.0.0.1:1234", "10.0.0.1:1338", "/res?client=10.0.0.9", "10.0.0.9"},
+		{"127.0.0.1:1234", "127.0.0.1:1338", "/res", ""},
+	} {
+		local, _ := net.ResolveTCPAddr("tcp", c.local)
+		r := httptest.NewRequest("GET", c.target, nil)
+		r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+		r.RemoteAddr = c.remote
+		if got := requestClient(r); got != c.want {
+			t.Errorf("%s to %s: %q", c.remote, c.target, got)
+		}
+	}
+}
+
+func TestStreamSchedulerServer(t *testing.T) {
+	ss := newStreamScheduler(2, 1, 0, 0)
+	a1, _ := testStream(ss, "10.0.0.1", []byte("a1"))
+	s1, _ := testStream(ss, "", []byte("s1"))
+	s2, _ := testStream(ss, "", []byte("s2"))
+	defer a1.Close()
+	defer s1.Close()
+	defer s2.Close()
+	if err := <-readByte(a1); err != nil {
+		t.Fatal(err)
+	}
+	// The server's own streams aren't held to a client's limit.
+	if err := <-readByte(s1); err != nil {
+		t.Fatal(err)
+	}
+	// But are to the overall limit.
+	select {
+	case <-readByte(s2):
+		t.Fatal("overall limit exceeded")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Records the most reads of a backend open at once.
+type concurrencyBackend struct {
+	memBackend
+	mu        sync.Mutex
+	open, max int
+}
+
+type closeFunc func() error
+
+func (f closeFunc) Close() error { return f() }
+
+func (b *concurrencyBackend) ReadStreamRange(p string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := b.memBackend.ReadStreamRange(p, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.open++
+	if b.open > b.max {
+		b.max = b.open
+	}
+	b.mu.Unlock()
+	// Slow enough for reads that aren't scheduled to overlap.
+	time.Sleep(10 * time.Millisecond)
+	return struct {
+		io.Reader
+		io.Closer
+	}{rc, closeFunc(func() error {
+		b.mu.Lock()
+		b.open--
+		b.mu.Unlock()
+		return rc.Close()
+	})}, nil
+}
+
+func TestStreamCacheScheduled(t *testing.T) {
+	data := make([]byte, 5*blockcache.DefaultBlockSize+1)
+	b := &concurrencyBackend{memBackend: memBackend{"/Videos/movie.mkv": data}}
+	s := &Server{
+		FriendlyName:   "test",
+		RootObjectPath: "/",
+		WebdavURI:      &url.URL{Scheme: "http", Host: "webdav"},
+		Backend:        b,
+		CacheDir:       t.TempDir(),
+		MaxStreams:     1,
+		Logger:         log.Default,
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := b.Stat("/Videos/movie.mkv")
+	rs := s.openStream(context.Background(), "10.0.0.1", "/Videos/movie.mkv", fi)
+	got, err := io.ReadAll(rs)
+	rs.Close()
+	if err != nil || len(got) != len(data) {
+		t.Fatal(len(got), err)
+	}
+	// The cache's fetches ahead of the reader wait their turn too.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max != 1 {
+		t.Fatal(b.max)
+	}
+}
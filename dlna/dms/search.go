@@ -0,0 +1,311 @@
+package dms
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofly/alipan-dms/hanzi"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+const (
+	// The most directories a search lists, and the longest it spends listing
+	// them. Listings are cached, so repeating a search goes further.
+	maxSearchDirs = 1000
+	searchBudget  = 10 * time.Second
+)
+
+// Properties that can be searched on.
+const searchCapabilities = "@id,@parentID,upnp:class,dc:title,dc:date,dc:description,upnp:artist,upnp:album,upnp:genre"
+
+// A parsed ContentDirectory SearchCriteria.
+type searchCriteria interface {
+	match(obj upnpav.Object) bool
+}
+
+// Matches everything, for the criteria "*".
+type searchAll struct{}
+
+func (searchAll) match(upnpav.Object) bool { return true }
+
+type searchAnd [2]searchCriteria
+
+func (sa searchAnd) match(obj upnpav.Object) bool { return sa[0].match(obj) && sa[1].match(obj) }
+
+type searchOr [2]searchCriteria
+
+func (so searchOr) match(obj upnpav.Object) bool { return so[0].match(obj) || so[1].match(obj) }
+
+// A comparison of a property with a value, like `dc:title contains "news"`.
+type searchRel struct {
+	property string
+	op       string
+	value    string
+}
+
+// Returns the value of a property of obj, and whether it has one.
+func searchProperty(obj upnpav.Object, property string) (string, bool) {
+	var v string
+	switch property {
+	case "@id":
+		v = obj.ID
+	case "@parentID":
+		v = obj.ParentID
+	case "upnp:class":
+		v = obj.Class
+	case "dc:title":
+		v = obj.Title
+	case "dc:date":
+		if !obj.Date.IsZero() {
+			v = obj.Date.Format("2006-01-02")
+		}
+	case "dc:description":
+		v = obj.Description
+	case "upnp:artist", "dc:creator":
+		v = obj.Artist
+	case "upnp:album":
+		v = obj.Album
+	case "upnp:genre":
+		v = obj.Genre
+	}
+	return v, v != ""
+}
+
+// String comparisons ignore case, full-width forms, and whether characters
+// are traditional or simplified. Containment also matches by pinyin, so
+// "lldq" is in 流浪地球.
+func (sr searchRel) match(obj upnpav.Object) bool {
+	v, ok := searchProperty(obj, sr.property)
+	if sr.op == "exists" {
+		return ok == (sr.value == "true")
+	}
+	v, want := hanzi.Fold(v), hanzi.Fold(sr.value)
+	switch sr.op {
+	case "=":
+		return v == want
+	case "!=":
+		return v != want
+	case "<":
+		return ok && v < want
+	case "<=":
+		return ok && v <= want
+	case ">":
+		return ok && v > want
+	case ">=":
+		return ok && v >= want
+	case "contains":
+		return ok && hanzi.Contains(v, want)
+	case "doesnotcontain":
+		return !hanzi.Contains(v, want)
+	case "derivedfrom":
+		return derivesFrom(v, want)
+	}
+	return false
+}
+
+// Returns whether a UPnP class is, or is a subclass of, base.
+func derivesFrom(class, base string) bool {
+	return class == base || strings.HasPrefix(class, base+".")
+}
+
+// Parses SearchCriteria, as described by the ContentDirectory spec. "and"
+// binds more tightly than "or".
+func parseSearchCriteria(s string) (searchCriteria, error) {
+	if strings.TrimSpace(s) == "*" {
+		return searchAll{}, nil
+	}
+	toks, err := searchTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &searchParser{toks: toks}
+	sc, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	return sc, nil
+}
+
+type searchToken struct {
+	text   string
+	quoted bool
+}
+
+// Splits criteria into words, quoted strings, parentheses and operators.
+func searchTokens(s string) (toks []searchToken, err error) {
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, searchToken{text: s[i : i+1]})
+			i++
+		case c == '"':
+			var b strings.Builder
+			for i++; ; i++ {
+				if i == len(s) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				} else if s[i] == '"' {
+					break
+				}
+				b.WriteByte(s[i])
+			}
+			toks = append(toks, searchToken{text: b.String(), quoted: true})
+			i++
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\r\n()\"", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, searchToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return
+}
+
+type searchParser struct {
+	toks []searchToken
+	pos  int
+}
+
+// Returns the next token, if it's an unquoted keyword, in lower case.
+func (p *searchParser) keyword() string {
+	if p.pos == len(p.toks) || p.toks[p.pos].quoted {
+		return ""
+	}
+	return strings.ToLower(p.toks[p.pos].text)
+}
+
+func (p *searchParser) or() (searchCriteria, error) {
+	sc, err := p.and()
+	for err == nil && p.keyword() == "or" {
+		p.pos++
+		var right searchCriteria
+		right, err = p.and()
+		sc = searchOr{sc, right}
+	}
+	return sc, err
+}
+
+func (p *searchParser) and() (searchCriteria, error) {
+	sc, err := p.term()
+	for err == nil && p.keyword() == "and" {
+		p.pos++
+		var right searchCriteria
+		right, err = p.term()
+		sc = searchAnd{sc, right}
+	}
+	return sc, err
+}
+
+func (p *searchParser) term() (searchCriteria, error) {
+	if p.pos+1 > len(p.toks) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	if p.keyword() == "(" {
+		p.pos++
+		sc, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.keyword() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return sc, nil
+	}
+	if p.pos+3 > len(p.toks) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	sr := searchRel{
+		property: p.toks[p.pos].text,
+		op:       strings.ToLower(p.toks[p.pos+1].text),
+		value:    p.toks[p.pos+2].text,
+	}
+	switch sr.op {
+	case "=", "!=", "<", "<=", ">", ">=", "contains", "doesnotcontain", "derivedfrom":
+		if !p.toks[p.pos+2].quoted {
+			return nil, fmt.Errorf("unquoted value %q", sr.value)
+		}
+	case "exists":
+		if sr.value = strings.ToLower(sr.value); sr.value != "true" && sr.value != "false" {
+			return nil, fmt.Errorf("bad exists value %q", sr.value)
+		}
+	default:
+		return nil, fmt.Errorf("unknown operator %q", sr.op)
+	}
+	p.pos += 3
+	return sr, nil
+}
+
+// Returns the Object of a upnpav Item or Container.
+func upnpavObject(obj interface{}) upnpav.Object {
+	switch obj := obj.(type) {
+	case upnpav.Item:
+		return obj.Object
+	case upnpav.Container:
+		return obj.Object
+	}
+	return upnpav.Object{}
+}
+
+// Calls visit with each file and directory under o, breadth first, until the
+// search limits are reached.
+func (s *Server) walk(o object, visit func(object, os.FileInfo)) error {
+	deadline := time.Now().Add(searchBudget)
+	queue := []object{o}
+	for dirs := 0; len(queue) != 0 && dirs < maxSearchDirs && time.Now().Before(deadline); dirs++ {
+		dir := queue[0]
+		queue = queue[1:]
+		dl, err := s.listings.get(dir.FilePath())
+		if err != nil {
+			if dirs == 0 {
+				return err
+			}
+			s.Logger.Printf("error listing %s: %s", dir.Path, err)
+			continue
+		}
+		for _, fi := range dl.fis {
+			child := object{path.Join(dir.Path, fi.Name()), s.RootObjectPath}
+			if ignored, _ := s.IgnorePath(child.FilePath(), fi); ignored {
+				continue
+			}
+			visit(child, fi)
+			if fi.IsDir() {
+				queue = append(queue, child)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the objects under o that match the criteria. The media index is
+// searched once it's complete, and until then the backend is walked.
+func (s *contentDirectoryService) search(o object, sc searchCriteria, host string, rp *rendererProfile) (ret []interface{}, err error) {
+	visit := func(child object, fi os.FileInfo) {
+		obj, err := s.cdsObjectToUpnpavObject(child, fi, host, rp)
+		if err != nil || obj == nil {
+			return
+		}
+		if sc.match(upnpavObject(obj)) {
+			ret = append(ret, obj)
+		}
+	}
+	if s.index.walk(o.Path, func(p string, fi os.FileInfo) {
+		visit(object{p, s.RootObjectPath}, fi)
+	}) {
+		return
+	}
+	err = s.walk(o, visit)
+	return
+}
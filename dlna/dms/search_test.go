@@ -0,0 +1,173 @@
+package dms
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofly/alipan-dms/upnp"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func TestParseSearchCriteria(t *testing.T) {
+	video := upnpav.Object{Class: "object.item.videoItem.movie", Title: "The \"Big\" Movie"}
+	folder := upnpav.Object{Class: "object.container.storageFolder", Title: "Films"}
+	for _, tc := range []struct {
+		criteria      string
+		video, folder bool
+	}{
+		{"*", true, true},
+		{`upnp:class derivedfrom "object.item.videoItem"`, true, false},
+		{`upnp:class derivedfrom "object.item.video"`, false, false},
+		{`upnp:class = "object.container.storageFolder"`, false, true},
+		{`dc:title contains "\"big\""`, true, false},
+		{`dc:title doesNotContain "big"`, false, true},
+		{`upnp:class derivedfrom "object.container" or dc:title contains "movie" and upnp:artist exists false`, true, true},
+		{`(upnp:class derivedfrom "object.container" or dc:title contains "movie") and upnp:artist exists true`, false, false},
+		{`dc:title >= "g" AND dc:title < "u"`, true, false},
+	} {
+		sc, err := parseSearchCriteria(tc.criteria)
+		if err != nil {
+			t.Errorf("%s: %s", tc.criteria, err)
+			continue
+		}
+		if sc.match(video) != tc.video || sc.match(folder) != tc.folder {
+			t.Errorf("%s: %v, %v", tc.criteria, sc.match(video), sc.match(folder))
+		}
+	}
+	for _, criteria := range []string{
+		"",
+		`dc:title contains`,
+		`dc:title contains news`,
+		`dc:title like "news"`,
+		`(dc:title contains "news"`,
+		`dc:title contains "news`,
+		`upnp:artist exists maybe`,
+		`dc:title = "a" dc:title = "b"`,
+	} {
+		if _, err := parseSearchCriteria(criteria); err == nil {
+			t.Errorf("%q parsed", criteria)
+		}
+	}
+}
+
+func searchArgs(containerID, criteria string) []byte {
+	var b strings.Builder
+	b.WriteString(`<u:Search xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1"><ContainerID>`)
+	b.WriteString(containerID)
+	b.WriteString(`</ContainerID><SearchCriteria>`)
+	b.WriteString(strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;").Replace(criteria))
+	b.WriteString(`</SearchCriteria><Filter>*</Filter><StartingIndex>0</StartingIndex><RequestedCount>0</RequestedCount><SortCriteria></SortCriteria></u:Search>`)
+	return []byte(b.String())
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Movies/Heat.mkv":          nil,
+		"/Movies/Extras/Making.mp4": nil,
+		"/Music/Song.mp3":           nil,
+		"/Photos/Beach.jpg":         nil,
+		"/Photos/Heat wave.jpg":     nil,
+	})
+	cds := s.services["ContentDirectory"]
+	r := httptest.NewRequest("POST", "/ctl", nil)
+	r.Host = "host"
+	handle := func(action string, args []byte) map[string]string {
+		t.Helper()
+		resp, err := cds.Handle(action, args, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]string)
+		for _, kv := range resp {
+			m[kv[0]] = kv[1]
+		}
+		return m
+	}
+	for _, tc := range []struct {
+		containerID, criteria string
+		matches               string
+		titles                []string
+	}{
+		{"0", `upnp:class derivedfrom "object.item.videoItem"`, "2", []string{"Heat", "Making"}},
+		{"0", `dc:title contains "heat"`, "2", []string{"Heat", "Heat wave"}},
+		{"%2FMovies", "*", "3", []string{"Extras", "Heat", "Making"}},
+		// Windows Media Player searches its well-known containers.
+		{"15", "*", "2", []string{"Heat", "Making"}},
+		{"16", `dc:title contains "beach"`, "1", []string{"Beach"}},
+		{"4", `upnp:class = "object.item.audioItem"`, "1", []string{"Song"}},
+	} {
+		m := handle("Search", searchArgs(tc.containerID, tc.criteria))
+		if m["TotalMatches"] != tc.matches {
+			t.Errorf("%s %s: %s matches: %s", tc.containerID, tc.criteria, m["TotalMatches"], m["Result"])
+		}
+		for _, title := range tc.titles {
+			if !strings.Contains(m["Result"], "<dc:title>"+title+"</dc:title>") {
+				t.Errorf("%s %s: missing %s: %s", tc.containerID, tc.criteria, title, m["Result"])
+			}
+		}
+	}
+
+	// The well-known containers can be browsed too.
+	m := handle("Browse", []byte(`<u:Browse><ObjectID>1</ObjectID><BrowseFlag>BrowseDirectChildren</BrowseFlag></u:Browse>`))
+	if m["TotalMatches"] != "1" || !strings.Contains(m["Result"], `<container id="4" parentID="1"`) {
+		t.Fatal(m)
+	}
+	m = handle("Browse", []byte(`<u:Browse><ObjectID>8</ObjectID><BrowseFlag>BrowseDirectChildren</BrowseFlag></u:Browse>`))
+	if m["TotalMatches"] != "2" {
+		t.Fatal(m)
+	}
+
+	_, err := cds.Handle("Search", searchArgs("0", `dc:title like "x"`), r)
+	if e, ok := err.(*upnp.Error); !ok || e.Code != upnpav.InvalidSearchCriteriaErrorCode {
+		t.Fatal(err)
+	}
+	_, err = cds.Handle("Search", searchArgs("%2FNowhere", "*"), r)
+	if e, ok := err.(*upnp.Error); !ok || e.Code != upnpav.NoSuchContainerErrorCode {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	b := memBackend{
+		"/电影/流浪地球.mkv":       nil,
+		"/電視劇/長安十二時辰/01.mp4": nil,
+		"/Music/Song.mp3":    nil,
+	}
+	s := newTestServer(b)
+	s.index.requestInterval = 0
+	if err := s.index.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	// Only the index knows of what's gone since.
+	delete(b, "/Music/Song.mp3")
+	cds := s.services["ContentDirectory"]
+	r := httptest.NewRequest("POST", "/ctl", nil)
+	r.Host = "host"
+	for _, tc := range []struct {
+		criteria string
+		titles   []string
+	}{
+		{`dc:title contains "lldq"`, []string{"流浪地球"}},
+		{`dc:title contains "LiuLang"`, []string{"流浪地球"}},
+		{`dc:title contains "长安"`, []string{"長安十二時辰"}},
+		{`dc:title contains "casesc"`, []string{"長安十二時辰"}},
+		{`dc:title contains "电视剧"`, []string{"電視劇"}},
+		{`dc:title = "ＳＯＮＧ"`, []string{"Song"}},
+	} {
+		resp, err := cds.Handle("Search", searchArgs("0", tc.criteria), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := resp[0][1]
+		if resp[2][1] != fmt.Sprint(len(tc.titles)) {
+			t.Errorf("%s: %s", tc.criteria, result)
+		}
+		for _, title := range tc.titles {
+			if !strings.Contains(result, "<dc:title>"+title+"</dc:title>") {
+				t.Errorf("%s: missing %s: %s", tc.criteria, title, result)
+			}
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package dms
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofly/alipan-dms/dlna"
+	"github.com/gofly/alipan-dms/playlist"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+// Relays the streams .strm and .url files, and playlists' HTTPS entries, link
+// to.
+const streamPath = "/stream"
+
+const hlsPlaylistMimeType = "application/vnd.apple.mpegurl"
+
+// Largest HLS playlist that's relayed.
+const maxRelayedPlaylistSize = 1 << 20
+
+// Returns the MIME type of a stream by its URL. Streams with no media
+// extension are taken to be IPTV transport streams.
+func streamMimeType(streamURL string) mimeType {
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return "video/mp2t"
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	if ext == ".m3u8" {
+		return hlsPlaylistMimeType
+	}
+	if mt := mimeType(mime.TypeByExtension(ext)); mt.IsVideo() || mt.IsAudio() {
+		return mt
+	}
+	return "video/mp2t"
+}
+
+// Returns the URL of the relay of the stream a .strm or .url file links to,
+// or, given its "entry", of a playlist's entry.
+func (s *Server) streamURL(o object, host string, params url.Values) string {
+	q := url.Values{"path": {o.Path}}
+	for k, v := range params {
+		q[k] = v
+	}
+	return (&url.URL{
+		Scheme:   "http",
+		Host:     host,
+		Path:     streamPath,
+		RawQuery: q.Encode(),
+	}).String()
+}
+
+// Returns the URL a request to the relay is for: the one a .strm or .url file
+// links to, or that of the numbered entry of a playlist. Empty is returned if
+// there's none.
+func (s *Server) relayedURL(filePath string, fi os.FileInfo, q url.Values) string {
+	if playlist.StreamFormat(filePath) != "" {
+		u, _ := s.metadata.stream(filePath, fi, true)
+		return u
+	}
+	if playlist.Format(filePath) == "" {
+		return ""
+	}
+	entries, _ := s.metadata.playlist(filePath, fi, true)
+	i, err := strconv.Atoi(q.Get("entry"))
+	if err != nil || i < 0 || i >= len(entries) {
+		return ""
+	}
+	if _, ok := playlistEntryURL(entries[i].Location); !ok {
+		return ""
+	}
+	return entries[i].Location
+}
+
+// Returns the MIME-type of the stream a .strm or .url file links to, or false
+// if it's known not to link to one. Until the file's read, it's the type it
+// was indexed with, if any.
+func (s *Server) linkedStreamType(filePath string, fi os.FileInfo) (mimeType, bool) {
+	streamURL, known := s.metadata.stream(filePath, fi, false)
+	if known {
+		return streamMimeType(streamURL), streamURL != ""
+	}
+	if mt := fileMimeType(fi); mt.IsVideo() || mt.IsAudio() {
+		return mt, true
+	}
+	return streamMimeType(""), true
+}
+
+// Returns the broadcast item of a .strm or .url file. Nil is returned if the
+// file is known not to link to a stream.
+func (s *contentDirectoryService) streamItem(o object, fi os.FileInfo, host string, rp *rendererProfile) interface{} {
+	mt, ok := s.linkedStreamType(o.FilePath(), fi)
+	if !ok || s.hides(mt, rp) {
+		return nil
+	}
+	class := "object.item.videoItem.videoBroadcast"
+	if mt.IsAudio() {
+		class = "object.item.audioItem.audioBroadcast"
+	}
+	title, _ := s.objectTitle(o, fi)
+	return upnpav.Item{
+		Object: upnpav.Object{
+			ID:         o.ID(),
+			ParentID:   o.ParentID(),
+			Restricted: 1,
+			Class:      class,
+			Title:      rp.title(title),
+			Date:       upnpav.Timestamp{Time: fi.ModTime()},
+		},
+		Res: []upnpav.Resource{{
+			URL:          s.streamURL(o, host, nil),
+			ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", rp.mimeType(mt), dlna.ContentFeatures{}.String()),
+		}},
+	}
+}
+
+// The URI attributes of HLS tags, like those of keys and variant renditions.
+var hlsURIAttrRE = regexp.MustCompile(`URI="([^"]*)"`)
+
+// Rewrites the URIs in an HLS playlist, of segments, keys and variant
+// playlists, to the URLs relay returns for them, resolved against the
+// playlist's URL. URIs that aren't HTTP or HTTPS are only made absolute.
+func relayHLSPlaylist(data []byte, base *url.URL, relay func(u string) string) []byte {
+	rewrite := func(uri string) string {
+		ref, err := url.Parse(strings.TrimSpace(uri))
+		if err != nil {
+			return uri
+		}
+		u := base.ResolveReference(ref)
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https":
+			return relay(u.String())
+		}
+		return u.String()
+	}
+	var buf bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, maxRelayedPlaylistSize)
+	for sc.Scan() {
+		line := sc.Text()
+		switch t := strings.TrimSpace(line); {
+		case t == "":
+		case strings.HasPrefix(t, "#"):
+			line = hlsURIAttrRE.ReplaceAllStringFunc(line, func(attr string) string {
+				return `URI="` + rewrite(hlsURIAttrRE.FindStringSubmatch(attr)[1]) + `"`
+			})
+		default:
+			line = rewrite(t)
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Signs a URL found in a playlist relayed for a file, or a playlist's entry,
+// so that the relay fetches only what the file's stream leads to.
+func (s *Server) relaySignature(o object, entry, u string) string {
+	mac := hmac.New(sha256.New, s.relayKey)
+	fmt.Fprintf(mac, "%s\x00%s\x00%s", o.Path, entry, u)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Relays streams, giving up on servers that don't respond.
+var relayClient = &http.Client{Transport: newUpstreamTransport()}
+
+func isHLSPlaylistPath(p string) bool {
+	return strings.HasSuffix(strings.ToLower(p), ".m3u8")
+}
+
+// Relays the stream a .strm or .url file, or a playlist's entry, links to,
+// following redirects, for renderers that can't fetch it themselves, like
+// those without HTTPS.
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	o := object{path.Clean("/" + q.Get("path")), s.RootObjectPath}
+	filePath := o.FilePath()
+	fi, err := s.Backend.Stat(filePath)
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if ignored, err := s.IgnorePath(filePath, fi); err != nil || ignored {
+		http.NotFound(w, r)
+		return
+	}
+	streamURL := s.relayedURL(filePath, fi, q)
+	if streamURL == "" {
+		http.NotFound(w, r)
+		return
+	}
+	// A segment, key or variant of an HLS stream that the stream's playlists
+	// led to.
+	if u := q.Get("u"); u != "" {
+		if !hmac.Equal([]byte(q.Get("sig")), []byte(s.relaySignature(o, q.Get("entry"), u))) {
+			http.Error(w, "bad signature", http.StatusForbidden)
+			return
+		}
+		streamURL = u
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, streamURL, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	// Playlists are rewritten whole, so aren't asked for in part.
+	if rg := r.Header.Get("Range"); rg != "" && !isHLSPlaylistPath(req.URL.Path) {
+		req.Header.Set("Range", rg)
+	}
+	resp, err := relayClient.Do(req)
+	if err != nil {
+		s.Logger.Printf("error relaying %s: %s", o.Path, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		http.Error(w, "stream: "+resp.Status, http.StatusBadGateway)
+		return
+	}
+	for _, h := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.Header().Set(dlna.ContentFeaturesDomain, dlna.ContentFeatures{}.String())
+	w.Header().Set(dlna.TransferModeDomain, "Streaming")
+	ct := strings.ToLower(resp.Header.Get("Content-Type"))
+	// Part of a playlist can't be rewritten, and is passed on as it is.
+	if r.Method == http.MethodGet && resp.StatusCode == http.StatusOK && (strings.Contains(ct, "mpegurl") || isHLSPlaylistPath(resp.Request.URL.Path)) {
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxRelayedPlaylistSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		data = relayHLSPlaylist(data, resp.Request.URL, func(u string) string {
+			params := url.Values{"u": {u}, "sig": {s.relaySignature(o, q.Get("entry"), u)}}
+			if entry := q.Get("entry"); entry != "" {
+				params.Set("entry", entry)
+			}
+			return s.streamURL(o, r.Host, params)
+		})
+		w.Header().Del("Content-Range")
+		w.Header().Del("Accept-Ranges")
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.WriteHeader(resp.StatusCode)
+		w.Write(data)
+		return
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
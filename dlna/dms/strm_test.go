@@ -0,0 +1,130 @@
+package dms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func TestStreamItems(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/live":
+			http.Redirect(w, r, "/live/stream.ts", http.StatusFound)
+		case "/live/stream.ts":
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Write([]byte("transport stream"))
+		case "/hls/index.m3u8":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader("#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n#EXTINF:10,\nseg0.ts\n"))
+		case "/hls/seg0.ts":
+			w.Write([]byte("segment"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+	s := newTestServer(memBackend{
+		"/TV/News.strm":     []byte("# news channel\n" + origin.URL + "/live\n"),
+		"/TV/Sports.strm":   []byte(origin.URL + "/hls/index.m3u8\n"),
+		"/TV/Broken.strm":   []byte("nothing here\n"),
+		"/Radio/Jazz.url":   []byte("[InternetShortcut]\r\nURL=" + origin.URL + "/jazz.mp3\r\n"),
+		"/Radio/readme.txt": nil,
+	})
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	items := make(map[string]upnpav.Item)
+	for _, dir := range []string{"/TV", "/Radio"} {
+		objs, err := cds.readContainer(object{dir, "/"}, "host", genericRenderer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range objs {
+			item := obj.(upnpav.Item)
+			items[item.Title] = item
+		}
+	}
+	if len(items) != 3 {
+		t.Fatal(items)
+	}
+	news := items["News"]
+	if news.Class != "object.item.videoItem.videoBroadcast" || news.Res[0].URL != "http://host/stream?path=%2FTV%2FNews.strm" {
+		t.Fatal(news)
+	}
+	if jazz := items["Jazz"]; jazz.Class != "object.item.audioItem.audioBroadcast" || !strings.Contains(jazz.Res[0].ProtocolInfo, ":audio/mpeg:") {
+		t.Fatal(jazz)
+	}
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		s.httpServeMux.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get("/stream?path=%2FTV%2FNews.strm"); rec.Code != 200 || rec.Body.String() != "transport stream" || rec.Header().Get("Content-Type") != "video/mp2t" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	// HLS playlists are relayed along with their segments and keys.
+	rec := get("/stream?path=%2FTV%2FSports.strm")
+	segments := playlistSegments(rec.Body.String())
+	if rec.Code != 200 || len(segments) != 1 || strings.Contains(rec.Body.String(), origin.URL) ||
+		!strings.Contains(rec.Body.String(), `URI="http://example.com/stream?path=`) {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	// Playlists are relayed whole, even when asked for in part.
+	if rec := get("/stream?path=%2FTV%2FSports.strm", "Range", "bytes=0-9"); rec.Code != 200 || len(playlistSegments(rec.Body.String())) != 1 {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	segment := strings.TrimPrefix(segments[0], "http://example.com")
+	if rec := get(segment); rec.Code != 200 || rec.Body.String() != "segment" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	// Only URLs the playlists lead to are relayed.
+	if rec := get(strings.Replace(segment, "seg0", "seg1", 1)); rec.Code != http.StatusForbidden {
+		t.Fatal(rec.Code)
+	}
+	if rec := get("/stream?path=%2FRadio%2FJazz.url"); rec.Code != http.StatusBadGateway {
+		t.Fatal(rec.Code)
+	}
+	if rec := get("/stream?path=%2FTV%2FBroken.strm"); rec.Code != http.StatusNotFound {
+		t.Fatal(rec.Code)
+	}
+}
+
+func TestStreamChildCount(t *testing.T) {
+	b := memBackend{
+		"/Links/News.strm": []byte("http://tv.example.com/news.ts\n"),
+		"/Links/Jazz.url":  []byte("[InternetShortcut]\nURL=https://radio.example.com/jazz.mp3\n"),
+		"/Links/Bad.strm":  []byte("nothing\n"),
+	}
+	s := newTestServer(b)
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	if _, err := cds.readContainer(object{"/Links", "/"}, "host", genericRenderer); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := s.childCount(object{"/Links", "/"}, genericRenderer); !ok || n != 2 {
+		t.Fatal(n, ok)
+	}
+
+	s = newTestServer(b)
+	s.index.requestInterval = 0
+	if err := s.index.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := s.childCount(object{"/Links", "/"}, genericRenderer); !ok || n != 2 {
+		t.Fatal(n, ok)
+	}
+	cds = s.services["ContentDirectory"].(*contentDirectoryService)
+	for view, class := range map[string]string{"videos": "object.item.videoItem.videoBroadcast", "music": "object.item.audioItem.audioBroadcast"} {
+		objs, err := cds.readVirtualContainer(view, views[view], searchAll{}, "host", genericRenderer)
+		if err != nil || len(objs) != 1 || upnpavObject(objs[0]).Class != class {
+			t.Fatal(view, objs, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package dms
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	subs "github.com/gofly/alipan-dms/subtitle"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+const (
+	subtitlePath = "/subtitle"
+	// Larger files aren't subtitles.
+	maxSubtitleSize = 16 << 20
+)
+
+// Sidecar subtitle formats by extension, with the MIME-types renderers expect.
+var subtitleMimeTypes = map[string]mimeType{
+	".srt": "text/srt",
+	".ass": "text/ass",
+	".ssa": "text/ssa",
+	".smi": "text/smi",
+	".vtt": "text/vtt",
+}
+
+// A sidecar subtitle file.
+type subtitle struct {
+	Path string
+	Info os.FileInfo
+	// Format, from the extension without the dot, like "srt".
+	Format string
+	// Language suffix, like "zh" in "movie.zh.srt". Empty if there's none.
+	Lang string
+}
+
+func (st subtitle) MimeType() mimeType {
+	return subtitleMimeTypes["."+st.Format]
+}
+
+// Returns st as it's served in format, where empty keeps its own format.
+func (st subtitle) as(format string) subtitle {
+	if format != "" && subs.Supported(format) && subs.Supported(st.Format) {
+		st.Format = format
+	}
+	return st
+}
+
+// Returns the position of the subtitle's language in langs, or len(langs) if
+// it isn't there. "zh" matches the languages "zh", "zh-cn" and "zh.forced".
+func (st subtitle) langRank(langs []string) int {
+	lang, _, _ := strings.Cut(st.Lang, ".")
+	for i, l := range langs {
+		if l = strings.ToLower(l); lang == l || strings.HasPrefix(lang, l+"-") {
+			return i
+		}
+	}
+	return len(langs)
+}
+
+// Returns the subtitles accompanying the video at filePath in its directory
+// listing. They share the video's base name, optionally followed by a
+// language: "movie.srt", "movie.zh.srt", "movie.en.forced.ass". Those in the
+// languages come first, in their order, then SRT, as it's the most widely
+// supported.
+func subtitlesFor(dl *dirListing, filePath string, langs []string) (ret []subtitle) {
+	base := strings.ToLower(path.Base(filePath))
+	base = strings.TrimSuffix(base, path.Ext(base))
+	for _, fi := range dl.fis {
+		name := strings.ToLower(fi.Name())
+		if fi.IsDir() || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		ext := path.Ext(name)
+		if _, ok := subtitleMimeTypes[ext]; !ok {
+			continue
+		}
+		ret = append(ret, subtitle{
+			Path:   path.Join(path.Dir(filePath), fi.Name()),
+			Info:   fi,
+			Format: ext[1:],
+			Lang:   strings.TrimPrefix(strings.TrimSuffix(name, ext)[len(base):], "."),
+		})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ri, rj := ret[i].langRank(langs), ret[j].langRank(langs); ri != rj {
+			return ri < rj
+		}
+		return ret[i].Format == "srt" && ret[j].Format != "srt"
+	})
+	return
+}
+
+// Returns the URL of a subtitle of the video object. The format is only given
+// when it's converted.
+func (s *Server) subtitleURL(video object, st subtitle, host string) string {
+	q := url.Values{"path": {path.Join(path.Dir(video.Path), st.Info.Name())}}
+	if !strings.EqualFold(path.Ext(st.Info.Name()), "."+st.Format) {
+		q.Set("format", st.Format)
+	}
+	return (&url.URL{
+		Scheme:   "http",
+		Host:     host,
+		Path:     subtitlePath,
+		RawQuery: q.Encode(),
+	}).String()
+}
+
+// Adds subtitle resources and Samsung caption info for the sidecar subtitles
+// of a video item, in the format preferred by the renderer.
+func (s *Server) addSubtitles(item *upnpav.Item, video object, sts []subtitle, host string, rp *rendererProfile) {
+	for _, st := range sts {
+		st = st.as(rp.SubtitleFormat)
+		u := s.subtitleURL(video, st, host)
+		item.Res = append(item.Res, upnpav.Resource{
+			URL:          u,
+			ProtocolInfo: "http-get:*:" + st.MimeType().String() + ":*",
+		})
+		item.CaptionInfo = append(item.CaptionInfo, upnpav.CaptionInfo{
+			Type: st.Format,
+			URL:  u,
+		})
+	}
+}
+
+// Returns the sidecar subtitles of the video at filePath, listing its
+// directory if necessary.
+func (s *Server) subtitles(filePath string) ([]subtitle, error) {
+	dl, err := s.listings.get(path.Dir(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return subtitlesFor(dl, filePath, s.SubtitleLanguages), nil
+}
+
+// Serves a subtitle file as UTF-8, converted to the format given by the
+// "format" parameter if there is one. The source encoding is detected unless
+// given by the "charset" parameter.
+func (s *Server) serveSubtitle(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	o := object{path.Clean("/" + q.Get("path")), s.RootObjectPath}
+	filePath := o.FilePath()
+	from := strings.ToLower(path.Ext(filePath))
+	if _, ok := subtitleMimeTypes[from]; !ok {
+		http.Error(w, "not a subtitle", http.StatusNotFound)
+		return
+	}
+	from = from[1:]
+	to := q.Get("format")
+	if to == "" {
+		to = from
+	}
+	mt, ok := subtitleMimeTypes["."+to]
+	if !ok || to != from && !(subs.Supported(from) && subs.Supported(to)) {
+		http.Error(w, "bad format", http.StatusBadRequest)
+		return
+	}
+	fi, err := s.Backend.Stat(filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if fi.Size() > maxSubtitleSize {
+		http.Error(w, "subtitle too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	rc, err := s.streams.open(r.Context(), requestClient(r), func() (io.ReadCloser, error) {
+		return s.Backend.ReadStream(filePath)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, maxSubtitleSize))
+	rc.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	var text string
+	if charset := q.Get("charset"); charset != "" {
+		text, _ = subs.DecodeTextAs(data, charset)
+	} else {
+		text, _ = subs.DecodeText(data)
+	}
+	out, err := subs.Convert(text, from, to)
+	if err != nil {
+		s.Logger.Printf("error converting %s to %s: %s", o.Path, to, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", mt.String())
+	http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(out))
+}
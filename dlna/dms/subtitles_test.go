@@ -0,0 +1,136 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func TestSubtitlesFor(t *testing.T) {
+	b := memBackend{
+		"/TV/Movie.mkv":       nil,
+		"/TV/Movie.zh.ass":    nil,
+		"/TV/movie.SRT":       nil,
+		"/TV/Movie.en.vtt":    nil,
+		"/TV/Movie 2.srt":     nil,
+		"/TV/Movie.nfo":       nil,
+		"/TV/Movies.srt":      nil,
+		"/TV/Movie.chs.smi":   nil,
+		"/TV/Movie.forced.en": nil,
+	}
+	fis, _ := b.ReadDir("/TV")
+	dl := newListingCache(b).note("/TV", fis)
+	for _, c := range []struct {
+		langs []string
+		want  []string
+	}{
+		{nil, []string{"srt:", "smi:chs", "vtt:en", "ass:zh"}},
+		// Preferred languages come first, in order.
+		{[]string{"ZH", "en"}, []string{"ass:zh", "vtt:en", "srt:", "smi:chs"}},
+	} {
+		var got []string
+		for _, st := range subtitlesFor(dl, "/TV/Movie.mkv", c.langs) {
+			got = append(got, st.Format+":"+st.Lang)
+		}
+		if strings.Join(got, " ") != strings.Join(c.want, " ") {
+			t.Fatal(c.langs, got)
+		}
+	}
+}
+
+func TestCaptionInfo(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/TV/Movie.mkv":    []byte("0123456789"),
+		"/TV/Movie.en.srt": []byte("1\n00:00:01,000 --> 00:00:02,000\nhello\n"),
+		"/TV/Movie.zh.srt": []byte("1\n00:00:01,000 --> 00:00:02,000\nhi\n"),
+	})
+	s.SubtitleLanguages = []string{"zh"}
+	objs, err := s.services["ContentDirectory"].(*contentDirectoryService).readContainer(object{"/TV", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := objs[0].(upnpav.Item)
+	b, _ := xml.Marshal(item)
+	for _, want := range []string{
+		`<res protocolInfo="http-get:*:text/srt:*">http://host/subtitle?path=%2FTV%2FMovie.zh.srt</res>`,
+		`<sec:CaptionInfoEx sec:type="srt">http://host/subtitle?path=%2FTV%2FMovie.zh.srt</sec:CaptionInfoEx>`,
+	} {
+		if !bytes.Contains(b, []byte(want)) {
+			t.Fatal(string(b))
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/res?path=%2FTV%2FMovie.mkv", nil)
+	req.Host = "host"
+	req.Header.Set("getCaptionInfo.sec", "1")
+	req.Header.Set("Range", "bytes=2-5")
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, req)
+	if rec.Code != 206 || rec.Body.String() != "2345" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("CaptionInfo.sec"); got != "http://host/subtitle?path=%2FTV%2FMovie.zh.srt" {
+		t.Fatal(got)
+	}
+
+	rec = httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", "/subtitle?path=%2FTV%2FMovie.zh.srt", nil))
+	if body, _ := io.ReadAll(rec.Body); rec.Code != 200 || !bytes.Contains(body, []byte("hi")) {
+		t.Fatal(rec.Code, string(body))
+	}
+}
+
+func TestSubtitleConversion(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String("[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+		"Dialogue: 0,0:00:01.00,0:00:02.50,Default,,0,0,0,,{\\b1}你好{\\b0}\\N世界\n")
+	s := newTestServer(memBackend{
+		"/TV/Movie.mkv":    nil,
+		"/TV/Movie.zh.ass": []byte(gbk),
+	})
+	s.SubtitleFormats = map[string]string{"Player": "vtt", "Player/raw": ""}
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	for _, tc := range []struct {
+		userAgent, res, caption string
+	}{
+		{"SEC_HHP_[TV] Samsung", `<res protocolInfo="http-get:*:text/srt:*">http://host/subtitle?format=srt&amp;path=%2FTV%2FMovie.zh.ass</res>`, `sec:type="srt"`},
+		{"Kodi", `<res protocolInfo="http-get:*:text/ass:*">http://host/subtitle?path=%2FTV%2FMovie.zh.ass</res>`, `sec:type="ass"`},
+		{"Player/1.0", `<res protocolInfo="http-get:*:text/vtt:*">http://host/subtitle?format=vtt&amp;path=%2FTV%2FMovie.zh.ass</res>`, `sec:type="vtt"`},
+		{"Player/raw", `<res protocolInfo="http-get:*:text/ass:*">`, `sec:type="ass"`},
+	} {
+		objs, err := cds.readContainer(object{"/TV", "/"}, "host", s.renderer(http.Header{"User-Agent": {tc.userAgent}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := xml.Marshal(objs[0].(upnpav.Item))
+		if !bytes.Contains(b, []byte(tc.res)) || !bytes.Contains(b, []byte(tc.caption)) {
+			t.Errorf("%s: %s", tc.userAgent, b)
+		}
+	}
+
+	for _, tc := range []struct {
+		query, contentType, body string
+	}{
+		{"format=srt&path=%2FTV%2FMovie.zh.ass", "text/srt", "1\r\n00:00:01,000 --> 00:00:02,500\r\n你好\r\n世界\r\n\r\n"},
+		{"format=vtt&path=%2FTV%2FMovie.zh.ass", "text/vtt", "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\n你好\n世界\n\n"},
+		{"path=%2FTV%2FMovie.zh.ass", "text/ass", "[Events]"},
+	} {
+		rec := httptest.NewRecorder()
+		s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", "/subtitle?"+tc.query, nil))
+		if rec.Code != 200 || rec.Header().Get("Content-Type") != tc.contentType || !strings.HasPrefix(rec.Body.String(), tc.body) {
+			t.Errorf("%s: %d %s %q", tc.query, rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", "/subtitle?format=txt&path=%2FTV%2FMovie.zh.ass", nil))
+	if rec.Code != 400 {
+		t.Error(rec.Code)
+	}
+}
@@ -0,0 +1,240 @@
+package dms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"sync"
+
+	"github.com/gofly/alipan-dms/dlna"
+	"github.com/gofly/alipan-dms/exif"
+	"github.com/gofly/alipan-dms/thumbnail"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+const (
+	thumbnailPath = "/thumbnail"
+	// Larger images aren't read to make thumbnails of.
+	maxThumbnailSourceSize = 64 << 20
+)
+
+// A DLNA image profile that thumbnails are generated for.
+type thumbnailProfile struct {
+	Name          string
+	MimeType      mimeType
+	Width, Height int
+}
+
+var (
+	jpegTN = thumbnailProfile{"JPEG_TN", "image/jpeg", 160, 160}
+	jpegSM = thumbnailProfile{"JPEG_SM", "image/jpeg", 640, 480}
+	pngTN  = thumbnailProfile{"PNG_TN", "image/png", 160, 160}
+)
+
+var thumbnailProfiles = map[string]thumbnailProfile{
+	jpegTN.Name: jpegTN,
+	jpegSM.Name: jpegSM,
+	pngTN.Name:  pngTN,
+}
+
+// Scales and orients img for the profile.
+func (tp thumbnailProfile) encode(img image.Image, orientation int) ([]byte, error) {
+	w, h := tp.Width, tp.Height
+	if orientation >= 5 {
+		w, h = h, w
+	}
+	scaled := thumbnail.Orient(thumbnail.Fit(img, w, h), orientation)
+	if tp.MimeType == "image/png" {
+		return thumbnail.EncodePNG(scaled)
+	}
+	return thumbnail.EncodeJPEG(scaled)
+}
+
+// Returns the resolution of the profile's thumbnail of an image.
+func (tp thumbnailProfile) resolution(mi mediaInfo) string {
+	w, h := mi.displaySize()
+	if w == 0 || h == 0 {
+		return ""
+	}
+	w, h = thumbnail.FitSize(w, h, tp.Width, tp.Height)
+	return fmt.Sprintf("%dx%d", w, h)
+}
+
+func (tp thumbnailProfile) protocolInfo() string {
+	return fmt.Sprintf("http-get:*:%s:%s", tp.MimeType, dlna.ContentFeatures{
+		ProfileName: tp.Name,
+		Transcoded:  true,
+	}.String())
+}
+
+// Generates thumbnails and caches them on disk.
+type thumbnailService struct {
+	// Nil if thumbnails aren't cached.
+	cache *thumbnail.Cache
+	// Limits concurrent decodes, which are memory hungry for large photos.
+	decodes  chan struct{}
+	mu       sync.Mutex
+	inflight map[string]*thumbnailCall
+}
+
+type thumbnailCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newThumbnailService(cacheDir string) *thumbnailService {
+	ts := &thumbnailService{
+		decodes:  make(chan struct{}, runtime.NumCPU()),
+		inflight: make(map[string]*thumbnailCall),
+	}
+	if cacheDir != "" {
+		ts.cache = &thumbnail.Cache{Dir: cacheDir}
+	}
+	return ts
+}
+
+// Returns the thumbnail for the image opened by open, which is identified by
+// key for caching. Each thumbnail is made only once at a time.
+func (ts *thumbnailService) get(key string, tp thumbnailProfile, open func() (io.ReadCloser, error)) ([]byte, error) {
+	key += "\x00" + tp.Name
+	if ts.cache != nil {
+		if data, ok := ts.cache.Get(key); ok {
+			return data, nil
+		}
+	}
+	ts.mu.Lock()
+	if c, ok := ts.inflight[key]; ok {
+		ts.mu.Unlock()
+		<-c.done
+		return c.data, c.err
+	}
+	c := &thumbnailCall{done: make(chan struct{})}
+	ts.inflight[key] = c
+	ts.mu.Unlock()
+	c.data, c.err = ts.generate(key, tp, open)
+	ts.mu.Lock()
+	delete(ts.inflight, key)
+	ts.mu.Unlock()
+	close(c.done)
+	return c.data, c.err
+}
+
+func (ts *thumbnailService) generate(key string, tp thumbnailProfile, open func() (io.ReadCloser, error)) ([]byte, error) {
+	ts.decodes <- struct{}{}
+	data, err := func() ([]byte, error) {
+		defer func() { <-ts.decodes }()
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		src, err := io.ReadAll(io.LimitReader(rc, maxThumbnailSourceSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(src) > maxThumbnailSourceSize {
+			return nil, errors.New("image too large")
+		}
+		var orientation int
+		if info, _ := exif.DecodeJPEG(bytes.NewReader(src)); info != nil {
+			orientation = info.Orientation
+		}
+		img, _, err := image.Decode(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		return tp.encode(img, orientation)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	if ts.cache != nil {
+		if err := ts.cache.Put(key, data); err != nil {
+			return data, fmt.Errorf("caching thumbnail: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// Returns the profiles that thumbnails are offered in for an image.
+func thumbnailProfilesFor(mt mimeType) []thumbnailProfile {
+	switch mt {
+	case "image/jpeg", "image/gif":
+		return []thumbnailProfile{jpegSM, jpegTN}
+	case "image/png":
+		return []thumbnailProfile{jpegSM, jpegTN, pngTN}
+	}
+	return nil
+}
+
+func (s *Server) thumbnailURL(o object, host string, tp thumbnailProfile) string {
+	return (&url.URL{
+		Scheme: "http",
+		Host:   host,
+		Path:   thumbnailPath,
+		RawQuery: url.Values{
+			"path":    {o.Path},
+			"profile": {tp.Name},
+		}.Encode(),
+	}).String()
+}
+
+// Adds the thumbnail resources and icon to an image item.
+func (s *Server) addThumbnails(item *upnpav.Item, o object, host string, mt mimeType, mi mediaInfo) {
+	for _, tp := range thumbnailProfilesFor(mt) {
+		item.Res = append(item.Res, upnpav.Resource{
+			URL:          s.thumbnailURL(o, host, tp),
+			ProtocolInfo: tp.protocolInfo(),
+			Resolution:   tp.resolution(mi),
+		})
+		if tp == jpegTN {
+			item.Icon = s.thumbnailURL(o, host, tp)
+		}
+	}
+}
+
+// Opens an image to make thumbnails of, through the read-ahead cache. The
+// thumbnails are shared by whoever asks for them, so the read isn't any one
+// client's.
+func (s *Server) openImage(filePath string, fi os.FileInfo) io.ReadCloser {
+	return s.openStream(context.Background(), "", filePath, fi)
+}
+
+func (s *Server) serveThumbnail(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tp, ok := thumbnailProfiles[q.Get("profile")]
+	if !ok {
+		http.Error(w, "bad profile", http.StatusBadRequest)
+		return
+	}
+	o := object{path.Clean("/" + q.Get("path")), s.RootObjectPath}
+	filePath := o.FilePath()
+	fi, err := s.Backend.Stat(filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	data, err := s.thumbnails.get(fileKey(filePath, fi), tp, func() (io.ReadCloser, error) {
+		return s.openImage(filePath, fi), nil
+	})
+	if err != nil {
+		s.Logger.Printf("error making %s thumbnail of %s: %s", tp.Name, o.Path, err)
+		if data == nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", tp.MimeType.String())
+	w.Header().Set(dlna.ContentFeaturesDomain, dlna.ContentFeatures{ProfileName: tp.Name}.String())
+	w.Header().Set(dlna.TransferModeDomain, "Interactive")
+	http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(data))
+}
@@ -0,0 +1,82 @@
+package dms
+
+import (
+	"bytes"
+	"image/jpeg"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func TestImageItemThumbnails(t *testing.T) {
+	s := newTestServer(memBackend{"/Photos/a.png": testPNG(1000, 500)})
+	s.CacheDir = t.TempDir()
+	s.thumbnails = newThumbnailService(s.cacheSubdir("thumbnails"))
+	objs, err := s.services["ContentDirectory"].(*contentDirectoryService).readContainer(object{"/Photos", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := objs[0].(upnpav.Item)
+	if len(item.Res) != 4 {
+		t.Fatalf("%#v", item.Res)
+	}
+	if !strings.Contains(item.Res[1].ProtocolInfo, "DLNA.ORG_PN=JPEG_SM") {
+		t.Fatal(item.Res[1].ProtocolInfo)
+	}
+	if item.Icon != item.Res[2].URL {
+		t.Fatal(item.Icon)
+	}
+
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", strings.TrimPrefix(item.Res[1].URL, "http://host"), nil))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	img, err := jpeg.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 640 || b.Dy() != 320 {
+		t.Fatal(b)
+	}
+	cached, _ := filepath.Glob(filepath.Join(s.CacheDir, "thumbnails", "*", "*"))
+	if len(cached) != 1 {
+		t.Fatal(cached)
+	}
+}
+
+func TestThumbnailMadeOnce(t *testing.T) {
+	ts := newThumbnailService(filepath.Join(t.TempDir(), "thumbnails"))
+	var opens int32
+	started, release := make(chan struct{}), make(chan struct{})
+	open := func() (io.ReadCloser, error) {
+		atomic.AddInt32(&opens, 1)
+		<-release
+		return io.NopCloser(bytes.NewReader(testPNG(10, 10))), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started <- struct{}{}
+			if _, err := ts.get("key", jpegTN, open); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-started
+	}
+	close(release)
+	wg.Wait()
+	if opens != 1 {
+		t.Fatal(opens)
+	}
+}
@@ -0,0 +1,209 @@
+package dms
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofly/alipan-dms/dlna"
+	"github.com/gofly/alipan-dms/mp4"
+	"github.com/gofly/alipan-dms/mpegts"
+)
+
+// How many files' seek indexes are kept. Each holds an MP4's sample tables,
+// or a transport stream's packet layout.
+const maxSeekIndexes = 16
+
+func isMPEGTS(mt mimeType) bool {
+	switch mt {
+	case "video/mp2t", "video/vnd.dlna.mpeg-tts":
+		return true
+	}
+	return false
+}
+
+// Returns whether TimeSeekRange.dlna.org requests are supported for the
+// MIME-type.
+func supportsTimeSeek(mt mimeType) bool {
+	return isMP4(mt) || isMPEGTS(mt)
+}
+
+// Maps presentation times in a file to byte offsets.
+type seekIndex interface {
+	// Returns the offset to start playing from to reach d, and the time
+	// playing from there starts at, which is at or before d.
+	seek(d time.Duration) (int64, time.Duration, error)
+	duration() time.Duration
+}
+
+type mp4SeekIndex struct {
+	tracks []*mp4.Track
+	length time.Duration
+}
+
+func (mi *mp4SeekIndex) seek(d time.Duration) (int64, time.Duration, error) {
+	return mp4.Seek(mi.tracks, d)
+}
+
+func (mi *mp4SeekIndex) duration() time.Duration {
+	return mi.length
+}
+
+// Transport streams are searched with range reads for each seek, through a
+// reader that isn't safe for concurrent use.
+type tsSeekIndex struct {
+	mu     sync.Mutex
+	stream *mpegts.Stream
+	length time.Duration
+}
+
+func (ti *tsSeekIndex) seek(d time.Duration) (int64, time.Duration, error) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	return ti.stream.Seek(d)
+}
+
+func (ti *tsSeekIndex) duration() time.Duration {
+	return ti.length
+}
+
+// Returns the offset a cut of the stream at d starts decoding cleanly from:
+// the first keyframe after the PCR at or before d, provided it comes before
+// the PCR at or before d+within. Without one there, the PCR's offset is
+// returned.
+func (ti *tsSeekIndex) cut(d, within time.Duration) (int64, error) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	off, _, err := ti.stream.Seek(d)
+	if err != nil {
+		return 0, err
+	}
+	limit, _, err := ti.stream.Seek(d + within)
+	if err != nil {
+		return 0, err
+	}
+	if ra, ok, err := ti.stream.RandomAccess(off, limit); err != nil {
+		return 0, err
+	} else if ok {
+		return ra, nil
+	}
+	return off, nil
+}
+
+func (ti *tsSeekIndex) programTables() ([]byte, error) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	return ti.stream.ProgramTables()
+}
+
+// Builds and keeps the seek indexes of recently seeked files.
+type seekIndexCache struct {
+	backend Backend
+	mu      sync.Mutex
+	indexes map[string]seekIndex
+}
+
+func newSeekIndexCache(backend Backend) *seekIndexCache {
+	return &seekIndexCache{
+		backend: backend,
+		indexes: make(map[string]seekIndex),
+	}
+}
+
+func (sc *seekIndexCache) get(filePath string, fi os.FileInfo, mt mimeType) (seekIndex, error) {
+	key := fileKey(filePath, fi)
+	sc.mu.Lock()
+	si, ok := sc.indexes[key]
+	sc.mu.Unlock()
+	if ok {
+		return si, nil
+	}
+	ra := newBackendReaderAt(sc.backend, filePath, fi.Size())
+	if isMPEGTS(mt) {
+		stream, err := mpegts.Open(ra, fi.Size())
+		if err != nil {
+			return nil, err
+		}
+		length, err := stream.Duration()
+		if err != nil {
+			return nil, err
+		}
+		si = &tsSeekIndex{stream: stream, length: length}
+	} else {
+		tracks, err := mp4.ReadTracks(ra, fi.Size())
+		if err != nil {
+			return nil, err
+		}
+		mi := &mp4SeekIndex{tracks: tracks}
+		for _, t := range tracks {
+			if l := t.Length(); l > mi.length {
+				mi.length = l
+			}
+		}
+		si = mi
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(sc.indexes) >= maxSeekIndexes {
+		for k := range sc.indexes {
+			delete(sc.indexes, k)
+			break
+		}
+	}
+	sc.indexes[key] = si
+	return si, nil
+}
+
+// Serves the part of a file given by a TimeSeekRange.dlna.org header.
+func (s *Server) serveTimeSeek(w http.ResponseWriter, r *http.Request, filePath string, fi os.FileInfo, mt mimeType) {
+	if !supportsTimeSeek(mt) {
+		http.Error(w, "time seek not supported", http.StatusNotAcceptable)
+		return
+	}
+	npt, err := dlna.ParseNPTRange(strings.TrimPrefix(strings.TrimSpace(r.Header.Get(dlna.TimeSeekRangeDomain)), "npt="))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	si, err := s.seekIndexes.get(filePath, fi, mt)
+	if err != nil {
+		s.Logger.Printf("error indexing %s for time seek: %s", filePath, err)
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	length := si.duration()
+	if npt.Start >= length {
+		http.Error(w, "seek past end", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	start, startTime, err := si.seek(npt.Start)
+	if err != nil {
+		s.Logger.Printf("error seeking %s to %s: %s", filePath, npt.Start, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	end, endTime := fi.Size()-1, length
+	if npt.End > npt.Start && npt.End < length {
+		if off, t, err := si.seek(npt.End); err == nil && off > start {
+			end, endTime = off-1, t
+		}
+	}
+	w.Header().Set(dlna.TimeSeekRangeDomain, fmt.Sprintf("npt=%s-%s/%s",
+		dlna.FormatNPTTime(startTime), dlna.FormatNPTTime(endTime), dlna.FormatNPTTime(length)))
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fi.Size()))
+	w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	rs := s.openStream(r.Context(), requestClient(r), filePath, fi)
+	defer rs.Close()
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return
+	}
+	io.Copy(w, io.LimitReader(rs, end-start+1))
+}
@@ -0,0 +1,99 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofly/alipan-dms/dlna"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+// A transport stream with a PCR every 10 packets, advancing 100ms each time.
+func testTS(packets int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < packets; i++ {
+		p := make([]byte, 188)
+		p[0], p[1], p[2], p[3] = 0x47, 0x01, 0x00, 0x30
+		if i%10 == 0 {
+			pcr := uint64(i/10) * 9000
+			p[4], p[5] = 7, 0x10
+			p[6], p[7], p[8], p[9], p[10] = byte(pcr>>25), byte(pcr>>17), byte(pcr>>9), byte(pcr>>1), byte(pcr<<7)
+		} else {
+			p[4] = 0
+		}
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
+
+func TestTimeSeek(t *testing.T) {
+	ts := testTS(20000)
+	s := newTestServer(memBackend{
+		"/Videos/clip.ts":  ts,
+		"/Videos/clip.mkv": []byte("matroska"),
+	})
+
+	objs, err := s.services["ContentDirectory"].(*contentDirectoryService).readContainer(object{"/Videos", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tsRes, mkvRes upnpav.Resource
+	for _, obj := range objs {
+		item := obj.(upnpav.Item)
+		if strings.HasSuffix(item.ID, ".ts") {
+			tsRes = item.Res[0]
+		} else {
+			mkvRes = item.Res[0]
+		}
+	}
+	if !strings.Contains(tsRes.ProtocolInfo, "DLNA.ORG_OP=11") || tsRes.Duration != "00:03:19.900" {
+		b, _ := xml.Marshal(tsRes)
+		t.Fatal(string(b))
+	}
+	if !strings.Contains(mkvRes.ProtocolInfo, "DLNA.ORG_OP=01") {
+		t.Fatal(mkvRes.ProtocolInfo)
+	}
+
+	req := httptest.NewRequest("GET", "/res?path=%2FVideos%2Fclip.ts", nil)
+	req.Header.Set(dlna.TimeSeekRangeDomain, "npt=100-")
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	var start, end, size int64
+	if _, err := fmt.Sscanf(rec.Header().Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size); err != nil {
+		t.Fatal(rec.Header())
+	}
+	tsr := rec.Header().Get(dlna.TimeSeekRangeDomain)
+	if size != int64(len(ts)) || end != size-1 || start%1880 != 0 || !strings.HasSuffix(tsr, "-00:03:19.900/00:03:19.900") {
+		t.Fatal(rec.Header())
+	}
+	if !bytes.Equal(rec.Body.Bytes(), ts[start:]) {
+		t.Fatal("body doesn't match the range")
+	}
+	seekTime, _ := dlna.ParseNPTTime(strings.TrimPrefix(strings.SplitN(tsr, "-", 2)[0], "npt="))
+	if want := start / 1880; seekTime.Milliseconds() != want*100 || seekTime.Seconds() > 100 || seekTime.Seconds() < 90 {
+		t.Fatal(tsr, start)
+	}
+
+	req = httptest.NewRequest("GET", "/res?path=%2FVideos%2Fclip.ts", nil)
+	req.Header.Set(dlna.TimeSeekRangeDomain, "npt=0:10:00-")
+	rec = httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, req)
+	if rec.Code != 416 {
+		t.Fatal(rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/res?path=%2FVideos%2Fclip.mkv", nil)
+	req.Header.Set(dlna.TimeSeekRangeDomain, "npt=10-")
+	rec = httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, req)
+	if rec.Code != 406 {
+		t.Fatal(rec.Code)
+	}
+}
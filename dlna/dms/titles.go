@@ -0,0 +1,40 @@
+package dms
+
+import (
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofly/alipan-dms/title"
+)
+
+// Returns whether the object's name is its title, because it's in one of the
+// RawTitleFolders.
+func (s *Server) rawTitle(o object) bool {
+	for _, dir := range s.RawTitleFolders {
+		dir = path.Clean("/" + dir)
+		if dir == "/" || o.Path == dir || strings.HasPrefix(o.Path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the title of a file or folder, and the year its name ends with if
+// any. Files' extensions are dropped.
+func (s *Server) objectTitle(o object, fi os.FileInfo) (string, int) {
+	name := fi.Name()
+	if len(s.TitleRules) == 0 || s.rawTitle(o) {
+		return name, 0
+	}
+	if !fi.IsDir() {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+	return title.Clean(name, s.TitleRules)
+}
+
+// The date of a year, for items whose names give one.
+func yearDate(year int) time.Time {
+	return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,37 @@
+package dms
+
+import (
+	"testing"
+
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func TestObjectTitles(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Movies/[字幕组] Movie.Name.2019.BluRay.x264-GRP.mkv": nil,
+		"/Movies/Show.Name.S01.1080p/readme.txt":            nil,
+		"/Raw/Movie.Name.2019.mkv":                          nil,
+	})
+	s.RawTitleFolders = []string{"Raw/"}
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+	titles := make(map[string]upnpav.Object)
+	for _, dir := range []string{"/Movies", "/Raw"} {
+		objs, err := cds.readContainer(object{dir, "/"}, "host", genericRenderer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range objs {
+			o := upnpavObject(obj)
+			titles[o.Title] = o
+		}
+	}
+	if o, ok := titles["Movie Name"]; !ok || o.Date.Year() != 2019 {
+		t.Fatal(titles)
+	}
+	if _, ok := titles["Show Name S01"]; !ok {
+		t.Fatal(titles)
+	}
+	if o, ok := titles["Movie.Name.2019.mkv"]; !ok || o.Date.Year() == 2019 {
+		t.Fatal(titles)
+	}
+}
@@ -0,0 +1,135 @@
+package dms
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofly/alipan-dms/dlna"
+	"github.com/gofly/alipan-dms/transcode"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+const transcodePath = "/transcode"
+
+// What the transcode command produces.
+var transcodeProfile = struct {
+	Name     string
+	MimeType mimeType
+}{"AVC_TS_MP_HD_AAC_MULT5_ISO", "video/mpeg"}
+
+func transcodeContentFeatures() dlna.ContentFeatures {
+	return dlna.ContentFeatures{
+		ProfileName:     transcodeProfile.Name,
+		SupportTimeSeek: true,
+		Transcoded:      true,
+	}
+}
+
+func (s *Server) transcodeURL(o object, host string) string {
+	return (&url.URL{
+		Scheme:   "http",
+		Host:     host,
+		Path:     transcodePath,
+		RawQuery: url.Values{"path": {o.Path}}.Encode(),
+	}).String()
+}
+
+// Offers a transcoded resource for a video item, after its original.
+func (s *Server) addTranscode(item *upnpav.Item, o object, host string, mi mediaInfo) {
+	if s.transcoder == nil {
+		return
+	}
+	item.Res = append(item.Res, upnpav.Resource{
+		URL:          s.transcodeURL(o, host),
+		ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", transcodeProfile.MimeType, transcodeContentFeatures()),
+		Duration:     mi.duration(),
+	})
+}
+
+// Returns the address the server can reach itself at: the address it listens
+// on, or loopback if it listens on all of them.
+func (s *Server) localHost() string {
+	host, port, _ := net.SplitHostPort(s.HTTPConn.Addr().String())
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Returns a job transcoding the part of a video from start for a client,
+// reading it through the server itself so the command can seek. The reads
+// are scheduled as the client's.
+func (s *Server) transcodeJob(o object, start, length time.Duration, client string) transcode.Job {
+	u := s.resURL(o, s.localHost())
+	if client != "" {
+		u += "&" + url.Values{clientParam: {client}}.Encode()
+	}
+	return transcode.Job{
+		Input: func() (io.ReadCloser, error) {
+			return s.streams.open(context.Background(), client, func() (io.ReadCloser, error) {
+				return s.Backend.ReadStream(o.FilePath())
+			})
+		},
+		URL:    u,
+		Start:  start,
+		Length: length,
+	}
+}
+
+// Streams a video through the transcode command, from the time given by any
+// TimeSeekRange.dlna.org header. The command is killed if the client goes
+// away.
+func (s *Server) serveTranscode(w http.ResponseWriter, r *http.Request) {
+	if s.transcoder == nil {
+		http.NotFound(w, r)
+		return
+	}
+	o := object{path.Clean("/" + r.URL.Query().Get("path")), s.RootObjectPath}
+	filePath := o.FilePath()
+	fi, err := s.Backend.Stat(filePath)
+	if err != nil || fi.IsDir() || !fileMimeType(fi).IsVideo() {
+		http.NotFound(w, r)
+		return
+	}
+	var start time.Duration
+	if tsr := r.Header.Get(dlna.TimeSeekRangeDomain); tsr != "" {
+		npt, err := dlna.ParseNPTRange(strings.TrimPrefix(strings.TrimSpace(tsr), "npt="))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		start = npt.Start
+		length := "*"
+		if mi, _ := s.prober.cached(filePath, fi); mi.Duration > 0 {
+			if start >= mi.Duration {
+				http.Error(w, "seek past end", http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			length = dlna.FormatNPTTime(mi.Duration)
+		}
+		w.Header().Set(dlna.TimeSeekRangeDomain, fmt.Sprintf("npt=%s-/%s", dlna.FormatNPTTime(start), length))
+	}
+	w.Header().Set("Content-Type", transcodeProfile.MimeType.String())
+	w.Header().Set(dlna.ContentFeaturesDomain, transcodeContentFeatures().String())
+	w.Header().Set(dlna.TransferModeDomain, "Streaming")
+	if r.Method == http.MethodHead {
+		return
+	}
+	out, err := s.transcoder.Start(r.Context(), s.transcodeJob(o, start, 0, requestClient(r)))
+	if err != nil {
+		s.Logger.Printf("error starting transcode of %s: %s", o.Path, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer out.Close()
+	if _, err := io.Copy(w, out); err != nil && r.Context().Err() == nil {
+		s.Logger.Printf("error transcoding %s: %s", o.Path, err)
+	}
+}
@@ -0,0 +1,82 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofly/alipan-dms/dlna"
+	"github.com/gofly/alipan-dms/transcode"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+func TestTranscode(t *testing.T) {
+	s := newTestServer(memBackend{"/Videos/movie.mkv": []byte("matroska")})
+	s.transcoder = transcode.New([]string{"sh", "-c", `echo "from $0"; cat`, transcode.StartArg}, 1)
+
+	objs, err := s.services["ContentDirectory"].(*contentDirectoryService).readContainer(object{"/Videos", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := xml.Marshal(objs[0].(upnpav.Item))
+	want := `<res protocolInfo="http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_MP_HD_AAC_MULT5_ISO;DLNA.ORG_OP=10;DLNA.ORG_CI=1;DLNA.ORG_FLAGS=01700000000000000000000000000000">http://host/transcode?path=%2FVideos%2Fmovie.mkv</res>`
+	if !bytes.Contains(b, []byte(want)) {
+		t.Fatal(string(b))
+	}
+
+	req := httptest.NewRequest("GET", "/transcode?path=%2FVideos%2Fmovie.mkv", nil)
+	req.Header.Set(dlna.TimeSeekRangeDomain, "npt=0:01:30-")
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, req)
+	if rec.Code != 200 || rec.Body.String() != "from 90.000\nmatroska" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(dlna.TimeSeekRangeDomain); got != "npt=00:01:30.000-/*" {
+		t.Fatal(got)
+	}
+	if rec.Header().Get("Content-Type") != "video/mpeg" {
+		t.Fatal(rec.Header())
+	}
+}
+
+func TestTranscodeDisabled(t *testing.T) {
+	s := newTestServer(memBackend{"/Videos/movie.mkv": []byte("matroska")})
+	objs, err := s.services["ContentDirectory"].(*contentDirectoryService).readContainer(object{"/Videos", "/"}, "host", genericRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := xml.Marshal(objs[0].(upnpav.Item)); bytes.Contains(b, []byte("/transcode")) {
+		t.Fatal(string(b))
+	}
+	rec := httptest.NewRecorder()
+	s.httpServeMux.ServeHTTP(rec, httptest.NewRequest("GET", "/transcode?path=%2FVideos%2Fmovie.mkv", nil))
+	if rec.Code != 404 {
+		t.Fatal(rec.Code)
+	}
+}
+
+type addrListener struct {
+	net.Listener
+	addr net.Addr
+}
+
+func (l addrListener) Addr() net.Addr {
+	return l.addr
+}
+
+func TestLocalHost(t *testing.T) {
+	for addr, want := range map[string]string{
+		"0.0.0.0:1338":      "127.0.0.1:1338",
+		"[::]:1338":         "127.0.0.1:1338",
+		"192.168.1.10:1338": "192.168.1.10:1338",
+		"[fe80::1]:1338":    "[fe80::1]:1338",
+	} {
+		tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+		s := &Server{HTTPConn: addrListener{addr: tcpAddr}}
+		if got := s.localHost(); got != want {
+			t.Errorf("%s: %s", addr, got)
+		}
+	}
+}
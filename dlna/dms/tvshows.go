@@ -0,0 +1,162 @@
+package dms
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofly/alipan-dms/series"
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+// The view of TV shows. A show's container is "tv/<show>", and a season's
+// "tv/<show>/<season>", with the show's name query-escaped.
+const tvID = "tv"
+
+func tvShowID(show string) string {
+	return tvID + "/" + url.QueryEscape(show)
+}
+
+func tvSeasonID(show string, season int) string {
+	return fmt.Sprintf("%s/%d", tvShowID(show), season)
+}
+
+func seasonTitle(season int) string {
+	return fmt.Sprintf("Season %d", season)
+}
+
+// Returns the container of a show or a season of one, by ObjectID.
+func tvContainer(id string) (vc virtualContainer, ok bool) {
+	if !strings.HasPrefix(id, tvID+"/") {
+		return
+	}
+	escaped, season, hasSeason := strings.Cut(strings.TrimPrefix(id, tvID+"/"), "/")
+	show, err := url.QueryUnescape(escaped)
+	if err != nil || show == "" {
+		return vc, false
+	}
+	vc = virtualContainer{title: show, parentID: tvID, tv: true, show: show}
+	if hasSeason {
+		if vc.season, err = strconv.Atoi(season); err != nil || vc.season <= 0 {
+			return vc, false
+		}
+		vc.title = seasonTitle(vc.season)
+		vc.parentID = tvShowID(show)
+	}
+	return vc, true
+}
+
+// Returns the title of an episode's item, like "Show S01E03 Title".
+func episodeTitle(ep series.Episode) string {
+	title := fmt.Sprintf("%s S%02dE%02d", ep.Show, ep.Season, ep.Episode)
+	if ep.Title != "" {
+		title += " " + ep.Title
+	}
+	return title
+}
+
+// An indexed video that's an episode of a series.
+type tvEpisode struct {
+	indexEntry
+	series.Episode
+}
+
+// Returns the indexed episodes in a show, or a season of one, or all of them
+// if show is empty, in order.
+func (s *Server) tvEpisodes(show string, season int) (ret []tvEpisode) {
+	for _, e := range s.index.items("object.item.videoItem") {
+		ep, ok := series.Parse(e.Path)
+		if !ok || show != "" && ep.Show != show || season != 0 && ep.Season != season {
+			continue
+		}
+		ret = append(ret, tvEpisode{e, ep})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		a, b := ret[i].Episode, ret[j].Episode
+		if a.Show != b.Show {
+			return a.Show < b.Show
+		}
+		if a.Season != b.Season {
+			return a.Season < b.Season
+		}
+		return a.Episode < b.Episode
+	})
+	return
+}
+
+// Returns the items of episodes that match the criteria, listed in the
+// container with the ObjectID.
+func (s *contentDirectoryService) episodeObjects(episodes []tvEpisode, parentID string, sc searchCriteria, host string, rp *rendererProfile) (ret []interface{}) {
+	entries := make([]indexEntry, 0, len(episodes))
+	for _, ep := range episodes {
+		entries = append(entries, ep.indexEntry)
+	}
+	for _, obj := range s.indexedObjects(entries, parentID, host, rp) {
+		if sc.match(upnpavObject(obj)) {
+			ret = append(ret, obj)
+		}
+	}
+	return
+}
+
+// Returns the objects in the TV shows view, or in a show or season in it,
+// that match the criteria.
+func (s *contentDirectoryService) readTVContainer(id string, vc virtualContainer, sc searchCriteria, host string, rp *rendererProfile) (ret []interface{}) {
+	episodes := s.tvEpisodes(vc.show, vc.season)
+	if vc.season != 0 {
+		return s.episodeObjects(episodes, id, sc, host, rp)
+	}
+	// Shows have as many children as seasons, and seasons as episodes.
+	var containers []upnpav.Container
+	var counts []int
+	lastSeason := 0
+	for _, ep := range episodes {
+		if s.hides(ep.MimeType, rp) {
+			continue
+		}
+		var c upnpav.Container
+		if vc.show == "" {
+			c = virtualContainer{title: rp.title(ep.Show), parentID: tvID}.upnpavContainer(tvShowID(ep.Show))
+		} else {
+			c = virtualContainer{title: seasonTitle(ep.Season), parentID: id}.upnpavContainer(tvSeasonID(ep.Show, ep.Season))
+		}
+		if len(containers) == 0 || containers[len(containers)-1].ID != c.ID {
+			containers = append(containers, c)
+			counts = append(counts, 0)
+			lastSeason = 0
+		}
+		if vc.show != "" || ep.Season != lastSeason {
+			counts[len(counts)-1]++
+			lastSeason = ep.Season
+		}
+	}
+	for i, c := range containers {
+		c.ChildCount = &counts[i]
+		if sc.match(c.Object) {
+			ret = append(ret, c)
+		}
+	}
+	return
+}
+
+// Returns the number of shows in the TV shows view, or of seasons in a show,
+// or of episodes in a season.
+func (s *Server) tvChildCount(vc virtualContainer, rp *rendererProfile) int {
+	n := 0
+	var last tvEpisode
+	for _, ep := range s.tvEpisodes(vc.show, vc.season) {
+		if s.hides(ep.MimeType, rp) {
+			continue
+		}
+		switch {
+		case vc.season != 0,
+			vc.show != "" && (n == 0 || ep.Season != last.Season),
+			vc.show == "" && (n == 0 || ep.Show != last.Show):
+			n++
+		}
+		last = ep
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+package dms
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTVShows(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/TV/Show.S01E02.1080p.WEB-DL.mkv":   nil,
+		"/TV/Show.S01E01.Pilot.1080p.mkv":    nil,
+		"/TV/Show.S02E01.mkv":                nil,
+		"/电视剧/长安十二时辰/第03集.mp4":               nil,
+		"/Movies/Heat.1995.1080p.BluRay.mkv": nil,
+	})
+	s.index.requestInterval = 0
+	if err := s.index.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	cds := s.services["ContentDirectory"]
+	r := httptest.NewRequest("POST", "/ctl", nil)
+	r.Host = "host"
+	browse := func(id string) map[string]string {
+		t.Helper()
+		resp, err := cds.Handle("Browse", []byte(`<u:Browse><ObjectID>`+id+`</ObjectID><BrowseFlag>BrowseDirectChildren</BrowseFlag></u:Browse>`), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]string)
+		for _, kv := range resp {
+			m[kv[0]] = kv[1]
+		}
+		return m
+	}
+	m := browse(tvID)
+	if m["TotalMatches"] != "2" ||
+		!strings.Contains(m["Result"], `<container id="tv/Show" parentID="tv"`) ||
+		!strings.Contains(m["Result"], `<container id="tv/%E9%95%BF%E5%AE%89%E5%8D%81%E4%BA%8C%E6%97%B6%E8%BE%B0" parentID="tv"`) {
+		t.Fatal(m)
+	}
+	m = browse("tv/Show")
+	if m["TotalMatches"] != "2" ||
+		!strings.Contains(m["Result"], `<container id="tv/Show/1" parentID="tv/Show"`) ||
+		!strings.Contains(m["Result"], `<dc:title>Season 2</dc:title>`) {
+		t.Fatal(m)
+	}
+	m = browse("tv/Show/1")
+	if m["TotalMatches"] != "2" {
+		t.Fatal(m)
+	}
+	first := strings.Index(m["Result"], "<dc:title>Show S01E01 Pilot</dc:title>")
+	second := strings.Index(m["Result"], "<dc:title>Show S01E02</dc:title>")
+	if first < 0 || second < first ||
+		!strings.Contains(m["Result"], `parentID="tv/Show/1"`) ||
+		!strings.Contains(m["Result"], "<upnp:seriesTitle>Show</upnp:seriesTitle><upnp:episodeSeason>1</upnp:episodeSeason><upnp:episodeNumber>2</upnp:episodeNumber>") {
+		t.Fatal(m)
+	}
+	// Episodes are titled in their folders too, and movies aren't episodes.
+	if m = browse("%2FTV"); !strings.Contains(m["Result"], "<dc:title>Show S02E01</dc:title>") {
+		t.Fatal(m)
+	}
+	m = browse("%2FMovies")
+	if !strings.Contains(m["Result"], "<dc:title>Heat</dc:title>") || strings.Contains(m["Result"], "seriesTitle") {
+		t.Fatal(m)
+	}
+	if m = browse("tv/Nothing/1"); m["TotalMatches"] != "0" {
+		t.Fatal(m)
+	}
+	if _, ok := virtualContainerByID("tv/Show/0"); ok {
+		t.Fatal("season 0")
+	}
+}
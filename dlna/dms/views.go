@@ -0,0 +1,174 @@
+package dms
+
+import (
+	"github.com/gofly/alipan-dms/upnpav"
+)
+
+const (
+	// The container of the backend's root directory.
+	foldersID = "folders"
+	// Items listed in Recently Added.
+	recentItems = 100
+)
+
+// A container that isn't a directory of the backend. It lists other virtual
+// containers, the root directory, or all the items of a class.
+type virtualContainer struct {
+	title    string
+	parentID string
+	children []string
+	// Lists the root directory.
+	folders bool
+	// Items that derive from this class are listed, if there are no children.
+	itemClass string
+	// Items are listed from the media index rather than by searching.
+	indexed bool
+	// Only the most recently modified items are listed, newest first.
+	recent bool
+	// Lists TV shows, or the seasons of show, or the episodes of a season of
+	// it.
+	tv     bool
+	show   string
+	season int
+}
+
+// The root and the views under it, keyed by ObjectID.
+var views = map[string]virtualContainer{
+	"0": {
+		title:    "Root",
+		parentID: "-1",
+		children: []string{"videos", tvID, "music", "photos", "recent", foldersID},
+	},
+	"videos":  {title: "Videos", parentID: "0", itemClass: "object.item.videoItem", indexed: true},
+	tvID:      {title: "TV Shows", parentID: "0", tv: true},
+	"music":   {title: "Music", parentID: "0", itemClass: "object.item.audioItem", indexed: true},
+	"photos":  {title: "Photos", parentID: "0", itemClass: "object.item.imageItem", indexed: true},
+	"recent":  {title: "Recently Added", parentID: "0", itemClass: "object.item", indexed: true, recent: true},
+	foldersID: {title: "Folders", parentID: "0", folders: true},
+}
+
+// Returns the virtual container with the ObjectID, if there is one.
+func virtualContainerByID(id string) (virtualContainer, bool) {
+	if vc, ok := views[id]; ok {
+		return vc, true
+	}
+	if vc, ok := msContainers[id]; ok {
+		return vc, true
+	}
+	return tvContainer(id)
+}
+
+func (vc virtualContainer) upnpavContainer(id string) upnpav.Container {
+	return upnpav.Container{Object: upnpav.Object{
+		ID:         id,
+		ParentID:   vc.parentID,
+		Restricted: 1,
+		Title:      vc.title,
+		Class:      "object.container",
+		Searchable: 1,
+	}}
+}
+
+// Returns the objects for index entries, listed in the container with the
+// ObjectID.
+func (s *contentDirectoryService) indexedObjects(entries []indexEntry, parentID, host string, rp *rendererProfile) (ret []interface{}) {
+	for _, e := range entries {
+		obj, err := s.cdsObjectToUpnpavObject(object{e.Path, s.RootObjectPath}, e.Info, host, rp)
+		if err != nil || obj == nil {
+			continue
+		}
+		if item, ok := obj.(upnpav.Item); ok {
+			item.ParentID = parentID
+			obj = item
+		}
+		ret = append(ret, obj)
+	}
+	return
+}
+
+// Returns the number of children of a virtual container, if it's known
+// without searching.
+func (s *Server) virtualChildCount(vc virtualContainer, rp *rendererProfile) (int, bool) {
+	switch {
+	case vc.children != nil:
+		return len(vc.children), true
+	case vc.folders:
+		return s.childCount(object{"/", s.RootObjectPath}, rp)
+	case vc.tv:
+		return s.tvChildCount(vc, rp), true
+	case vc.indexed:
+		entries := s.index.items(vc.itemClass)
+		if vc.recent {
+			entries = s.index.recent(vc.itemClass, recentItems)
+		}
+		n := 0
+		for _, e := range entries {
+			if !s.hides(e.MimeType, rp) {
+				n++
+			}
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+// Returns the objects in a virtual container that match the criteria.
+func (s *contentDirectoryService) readVirtualContainer(id string, vc virtualContainer, sc searchCriteria, host string, rp *rendererProfile) ([]interface{}, error) {
+	root := object{"/", s.RootObjectPath}
+	switch {
+	case vc.children != nil:
+		var ret []interface{}
+		for _, child := range vc.children {
+			c, _ := virtualContainerByID(child)
+			obj := c.upnpavContainer(child)
+			if n, ok := s.virtualChildCount(c, rp); ok {
+				obj.ChildCount = &n
+			}
+			if sc.match(obj.Object) {
+				ret = append(ret, obj)
+			}
+		}
+		return ret, nil
+	case vc.folders:
+		objs, err := s.readContainer(root, host, rp)
+		if err != nil {
+			return nil, err
+		}
+		var ret []interface{}
+		for _, obj := range objs {
+			if sc.match(upnpavObject(obj)) {
+				ret = append(ret, obj)
+			}
+		}
+		return ret, nil
+	case vc.tv:
+		return s.readTVContainer(id, vc, sc, host, rp), nil
+	case vc.indexed:
+		var entries []indexEntry
+		if vc.recent {
+			entries = s.index.recent(vc.itemClass, recentItems)
+		} else {
+			entries = s.index.items(vc.itemClass)
+		}
+		var ret []interface{}
+		for _, obj := range s.indexedObjects(entries, id, host, rp) {
+			if sc.match(upnpavObject(obj)) {
+				ret = append(ret, obj)
+			}
+		}
+		return ret, nil
+	}
+	return s.search(root, searchAnd{searchRel{"upnp:class", "derivedfrom", vc.itemClass}, sc}, host, rp)
+}
+
+// Searches under a virtual container: the items it lists if it lists a class,
+// the episodes under it if it's a TV container, and otherwise everything.
+func (s *contentDirectoryService) searchVirtualContainer(id string, vc virtualContainer, sc searchCriteria, host string, rp *rendererProfile) ([]interface{}, error) {
+	if vc.tv {
+		return s.episodeObjects(s.tvEpisodes(vc.show, vc.season), id, sc, host, rp), nil
+	}
+	if vc.itemClass != "" {
+		return s.readVirtualContainer(id, vc, sc, host, rp)
+	}
+	return s.search(object{"/", s.RootObjectPath}, sc, host, rp)
+}
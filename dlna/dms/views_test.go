@@ -0,0 +1,94 @@
+package dms
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type modTimeFileInfo struct {
+	memFileInfo
+	modTime time.Time
+}
+
+func (fi modTimeFileInfo) ModTime() time.Time { return fi.modTime }
+
+func TestViews(t *testing.T) {
+	s := newTestServer(memBackend{
+		"/Movies/Heat.mkv":        nil,
+		"/Movies/Old/Ronin.mp4":   nil,
+		"/Music/Song.mp3":         nil,
+		"/Photos/2020/Beach.jpg":  nil,
+		"/Documents/Taxes.pdf":    nil,
+		"/Documents/Scan.mp4.txt": nil,
+	})
+	s.index.requestInterval = 0
+	if err := s.index.crawl(nil); err != nil {
+		t.Fatal(err)
+	}
+	cds := s.services["ContentDirectory"]
+	r := httptest.NewRequest("POST", "/ctl", nil)
+	r.Host = "host"
+	browse := func(id string) map[string]string {
+		t.Helper()
+		resp, err := cds.Handle("Browse", []byte(`<u:Browse><ObjectID>`+id+`</ObjectID><BrowseFlag>BrowseDirectChildren</BrowseFlag></u:Browse>`), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]string)
+		for _, kv := range resp {
+			m[kv[0]] = kv[1]
+		}
+		return m
+	}
+	m := browse("0")
+	for _, want := range []string{
+		`<container id="videos" parentID="0"`,
+		`<container id="tv" parentID="0"`,
+		`<container id="music" parentID="0"`,
+		`<container id="photos" parentID="0"`,
+		`<container id="recent" parentID="0"`,
+		`<container id="folders" parentID="0"`,
+	} {
+		if !strings.Contains(m["Result"], want) {
+			t.Fatal(m["Result"])
+		}
+	}
+	m = browse("videos")
+	if m["TotalMatches"] != "2" ||
+		!strings.Contains(m["Result"], `<item id="%2FMovies%2FHeat.mkv" parentID="videos"`) ||
+		!strings.Contains(m["Result"], `<item id="%2FMovies%2FOld%2FRonin.mp4" parentID="videos"`) {
+		t.Fatal(m)
+	}
+	if m = browse("photos"); m["TotalMatches"] != "1" || !strings.Contains(m["Result"], "Beach.jpg") {
+		t.Fatal(m)
+	}
+	if m = browse("recent"); m["TotalMatches"] != "4" {
+		t.Fatal(m)
+	}
+	// The folders are under their own view.
+	m = browse("folders")
+	if m["TotalMatches"] != "4" || !strings.Contains(m["Result"], `<container id="%2FMovies" parentID="folders"`) {
+		t.Fatal(m)
+	}
+	if m = browse("%2FMovies%2FOld"); !strings.Contains(m["Result"], `parentID="%2FMovies%2FOld"`) {
+		t.Fatal(m)
+	}
+}
+
+func TestIndexRecent(t *testing.T) {
+	now := time.Now()
+	mi := &mediaIndex{}
+	for i, name := range []string{"a.mkv", "b.mp3", "c.mkv", "d.mkv"} {
+		fi := modTimeFileInfo{memFileInfo{name: name}, now.Add(time.Duration(i) * time.Hour)}
+		mi.entries = append(mi.entries, indexEntry{"/" + name, fi, fileMimeType(fi)})
+	}
+	var got []string
+	for _, e := range mi.recent("object.item.videoItem", 2) {
+		got = append(got, e.Path)
+	}
+	if strings.Join(got, " ") != "/d.mkv /c.mkv" {
+		t.Fatal(got)
+	}
+}
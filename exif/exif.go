@@ -0,0 +1,232 @@
+// Package exif reads the few EXIF fields a media server cares about from the
+// header of a JPEG file.
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Info is the metadata read from a JPEG header.
+type Info struct {
+	// When the photo was taken. Zero if unknown.
+	DateTimeOriginal time.Time
+	// EXIF orientation, 1 to 8. Zero if absent.
+	Orientation int
+	// The stored dimensions from the frame header, before orientation.
+	Width, Height int
+}
+
+var ErrNotJPEG = errors.New("not a jpeg")
+
+const (
+	markerSOI  = 0xd8
+	markerSOS  = 0xda
+	markerEOI  = 0xd9
+	markerAPP1 = 0xe1
+)
+
+// DecodeJPEG reads JPEG segments from r up to the first frame header. Only
+// the header needs to be available, so r can be a short range of the file.
+func DecodeJPEG(r io.Reader) (*Info, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xff || soi[1] != markerSOI {
+		return nil, ErrNotJPEG
+	}
+	info := &Info{}
+	for {
+		marker, err := nextMarker(br)
+		if err != nil {
+			return info, err
+		}
+		if marker == markerSOS || marker == markerEOI {
+			return info, nil
+		}
+		if marker >= 0xd0 && marker <= 0xd7 || marker == 0x01 {
+			// Markers without a length.
+			continue
+		}
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(br, lenBuf[:]); err != nil {
+			return info, err
+		}
+		n := int(binary.BigEndian.Uint16(lenBuf[:])) - 2
+		if n < 0 {
+			return info, ErrNotJPEG
+		}
+		switch {
+		case marker == markerAPP1:
+			seg := make([]byte, n)
+			if _, err := io.ReadFull(br, seg); err != nil {
+				return info, err
+			}
+			if bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+				parseTIFF(seg[6:], info)
+			}
+		case isSOF(marker):
+			var sof [5]byte
+			if n < len(sof) {
+				return info, ErrNotJPEG
+			}
+			if _, err := io.ReadFull(br, sof[:]); err != nil {
+				return info, err
+			}
+			info.Height = int(binary.BigEndian.Uint16(sof[1:3]))
+			info.Width = int(binary.BigEndian.Uint16(sof[3:5]))
+			return info, nil
+		default:
+			if _, err := br.Discard(n); err != nil {
+				return info, err
+			}
+		}
+	}
+}
+
+func nextMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xff {
+		return 0, ErrNotJPEG
+	}
+	for b == 0xff {
+		// Fill bytes.
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// Start of frame markers, excluding DHT, JPG and DAC which share the range.
+func isSOF(marker byte) bool {
+	return marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc
+}
+
+const (
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+const (
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+)
+
+type tiff struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// Parses the TIFF structure of an EXIF segment. Malformed data is ignored as
+// far as possible, since cameras get this wrong often enough.
+func parseTIFF(b []byte, info *Info) {
+	if len(b) < 8 {
+		return
+	}
+	t := tiff{b: b}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return
+	}
+	var exifIFD uint32
+	var modified time.Time
+	t.walkIFD(t.order.Uint32(b[4:8]), func(tag, typ uint16, count uint32, value []byte) {
+		switch tag {
+		case tagOrientation:
+			if o := int(t.uint(typ, value)); o >= 1 && o <= 8 {
+				info.Orientation = o
+			}
+		case tagDateTime:
+			modified = parseDateTime(t.ascii(typ, value))
+		case tagExifIFD:
+			exifIFD = t.uint(typ, value)
+		}
+	})
+	if exifIFD != 0 {
+		t.walkIFD(exifIFD, func(tag, typ uint16, count uint32, value []byte) {
+			if tag == tagDateTimeOriginal {
+				info.DateTimeOriginal = parseDateTime(t.ascii(typ, value))
+			}
+		})
+	}
+	if info.DateTimeOriginal.IsZero() {
+		info.DateTimeOriginal = modified
+	}
+}
+
+// Calls f with each entry in the IFD at offset. value holds the entry's data,
+// resolved through its offset if it doesn't fit inline.
+func (t tiff) walkIFD(offset uint32, f func(tag, typ uint16, count uint32, value []byte)) {
+	if int64(offset)+2 > int64(len(t.b)) {
+		return
+	}
+	n := int(t.order.Uint16(t.b[offset:]))
+	entries := t.b[offset+2:]
+	for i := 0; i < n && (i+1)*12 <= len(entries); i++ {
+		e := entries[i*12 : (i+1)*12]
+		tag, typ, count := t.order.Uint16(e), t.order.Uint16(e[2:]), t.order.Uint32(e[4:])
+		size := int64(count)
+		switch typ {
+		case typeShort:
+			size *= 2
+		case typeLong:
+			size *= 4
+		case typeASCII:
+		default:
+			continue
+		}
+		value := e[8:12]
+		if size > 4 {
+			off := int64(t.order.Uint32(e[8:]))
+			if off+size > int64(len(t.b)) {
+				continue
+			}
+			value = t.b[off : off+size]
+		}
+		f(tag, typ, count, value)
+	}
+}
+
+func (t tiff) uint(typ uint16, value []byte) uint32 {
+	switch typ {
+	case typeShort:
+		return uint32(t.order.Uint16(value))
+	case typeLong:
+		return t.order.Uint32(value)
+	}
+	return 0
+}
+
+func (t tiff) ascii(typ uint16, value []byte) string {
+	if typ != typeASCII {
+		return ""
+	}
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// EXIF times have no zone, and are in the camera's local time.
+func parseDateTime(s string) time.Time {
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", s, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,109 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type entry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// Builds a TIFF structure with IFD0 and an Exif IFD.
+func buildTIFF(order binary.ByteOrder, ifd0, exifIFD []entry) []byte {
+	var b bytes.Buffer
+	if order == binary.LittleEndian {
+		b.WriteString("II")
+	} else {
+		b.WriteString("MM")
+	}
+	binary.Write(&b, order, uint16(42))
+	binary.Write(&b, order, uint32(8))
+	ifd0Size := 2 + 12*(len(ifd0)+1) + 4
+	exifOffset := uint32(8 + ifd0Size)
+	exifSize := 2 + 12*len(exifIFD) + 4
+	dataOffset := exifOffset + uint32(exifSize)
+	var data bytes.Buffer
+	writeIFD := func(entries []entry) {
+		binary.Write(&b, order, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(&b, order, e.tag)
+			binary.Write(&b, order, e.typ)
+			binary.Write(&b, order, e.count)
+			if len(e.value) > 4 {
+				binary.Write(&b, order, dataOffset+uint32(data.Len()))
+				data.Write(e.value)
+			} else {
+				v := append(append([]byte(nil), e.value...), make([]byte, 4-len(e.value))...)
+				b.Write(v)
+			}
+		}
+		binary.Write(&b, order, uint32(0))
+	}
+	ptr := make([]byte, 4)
+	order.PutUint32(ptr, exifOffset)
+	writeIFD(append(ifd0, entry{tagExifIFD, typeLong, 1, ptr}))
+	writeIFD(exifIFD)
+	b.Write(data.Bytes())
+	return b.Bytes()
+}
+
+func ascii(s string) entry {
+	return entry{value: append([]byte(s), 0), typ: typeASCII, count: uint32(len(s) + 1)}
+}
+
+func buildJPEG(tiff []byte, w, h int) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xff, markerSOI})
+	b.Write([]byte{0xff, 0xe0, 0, 4, 'J', 'F'})
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	b.Write([]byte{0xff, markerAPP1})
+	binary.Write(&b, binary.BigEndian, uint16(len(app1)+2))
+	b.Write(app1)
+	b.Write([]byte{0xff, 0xc0, 0, 11, 8})
+	binary.Write(&b, binary.BigEndian, uint16(h))
+	binary.Write(&b, binary.BigEndian, uint16(w))
+	b.Write([]byte{1, 1, 0x11, 0})
+	return b.Bytes()
+}
+
+func TestDecodeJPEG(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		orientation := entry{tagOrientation, typeShort, 1, make([]byte, 2)}
+		order.PutUint16(orientation.value, 6)
+		taken := ascii("2019:05:04 12:34:56")
+		taken.tag = tagDateTimeOriginal
+		jpeg := buildJPEG(buildTIFF(order, []entry{orientation}, []entry{taken}), 4000, 3000)
+		info, err := DecodeJPEG(bytes.NewReader(jpeg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.DateTimeOriginal.Equal(time.Date(2019, 5, 4, 12, 34, 56, 0, time.Local)) {
+			t.Error(info.DateTimeOriginal)
+		}
+		if info.Orientation != 6 {
+			t.Error(info.Orientation)
+		}
+		if info.Width != 4000 || info.Height != 3000 {
+			t.Error(info.Width, info.Height)
+		}
+	}
+}
+
+func TestDecodeJPEGWithoutExif(t *testing.T) {
+	jpeg := []byte{0xff, markerSOI, 0xff, 0xc2, 0, 11, 8, 0, 10, 0, 20, 1, 1, 0x11, 0}
+	info, err := DecodeJPEG(bytes.NewReader(jpeg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 20 || info.Height != 10 || info.Orientation != 0 || !info.DateTimeOriginal.IsZero() {
+		t.Fatalf("%+v", info)
+	}
+	if _, err := DecodeJPEG(bytes.NewReader([]byte("\x89PNG"))); err != ErrNotJPEG {
+		t.Fatal(err)
+	}
+}
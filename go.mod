@@ -5,7 +5,8 @@ go 1.18
 require (
 	github.com/anacrolix/log v0.13.1
 	github.com/studio-b12/gowebdav v0.0.0-20220128162035-c7b1ff8a5e62
-	golang.org/x/net v0.0.0-20220412020605-290c469a71a5
+	golang.org/x/net v0.17.0
+	golang.org/x/text v0.14.0
 )
 
-require golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
+require golang.org/x/sys v0.13.0 // indirect
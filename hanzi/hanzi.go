@@ -0,0 +1,141 @@
+// Package hanzi matches Chinese text the way it's typed on a remote: by
+// pinyin, by pinyin initials, and without regard to traditional or simplified
+// characters, letter case, or full-width forms.
+package hanzi
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// The dictionaries were made from ICU's Han-Latin and Traditional-Simplified
+// transforms over the CJK Unified Ideographs block, with the other common
+// readings of characters added by hand. Each line of pinyin.txt is a toneless
+// syllable, with ü written v, followed by the characters read that way. Each
+// line of t2s.txt is a traditional character followed by its simplified form.
+var (
+	//go:embed pinyin.txt
+	pinyinData string
+	//go:embed t2s.txt
+	t2sData string
+)
+
+var (
+	loadOnce   sync.Once
+	readings   map[rune][]string
+	simplified map[rune]rune
+)
+
+func load() {
+	readings = make(map[rune][]string)
+	for _, line := range strings.Split(pinyinData, "\n") {
+		syllable, chars, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		for _, r := range chars {
+			readings[r] = append(readings[r], syllable)
+		}
+	}
+	simplified = make(map[rune]rune)
+	for _, line := range strings.Split(t2sData, "\n") {
+		if rs := []rune(line); len(rs) == 2 {
+			simplified[rs[0]] = rs[1]
+		}
+	}
+}
+
+// Pinyin returns the toneless readings of a character, or nil if it isn't a
+// known Chinese character.
+func Pinyin(r rune) []string {
+	loadOnce.Do(load)
+	return readings[r]
+}
+
+// Simplify replaces traditional characters with their simplified forms.
+func Simplify(s string) string {
+	loadOnce.Do(load)
+	return strings.Map(func(r rune) rune {
+		if simp, ok := simplified[r]; ok {
+			return simp
+		}
+		return r
+	}, s)
+}
+
+// Fold returns s in simplified characters, with full-width letters and digits
+// made ASCII, in lower case.
+func Fold(s string) string {
+	return strings.ToLower(Simplify(width.Fold.String(s)))
+}
+
+// Contains reports whether query is in text, after folding both. Failing
+// that, each character of text can be matched by a prefix of one of its
+// readings, so "lldq", "liulangdiqiu" and "liulangdq" all find 流浪地球. Spaces
+// are ignored, and u in the query matches ü.
+func Contains(text, query string) bool {
+	text, query = Fold(text), Fold(query)
+	if strings.Contains(text, query) {
+		return true
+	}
+	t, q := runesNoSpace(text), runesNoSpace(query)
+	if len(q) == 0 {
+		return true
+	}
+	m := matcher{t: t, q: q, failed: make([]bool, (len(t)+1)*(len(q)+1))}
+	for i := range t {
+		if m.match(i, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func runesNoSpace(s string) (ret []rune) {
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			ret = append(ret, r)
+		}
+	}
+	return
+}
+
+type matcher struct {
+	t, q []rune
+	// Positions in t and q already known not to match the rest of q.
+	failed []bool
+}
+
+// Returns whether the rest of the query, from qi, matches text from ti.
+func (m *matcher) match(ti, qi int) bool {
+	if qi == len(m.q) {
+		return true
+	}
+	if ti == len(m.t) {
+		return false
+	}
+	key := ti*(len(m.q)+1) + qi
+	if m.failed[key] {
+		return false
+	}
+	if m.t[ti] == m.q[qi] && m.match(ti+1, qi+1) {
+		return true
+	}
+	for _, p := range Pinyin(m.t[ti]) {
+		for k := 0; k < len(p) && qi+k < len(m.q) && letterMatches(p[k], m.q[qi+k]); k++ {
+			if m.match(ti+1, qi+k+1) {
+				return true
+			}
+		}
+	}
+	m.failed[key] = true
+	return false
+}
+
+func letterMatches(reading byte, query rune) bool {
+	return rune(reading) == query || reading == 'v' && query == 'u'
+}
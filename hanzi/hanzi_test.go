@@ -0,0 +1,45 @@
+package hanzi
+
+import "testing"
+
+func TestContains(t *testing.T) {
+	for _, tc := range []struct {
+		text, query string
+		want        bool
+	}{
+		{"流浪地球2", "流浪", true},
+		{"流浪地球2", "lldq", true},
+		{"流浪地球2", "liulangdiqiu", true},
+		{"流浪地球2", "LiuLang DQ 2", true},
+		{"流浪地球2", "lldq3", false},
+		{"流浪地球2", "dqll", false},
+		{"長城", "长城", true},
+		{"长城", "長城", true},
+		{"长城", "changcheng", true},
+		{"绿皮书", "lvps", true},
+		{"绿皮书", "lups", true},
+		{"Ｔｈｅ Ｗａｎｄｅｒｉｎｇ Ｅａｒｔｈ", "wandering earth", true},
+		{"The Wandering Earth", "ＥＡＲＴＨ", true},
+		{"The Wandering Earth", "moon", false},
+		{"anything", "", true},
+	} {
+		if got := Contains(tc.text, tc.query); got != tc.want {
+			t.Errorf("Contains(%q, %q) = %v", tc.text, tc.query, got)
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	if s := Simplify("臺灣電視劇 abc"); s != "台湾电视剧 abc" {
+		t.Fatal(s)
+	}
+}
+
+func TestPinyin(t *testing.T) {
+	if p := Pinyin('长'); len(p) != 2 || p[0] != "chang" || p[1] != "zhang" {
+		t.Fatal(p)
+	}
+	if p := Pinyin('a'); p != nil {
+		t.Fatal(p)
+	}
+}
@@ -0,0 +1,196 @@
+// Package id3 extracts attached pictures from ID3v2 tags.
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// HeaderSize is the size of the ID3v2 tag header.
+const HeaderSize = 10
+
+// Picture type for the front cover, as used in APIC frames.
+const FrontCover = 3
+
+var ErrNoTag = errors.New("no id3v2 tag")
+
+var ErrNoPicture = errors.New("no attached picture")
+
+// An attached picture from an APIC (v2.3, v2.4) or PIC (v2.2) frame.
+type Picture struct {
+	MIMEType string
+	Type     byte
+	Data     []byte
+}
+
+// Returns the total size of the tag, including the header, from the first
+// HeaderSize bytes of a file.
+func TagSize(header []byte) (int64, error) {
+	if len(header) < HeaderSize || string(header[:3]) != "ID3" {
+		return 0, ErrNoTag
+	}
+	size := syncsafe(header[6:10])
+	if header[5]&0x10 != 0 {
+		// Footer present.
+		size += HeaderSize
+	}
+	return int64(size) + HeaderSize, nil
+}
+
+// ReadPicture reads an ID3v2 tag from the start of r and returns its front
+// cover, or the first picture if there's no front cover. No more than maxSize
+// bytes of tag are read.
+func ReadPicture(r io.Reader, maxSize int64) (*Picture, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, ErrNoTag
+	}
+	size, err := TagSize(header[:])
+	if err != nil {
+		return nil, err
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("id3 tag too large: %d bytes", size)
+	}
+	body := make([]byte, size-HeaderSize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return findPicture(header[3], header[5], body)
+}
+
+func findPicture(version, flags byte, body []byte) (*Picture, error) {
+	if version < 4 && flags&0x80 != 0 {
+		// Whole tag unsynchronisation, only meaningful before v2.4.
+		body = bytes.ReplaceAll(body, []byte{0xff, 0x00}, []byte{0xff})
+	}
+	if version > 2 && flags&0x40 != 0 && len(body) >= 4 {
+		// Skip the extended header.
+		var n int
+		if version == 3 {
+			n = int(binary.BigEndian.Uint32(body)) + 4
+		} else {
+			n = int(syncsafe(body[:4]))
+		}
+		if n > len(body) {
+			return nil, ErrNoPicture
+		}
+		body = body[n:]
+	}
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	var first *Picture
+	for len(body) >= headerLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var size int
+		switch version {
+		case 2:
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+		default:
+			size = int(syncsafe(body[4:8]))
+		}
+		if size < 0 || size > len(body)-headerLen {
+			break
+		}
+		frame := body[headerLen : headerLen+size]
+		body = body[headerLen+size:]
+		var pic *Picture
+		switch id {
+		case "APIC":
+			pic = parseAPIC(frame)
+		case "PIC":
+			pic = parsePIC(frame)
+		}
+		if pic == nil {
+			continue
+		}
+		if pic.Type == FrontCover {
+			return pic, nil
+		}
+		if first == nil {
+			first = pic
+		}
+	}
+	if first == nil {
+		return nil, ErrNoPicture
+	}
+	return first, nil
+}
+
+// <encoding> <mime type>\0 <picture type> <description> <data>
+func parseAPIC(b []byte) *Picture {
+	if len(b) < 2 {
+		return nil
+	}
+	enc := b[0]
+	i := bytes.IndexByte(b[1:], 0)
+	if i < 0 || 1+i+2 > len(b) {
+		return nil
+	}
+	mimeType := string(b[1 : 1+i])
+	b = b[1+i+1:]
+	pic := &Picture{MIMEType: mimeType, Type: b[0]}
+	data, ok := skipDescription(enc, b[1:])
+	if !ok {
+		return nil
+	}
+	pic.Data = data
+	if pic.MIMEType == "" || pic.MIMEType == "-->" {
+		return nil
+	}
+	if pic.MIMEType == "jpg" || pic.MIMEType == "image/jpg" {
+		pic.MIMEType = "image/jpeg"
+	}
+	return pic
+}
+
+// <encoding> <3 byte format> <picture type> <description> <data>
+func parsePIC(b []byte) *Picture {
+	if len(b) < 5 {
+		return nil
+	}
+	pic := &Picture{Type: b[4]}
+	switch string(bytes.ToUpper(b[1:4])) {
+	case "JPG":
+		pic.MIMEType = "image/jpeg"
+	case "PNG":
+		pic.MIMEType = "image/png"
+	default:
+		return nil
+	}
+	data, ok := skipDescription(b[0], b[5:])
+	if !ok {
+		return nil
+	}
+	pic.Data = data
+	return pic
+}
+
+// Skips the terminated description string, whose terminator width depends on
+// the text encoding.
+func skipDescription(enc byte, b []byte) ([]byte, bool) {
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return b[i+2:], true
+			}
+		}
+		return nil, false
+	}
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return nil, false
+	}
+	return b[i+1:], true
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
@@ -0,0 +1,72 @@
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func frame(version byte, id string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	var size [4]byte
+	if version == 4 {
+		n := uint32(len(data))
+		size = [4]byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+	} else {
+		binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	}
+	b.Write(size[:])
+	b.Write([]byte{0, 0})
+	b.Write(data)
+	return b.Bytes()
+}
+
+func tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	n := uint32(len(body))
+	header := []byte{'I', 'D', '3', version, 0, 0, byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+	return append(header, body...)
+}
+
+func TestReadPicturePrefersFrontCover(t *testing.T) {
+	for _, version := range []byte{3, 4} {
+		b := tag(version,
+			frame(version, "TIT2", []byte("\x00title")),
+			frame(version, "APIC", []byte("\x00image/png\x00\x04back\x00PNGDATA")),
+			frame(version, "APIC", []byte("\x01image/jpeg\x00\x03\xff\xfed\x00\x00\x00JPEGDATA")),
+		)
+		pic, err := ReadPicture(bytes.NewReader(append(b, "audio"...)), 1<<20)
+		if err != nil {
+			t.Fatalf("v2.%d: %s", version, err)
+		}
+		if pic.MIMEType != "image/jpeg" || string(pic.Data) != "JPEGDATA" {
+			t.Fatalf("v2.%d: %q %q", version, pic.MIMEType, pic.Data)
+		}
+	}
+}
+
+func TestReadPictureV22(t *testing.T) {
+	body := []byte("PIC\x00\x00\x0a\x00PNG\x00\x00data")
+	b := append([]byte{'I', 'D', '3', 2, 0, 0, 0, 0, 0, byte(len(body))}, body...)
+	pic, err := ReadPicture(bytes.NewReader(b), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pic.MIMEType != "image/png" || string(pic.Data) != "data" {
+		t.Fatalf("%q %q", pic.MIMEType, pic.Data)
+	}
+}
+
+func TestReadPictureErrors(t *testing.T) {
+	if _, err := ReadPicture(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00\x00\x00")), 1<<20); err != ErrNoTag {
+		t.Fatal(err)
+	}
+	b := tag(3, frame(3, "TIT2", []byte("\x00title")))
+	if _, err := ReadPicture(bytes.NewReader(b), 1<<20); err != ErrNoPicture {
+		t.Fatal(err)
+	}
+	if _, err := ReadPicture(bytes.NewReader(b), 4); err == nil {
+		t.Fatal("expected size limit error")
+	}
+}
@@ -0,0 +1,134 @@
+// Package ignore matches paths against patterns in the syntax of .gitignore
+// files.
+package ignore
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Rules are the patterns of an ignore file, relative to the directory it's
+// in.
+type Rules struct {
+	text     string
+	patterns []pattern
+}
+
+type pattern struct {
+	re *regexp.Regexp
+	// Re-includes what the pattern matches.
+	negate bool
+	// Only matches directories.
+	dirOnly bool
+}
+
+// Parse parses the patterns in an ignore file, one to a line. Blank lines and
+// those starting with # are skipped. As in .gitignore:
+//
+//   - A leading ! re-includes what an earlier pattern ignored.
+//   - A trailing / only matches directories.
+//   - A pattern with a / anywhere else is relative to the ignore file's
+//     directory, and otherwise matches names at any depth.
+//   - * and ? match within a name, ** matches any number of directories, and
+//     [...] matches a character in a class.
+//
+// A \ escapes the character after it, like a leading # or !.
+func Parse(text string) *Rules {
+	r := &Rules{text: text}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " \t")
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var p pattern
+		if line[0] == '!' {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		prefix := "^"
+		if !anchored {
+			prefix = "^(?:.*/)?"
+		}
+		re, err := regexp.Compile(prefix + globRegexp(line) + "$")
+		if err != nil {
+			continue
+		}
+		p.re = re
+		r.patterns = append(r.patterns, p)
+	}
+	return r
+}
+
+// Returns the regular expression for a glob.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case glob[i:] == "/**":
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return b.String()
+}
+
+// String returns the text the rules were parsed from.
+func (r *Rules) String() string {
+	return r.text
+}
+
+// Match reports whether the last pattern matching the path, relative to the
+// rules' directory, ignores it. Matched is false if no pattern matches.
+func (r *Rules) Match(relPath string, isDir bool) (ignored, matched bool) {
+	if r == nil {
+		return
+	}
+	for i := len(r.patterns) - 1; i >= 0; i-- {
+		p := r.patterns[i]
+		if p.dirOnly && !isDir || !p.re.MatchString(relPath) {
+			continue
+		}
+		return !p.negate, true
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package ignore
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	r := Parse(`# NAS junk
+@eaDir/
+*.part
+\#recycle
+/Downloads
+docs/*.mkv
+!keep.part
+**/extras/**
+a/**/b
+*.[!m]p4
+` + "trailing\\ \n")
+	for _, tc := range []struct {
+		path    string
+		isDir   bool
+		ignored bool
+		matched bool
+	}{
+		{"@eaDir", true, true, true},
+		{"Movies/@eaDir", true, true, true},
+		{"Movies/@eaDir", false, false, false},
+		{"Movies/film.mkv.part", false, true, true},
+		{"keep.part", false, false, true},
+		{"#recycle", true, true, true},
+		{"Downloads", true, true, true},
+		{"Movies/Downloads", true, false, false},
+		{"docs/a.mkv", false, true, true},
+		{"x/docs/a.mkv", false, false, false},
+		{"docs/sub/a.mkv", false, false, false},
+		{"Show/extras/a.mkv", false, true, true},
+		{"a/b", true, true, true},
+		{"a/x/y/b", true, true, true},
+		{"clip.xp4", false, true, true},
+		{"clip.mp4", false, false, false},
+		{"trailing ", false, true, true},
+		{"Movies/film.mkv", false, false, false},
+	} {
+		ignored, matched := r.Match(tc.path, tc.isDir)
+		if ignored != tc.ignored || matched != tc.matched {
+			t.Errorf("%q: %v, %v", tc.path, ignored, matched)
+		}
+	}
+	if r := Parse("*.part"); r.String() != "*.part" {
+		t.Fatal(r)
+	}
+	var none *Rules
+	if ignored, matched := none.Match("a", false); ignored || matched {
+		t.Fatal("nil rules matched")
+	}
+}
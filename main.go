@@ -4,11 +4,17 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/anacrolix/log"
 
 	"github.com/gofly/alipan-dms/dlna/dms"
+	"github.com/gofly/alipan-dms/title"
+	"github.com/gofly/alipan-dms/transcode"
 )
 
 func main() {
@@ -26,11 +32,90 @@ func main() {
 			inters = append(inters, i)
 		}
 	}
+	cacheDir := os.Getenv("CACHE_DIR")
+	if cacheDir == "" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			cacheDir = filepath.Join(dir, "alipan-dms")
+		}
+	}
+	// Like "Samsung=srt,LG=vtt,VLC=".
+	subtitleFormats := make(map[string]string)
+	for _, kv := range strings.Split(os.Getenv("SUBTITLE_FORMATS"), ",") {
+		if ua, format, ok := strings.Cut(kv, "="); ok && ua != "" {
+			subtitleFormats[ua] = format
+		}
+	}
+	var subtitleLanguages []string
+	if langs := os.Getenv("SUBTITLE_LANGUAGES"); langs != "" {
+		subtitleLanguages = strings.Split(langs, ",")
+	}
+	// Transcode with ffmpeg if it's installed, unless given a command. An
+	// empty command disables transcoding.
+	var transcodeCommand []string
+	if cmd, ok := os.LookupEnv("TRANSCODE_COMMAND"); ok {
+		transcodeCommand = strings.Fields(cmd)
+	} else if _, err := exec.LookPath("ffmpeg"); err == nil {
+		transcodeCommand = transcode.DefaultCommand
+	}
+	maxTranscodes, _ := strconv.Atoi(os.Getenv("MAX_TRANSCODES"))
+	streamCacheMB, _ := strconv.ParseInt(os.Getenv("STREAM_CACHE_MB"), 10, 64)
+	maxStreams, _ := strconv.Atoi(os.Getenv("MAX_STREAMS"))
+	maxClientStreams, _ := strconv.Atoi(os.Getenv("MAX_CLIENT_STREAMS"))
+	streamRateKB, _ := strconv.ParseInt(os.Getenv("STREAM_RATE_KB"), 10, 64)
+	clientStreamRateKB, _ := strconv.ParseInt(os.Getenv("CLIENT_STREAM_RATE_KB"), 10, 64)
+	// More rules that make titles of names, read from a file of lines like
+	// "regexp<TAB>replacement", applied after the default rules.
+	titleRules := title.DefaultRules
+	if file := os.Getenv("TITLE_RULES_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			var rules []title.Rule
+			if rules, err = title.ParseRules(string(data)); err == nil {
+				titleRules = append(append([]title.Rule{}, title.DefaultRules...), rules...)
+			}
+		}
+		if err != nil {
+			logger.Printf("[FATAL] env TITLE_RULES_FILE invalid: %v", err)
+			os.Exit(1)
+		}
+	}
+	var rawTitleFolders []string
+	if dirs := os.Getenv("RAW_TITLE_FOLDERS"); dirs != "" {
+		rawTitleFolders = strings.Split(dirs, ",")
+	}
+	folderPlaylists, _ := strconv.ParseBool(os.Getenv("FOLDER_PLAYLISTS"))
+	showHidden, _ := strconv.ParseBool(os.Getenv("SHOW_HIDDEN"))
+	// More patterns, like those of a .gitignore, of what isn't served.
+	ignorePatterns := dms.DefaultIgnorePatterns
+	if patterns := os.Getenv("IGNORE_PATTERNS"); patterns != "" {
+		ignorePatterns = append(append([]string{}, dms.DefaultIgnorePatterns...), strings.Split(patterns, ",")...)
+	}
+	var mediaReceivers []string
+	if ids := os.Getenv("MEDIA_RECEIVERS"); ids != "" {
+		mediaReceivers = strings.Split(ids, ",")
+	}
 	dmsServer := &dms.Server{
-		FriendlyName:   "阿里云盘",
-		Interfaces:     inters,
-		RootObjectPath: "/",
-		WebdavURI:      webdavURI,
+		FriendlyName:      "阿里云盘",
+		Interfaces:        inters,
+		RootObjectPath:    "/",
+		WebdavURI:         webdavURI,
+		CacheDir:          cacheDir,
+		SubtitleFormats:   subtitleFormats,
+		SubtitleLanguages: subtitleLanguages,
+		TranscodeCommand:  transcodeCommand,
+		MaxTranscodes:     maxTranscodes,
+		StreamCacheSize:   streamCacheMB << 20,
+		DownloadReferer:   os.Getenv("DOWNLOAD_REFERER"),
+		MaxStreams:        maxStreams,
+		MaxClientStreams:  maxClientStreams,
+		StreamRate:        streamRateKB << 10,
+		ClientStreamRate:  clientStreamRateKB << 10,
+		MediaReceivers:    mediaReceivers,
+		TitleRules:        titleRules,
+		RawTitleFolders:   rawTitleFolders,
+		FolderPlaylists:   folderPlaylists,
+		ShowHidden:        showHidden,
+		IgnorePatterns:    ignorePatterns,
 		HTTPConn: func() net.Listener {
 			conn, err := net.Listen("tcp", ":8083")
 			if err != nil {
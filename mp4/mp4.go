@@ -0,0 +1,183 @@
+// Package mp4 reads ISO base media (MP4, M4A, MOV) box structures through
+// random access, so only the parts of interest need to be fetched.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Box is an MP4 box (atom) header.
+type Box struct {
+	Type       string
+	Offset     int64 // Of the header within the file.
+	Size       int64 // Including the header.
+	HeaderSize int64
+}
+
+// DataOffset returns the offset of the box's payload.
+func (b Box) DataOffset() int64 {
+	return b.Offset + b.HeaderSize
+}
+
+// End returns the offset just past the box.
+func (b Box) End() int64 {
+	return b.Offset + b.Size
+}
+
+var ErrNotFound = errors.New("box not found")
+
+// ReadBox reads the box header at off. Boxes are clipped to end, which is
+// where boxes that extend to the end of the file stop too.
+func ReadBox(r io.ReaderAt, off, end int64) (b Box, err error) {
+	var h [16]byte
+	n, err := r.ReadAt(h[:], off)
+	if n < 8 {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	b = Box{
+		Type:       string(h[4:8]),
+		Offset:     off,
+		Size:       int64(binary.BigEndian.Uint32(h[:4])),
+		HeaderSize: 8,
+	}
+	switch b.Size {
+	case 0:
+		b.Size = end - off
+	case 1:
+		if n < 16 {
+			return b, io.ErrUnexpectedEOF
+		}
+		b.Size = int64(binary.BigEndian.Uint64(h[8:]))
+		b.HeaderSize = 16
+	}
+	if b.Size < b.HeaderSize {
+		return b, fmt.Errorf("bad %q box size %d at %d", b.Type, b.Size, off)
+	}
+	if b.End() > end {
+		b.Size = end - off
+	}
+	return b, nil
+}
+
+// Children returns the boxes contained in parent.
+func Children(r io.ReaderAt, parent Box) (ret []Box, err error) {
+	start := parent.DataOffset()
+	if isFullBox(parent.Type) {
+		start += 4
+		if parent.Type == "meta" && isQuickTimeMeta(r, start-4) {
+			// QuickTime meta boxes have no version and flags.
+			start -= 4
+		}
+	}
+	return readBoxes(r, start, parent.End())
+}
+
+// Container boxes that are preceded by a version and flags.
+func isFullBox(typ string) bool {
+	return typ == "meta" || typ == "stsd"
+}
+
+// Returns whether the meta payload at off starts with a box rather than
+// version and flags.
+func isQuickTimeMeta(r io.ReaderAt, off int64) bool {
+	var h [8]byte
+	if _, err := r.ReadAt(h[:], off); err != nil {
+		return false
+	}
+	return string(h[4:8]) == "hdlr"
+}
+
+func readBoxes(r io.ReaderAt, off, end int64) (ret []Box, err error) {
+	for off+8 <= end {
+		var b Box
+		b, err = ReadBox(r, off, end)
+		if err != nil {
+			return
+		}
+		ret = append(ret, b)
+		off = b.End()
+	}
+	return
+}
+
+// Find descends from the top level of a file of the given size through the
+// path of box types, returning the first match at each level.
+func Find(r io.ReaderAt, size int64, path ...string) (Box, error) {
+	boxes, err := readBoxes(r, 0, size)
+	if err != nil && len(boxes) == 0 {
+		return Box{}, err
+	}
+	for i, typ := range path {
+		var found *Box
+		for j := range boxes {
+			if boxes[j].Type == typ {
+				found = &boxes[j]
+				break
+			}
+		}
+		if found == nil {
+			return Box{}, fmt.Errorf("%w: %s", ErrNotFound, typ)
+		}
+		if i == len(path)-1 {
+			return *found, nil
+		}
+		if boxes, err = Children(r, *found); err != nil && len(boxes) == 0 {
+			return Box{}, err
+		}
+	}
+	return Box{}, ErrNotFound
+}
+
+// ReadData reads the payload of a box, refusing ones larger than max.
+func ReadData(r io.ReaderAt, b Box, max int64) ([]byte, error) {
+	n := b.Size - b.HeaderSize
+	if n > max {
+		return nil, fmt.Errorf("%q box too large: %d bytes", b.Type, n)
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, b.DataOffset()); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Data types in iTunes metadata data boxes.
+const (
+	dataTypeJPEG = 13
+	dataTypePNG  = 14
+)
+
+// CoverArt returns the first image in the iTunes style cover (covr) metadata
+// of a file, and its MIME-type. No image larger than max is read.
+func CoverArt(r io.ReaderAt, size, max int64) ([]byte, string, error) {
+	covr, err := Find(r, size, "moov", "udta", "meta", "ilst", "covr")
+	if err != nil {
+		return nil, "", err
+	}
+	boxes, err := Children(r, covr)
+	for _, b := range boxes {
+		if b.Type != "data" || b.Size < b.HeaderSize+8 {
+			continue
+		}
+		// Type indicator and locale precede the image.
+		data, err := ReadData(r, b, max+8)
+		if err != nil {
+			return nil, "", err
+		}
+		mimeType := "image/jpeg"
+		if binary.BigEndian.Uint32(data[:4])&0xffffff == dataTypePNG {
+			mimeType = "image/png"
+		}
+		return data[8:], mimeType, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("%w: covr data", ErrNotFound)
+	}
+	return nil, "", err
+}
@@ -0,0 +1,64 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], typ)
+	return append(b, body...)
+}
+
+func coverFile(quickTime bool) []byte {
+	meta := [][]byte{
+		box("hdlr", make([]byte, 25)),
+		box("ilst", box("covr", box("data", []byte{0, 0, 0, dataTypePNG, 0, 0, 0, 0}, []byte("PNGDATA")))),
+	}
+	if !quickTime {
+		meta = append([][]byte{{0, 0, 0, 0}}, meta...)
+	}
+	return bytes.Join([][]byte{
+		box("ftyp", []byte("isom")),
+		box("mdat", make([]byte, 100)),
+		box("moov", box("mvhd", make([]byte, 100)), box("udta", box("meta", meta...))),
+	}, nil)
+}
+
+func TestCoverArt(t *testing.T) {
+	for _, quickTime := range []bool{false, true} {
+		f := coverFile(quickTime)
+		data, mimeType, err := CoverArt(bytes.NewReader(f), int64(len(f)), 1<<20)
+		if err != nil {
+			t.Fatal(quickTime, err)
+		}
+		if string(data) != "PNGDATA" || mimeType != "image/png" {
+			t.Fatal(quickTime, data, mimeType)
+		}
+	}
+}
+
+func TestCoverArtMissing(t *testing.T) {
+	f := bytes.Join([][]byte{box("ftyp"), box("moov", box("mvhd"))}, nil)
+	if _, _, err := CoverArt(bytes.NewReader(f), int64(len(f)), 1<<20); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestReadBoxExtendedSize(t *testing.T) {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint32(b, 1)
+	copy(b[4:], "mdat")
+	binary.BigEndian.PutUint64(b[8:], 24)
+	got, err := ReadBox(bytes.NewReader(b), 0, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Size != 24 || got.HeaderSize != 16 || got.Type != "mdat" {
+		t.Fatalf("%+v", got)
+	}
+}
@@ -0,0 +1,378 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Largest sample table that will be read.
+const maxTableSize = 64 << 20
+
+// Track holds the sample tables of a track, which map between presentation
+// times and file offsets.
+type Track struct {
+	// Handler type, like "vide" or "soun".
+	Handler   string
+	Timescale uint32
+	// In Timescale units.
+	Duration uint64
+	// Runs of samples with the same duration.
+	timeToSample []struct{ count, delta uint32 }
+	// Sample numbers of sync samples, starting at 1. Nil if every sample is
+	// a sync sample.
+	syncSamples []uint32
+	// Runs of chunks with the same number of samples, starting at chunk 1.
+	sampleToChunk []struct{ firstChunk, samplesPerChunk uint32 }
+	chunkOffsets  []int64
+	// Nonzero if every sample has the same size.
+	sampleSize  uint32
+	sampleSizes []uint32
+	sampleCount uint32
+}
+
+// Duration returns the duration of the movie from its header.
+func Duration(r io.ReaderAt, size int64) (time.Duration, error) {
+	mvhd, err := Find(r, size, "moov", "mvhd")
+	if err != nil {
+		return 0, err
+	}
+	b := make([]byte, 32)
+	n, err := r.ReadAt(b, mvhd.DataOffset())
+	if int64(n) > mvhd.Size-mvhd.HeaderSize {
+		n = int(mvhd.Size - mvhd.HeaderSize)
+	}
+	if n < 20 && err != nil {
+		return 0, err
+	}
+	timescale, duration, err := readTimes(b[:n])
+	if err != nil {
+		return 0, err
+	}
+	return unitsToDuration(duration, timescale), nil
+}
+
+// Reads the timescale and duration from a mvhd or mdhd payload.
+func readTimes(b []byte) (timescale uint32, duration uint64, err error) {
+	if len(b) >= 32 && b[0] == 1 {
+		return binary.BigEndian.Uint32(b[20:]), binary.BigEndian.Uint64(b[24:]), nil
+	}
+	if len(b) >= 20 && b[0] == 0 {
+		return binary.BigEndian.Uint32(b[12:]), uint64(binary.BigEndian.Uint32(b[16:])), nil
+	}
+	return 0, 0, fmt.Errorf("bad movie header")
+}
+
+func unitsToDuration(units uint64, timescale uint32) time.Duration {
+	if timescale == 0 {
+		return 0
+	}
+	ts := uint64(timescale)
+	return time.Duration(units/ts)*time.Second + time.Duration(units%ts)*time.Second/time.Duration(ts)
+}
+
+func durationToUnits(d time.Duration, timescale uint32) uint64 {
+	if d < 0 {
+		return 0
+	}
+	ts := uint64(timescale)
+	return uint64(d/time.Second)*ts + uint64(d%time.Second)*ts/uint64(time.Second)
+}
+
+// ReadTracks reads the sample tables of the tracks in the file.
+func ReadTracks(r io.ReaderAt, size int64) (ret []*Track, err error) {
+	moov, err := Find(r, size, "moov")
+	if err != nil {
+		return
+	}
+	boxes, err := Children(r, moov)
+	if err != nil {
+		return
+	}
+	for _, b := range boxes {
+		if b.Type != "trak" {
+			continue
+		}
+		t, err := readTrack(r, b)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, t)
+	}
+	if len(ret) == 0 {
+		err = fmt.Errorf("%w: trak", ErrNotFound)
+	}
+	return
+}
+
+// Returns the payloads of the children of parent with the given types.
+func childData(r io.ReaderAt, parent Box, types ...string) (map[string][]byte, error) {
+	boxes, err := Children(r, parent)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]byte)
+	for _, b := range boxes {
+		for _, typ := range types {
+			if b.Type != typ {
+				continue
+			}
+			if ret[typ], err = ReadData(r, b, maxTableSize); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ret, nil
+}
+
+func child(r io.ReaderAt, parent Box, typ string) (Box, error) {
+	boxes, err := Children(r, parent)
+	if err != nil {
+		return Box{}, err
+	}
+	for _, b := range boxes {
+		if b.Type == typ {
+			return b, nil
+		}
+	}
+	return Box{}, fmt.Errorf("%w: %s", ErrNotFound, typ)
+}
+
+func readTrack(r io.ReaderAt, trak Box) (*Track, error) {
+	mdia, err := child(r, trak, "mdia")
+	if err != nil {
+		return nil, err
+	}
+	mdiaData, err := childData(r, mdia, "mdhd", "hdlr")
+	if err != nil {
+		return nil, err
+	}
+	t := &Track{}
+	if t.Timescale, t.Duration, err = readTimes(mdiaData["mdhd"]); err != nil {
+		return nil, err
+	}
+	if hdlr := mdiaData["hdlr"]; len(hdlr) >= 12 {
+		t.Handler = string(hdlr[8:12])
+	}
+	minf, err := child(r, mdia, "minf")
+	if err != nil {
+		return nil, err
+	}
+	stbl, err := child(r, minf, "stbl")
+	if err != nil {
+		return nil, err
+	}
+	tables, err := childData(r, stbl, "stts", "stss", "stsc", "stco", "co64", "stsz")
+	if err != nil {
+		return nil, err
+	}
+	return t, t.parseTables(tables)
+}
+
+// Returns the entries of a full box table, each of the given size.
+func tableEntries(b []byte, header, entrySize int) ([]byte, int, error) {
+	if len(b) < header+4 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	n := int(binary.BigEndian.Uint32(b[header:]))
+	b = b[header+4:]
+	if n < 0 || n > len(b)/entrySize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	return b, n, nil
+}
+
+func (t *Track) parseTables(tables map[string][]byte) error {
+	be := binary.BigEndian
+	b, n, err := tableEntries(tables["stts"], 4, 8)
+	if err != nil {
+		return fmt.Errorf("stts: %w", err)
+	}
+	for i := 0; i < n; i++ {
+		t.timeToSample = append(t.timeToSample, struct{ count, delta uint32 }{be.Uint32(b[i*8:]), be.Uint32(b[i*8+4:])})
+	}
+	if stss, ok := tables["stss"]; ok {
+		b, n, err := tableEntries(stss, 4, 4)
+		if err != nil {
+			return fmt.Errorf("stss: %w", err)
+		}
+		t.syncSamples = make([]uint32, n)
+		for i := range t.syncSamples {
+			t.syncSamples[i] = be.Uint32(b[i*4:])
+		}
+	}
+	b, n, err = tableEntries(tables["stsc"], 4, 12)
+	if err != nil {
+		return fmt.Errorf("stsc: %w", err)
+	}
+	for i := 0; i < n; i++ {
+		t.sampleToChunk = append(t.sampleToChunk, struct{ firstChunk, samplesPerChunk uint32 }{be.Uint32(b[i*12:]), be.Uint32(b[i*12+4:])})
+	}
+	if co64, ok := tables["co64"]; ok {
+		b, n, err := tableEntries(co64, 4, 8)
+		if err != nil {
+			return fmt.Errorf("co64: %w", err)
+		}
+		for i := 0; i < n; i++ {
+			t.chunkOffsets = append(t.chunkOffsets, int64(be.Uint64(b[i*8:])))
+		}
+	} else {
+		b, n, err := tableEntries(tables["stco"], 4, 4)
+		if err != nil {
+			return fmt.Errorf("stco: %w", err)
+		}
+		for i := 0; i < n; i++ {
+			t.chunkOffsets = append(t.chunkOffsets, int64(be.Uint32(b[i*4:])))
+		}
+	}
+	stsz := tables["stsz"]
+	if len(stsz) < 12 {
+		return fmt.Errorf("stsz: %w", io.ErrUnexpectedEOF)
+	}
+	t.sampleSize = be.Uint32(stsz[4:])
+	t.sampleCount = be.Uint32(stsz[8:])
+	if t.sampleSize == 0 {
+		b, n, err := tableEntries(stsz, 8, 4)
+		if err != nil {
+			return fmt.Errorf("stsz: %w", err)
+		}
+		t.sampleSizes = make([]uint32, n)
+		for i := range t.sampleSizes {
+			t.sampleSizes[i] = be.Uint32(b[i*4:])
+		}
+		t.sampleCount = uint32(n)
+	}
+	return nil
+}
+
+// Length returns the duration of the track.
+func (t *Track) Length() time.Duration {
+	return unitsToDuration(t.Duration, t.Timescale)
+}
+
+// Returns the sample, counting from 0, that's presented at the given time in
+// Timescale units, and when it starts.
+func (t *Track) sampleAt(units uint64) (sample uint32, start uint64) {
+	for _, e := range t.timeToSample {
+		run := uint64(e.count) * uint64(e.delta)
+		if e.delta != 0 && units < start+run {
+			n := (units - start) / uint64(e.delta)
+			return sample + uint32(n), start + n*uint64(e.delta)
+		}
+		sample += e.count
+		start += run
+	}
+	// Past the end: the last sample.
+	if sample > 0 {
+		sample--
+		if n := len(t.timeToSample); n != 0 {
+			start -= uint64(t.timeToSample[n-1].delta)
+		}
+	}
+	return
+}
+
+// Returns when the sample, counting from 0, is presented.
+func (t *Track) sampleTime(sample uint32) (start uint64) {
+	for _, e := range t.timeToSample {
+		if sample < e.count {
+			return start + uint64(sample)*uint64(e.delta)
+		}
+		sample -= e.count
+		start += uint64(e.count) * uint64(e.delta)
+	}
+	return
+}
+
+// Returns the last sync sample at or before the sample, counting from 0.
+func (t *Track) syncSampleBefore(sample uint32) uint32 {
+	if t.syncSamples == nil {
+		return sample
+	}
+	i := sort.Search(len(t.syncSamples), func(i int) bool {
+		return t.syncSamples[i] > sample+1
+	})
+	if i == 0 {
+		return 0
+	}
+	return t.syncSamples[i-1] - 1
+}
+
+// Returns the file offset of the sample, counting from 0.
+func (t *Track) sampleOffset(sample uint32) (int64, error) {
+	if sample >= t.sampleCount {
+		return 0, fmt.Errorf("sample %d out of range", sample)
+	}
+	first := uint32(0)
+	for i, e := range t.sampleToChunk {
+		if e.samplesPerChunk == 0 || e.firstChunk == 0 {
+			continue
+		}
+		chunks := uint32(len(t.chunkOffsets)) + 1 - e.firstChunk
+		if i+1 < len(t.sampleToChunk) {
+			chunks = t.sampleToChunk[i+1].firstChunk - e.firstChunk
+		}
+		if sample-first >= chunks*e.samplesPerChunk {
+			first += chunks * e.samplesPerChunk
+			continue
+		}
+		chunk := e.firstChunk - 1 + (sample-first)/e.samplesPerChunk
+		if int(chunk) >= len(t.chunkOffsets) {
+			break
+		}
+		off := t.chunkOffsets[chunk]
+		for s := sample - (sample-first)%e.samplesPerChunk; s < sample; s++ {
+			off += int64(t.size(s))
+		}
+		return off, nil
+	}
+	return 0, fmt.Errorf("sample %d not in any chunk", sample)
+}
+
+func (t *Track) size(sample uint32) uint32 {
+	if t.sampleSize != 0 {
+		return t.sampleSize
+	}
+	return t.sampleSizes[sample]
+}
+
+// Seek returns the file offset of the last sync sample presented at or before
+// d, and when it's presented.
+func (t *Track) Seek(d time.Duration) (int64, time.Duration, error) {
+	sample, _ := t.sampleAt(durationToUnits(d, t.Timescale))
+	sample = t.syncSampleBefore(sample)
+	off, err := t.sampleOffset(sample)
+	return off, unitsToDuration(t.sampleTime(sample), t.Timescale), err
+}
+
+// Seek returns the offset to play the tracks from to start at d, along with
+// the time playback actually starts at. The video track's sync samples
+// determine the time, and the offset is early enough for every track's
+// samples from then.
+func Seek(tracks []*Track, d time.Duration) (off int64, start time.Duration, err error) {
+	if len(tracks) == 0 {
+		return 0, 0, fmt.Errorf("%w: trak", ErrNotFound)
+	}
+	main := tracks[0]
+	for _, t := range tracks {
+		if t.Handler == "vide" {
+			main = t
+			break
+		}
+	}
+	if off, start, err = main.Seek(d); err != nil {
+		return
+	}
+	for _, t := range tracks {
+		if t == main || t.sampleCount == 0 || t.Timescale == 0 {
+			continue
+		}
+		sample, _ := t.sampleAt(durationToUnits(start, t.Timescale))
+		if o, err := t.sampleOffset(sample); err == nil && o < off {
+			off = o
+		}
+	}
+	return
+}
@@ -0,0 +1,87 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func u32s(vs ...uint32) []byte {
+	b := make([]byte, 4*len(vs))
+	for i, v := range vs {
+		binary.BigEndian.PutUint32(b[i*4:], v)
+	}
+	return b
+}
+
+// A track of samples with the same duration and size, stored in chunks of
+// samplesPerChunk at the given offsets.
+func trak(handler string, timescale, delta, size, samplesPerChunk uint32, sync []uint32, chunkOffsets ...uint32) []byte {
+	count := uint32(len(chunkOffsets)) * samplesPerChunk
+	stbl := [][]byte{
+		box("stts", u32s(0, 1, count, delta)),
+		box("stsc", u32s(0, 1, 1, samplesPerChunk, 1)),
+		box("stco", u32s(0, uint32(len(chunkOffsets))), u32s(chunkOffsets...)),
+		box("stsz", u32s(0, size, count)),
+	}
+	if sync != nil {
+		stbl = append(stbl, box("stss", u32s(0, uint32(len(sync))), u32s(sync...)))
+	}
+	return box("trak", box("mdia",
+		box("mdhd", u32s(0, 0, 0, timescale, count*delta, 0)),
+		box("hdlr", u32s(0, 0), []byte(handler), make([]byte, 13)),
+		box("minf", box("stbl", stbl...)),
+	))
+}
+
+func seekFile() []byte {
+	return bytes.Join([][]byte{
+		box("ftyp", []byte("isom")),
+		box("moov",
+			box("mvhd", u32s(0, 0, 0, 600, 6000), make([]byte, 80)),
+			// Audio is stored ahead of the video it plays with.
+			trak("soun", 1000, 2000, 10, 1, nil, 900, 1100, 1300, 1500, 1700),
+			trak("vide", 1000, 1000, 100, 2, []uint32{1, 6}, 1000, 1200, 1400, 1600, 1800),
+		),
+	}, nil)
+}
+
+func TestDuration(t *testing.T) {
+	f := seekFile()
+	d, err := Duration(bytes.NewReader(f), int64(len(f)))
+	if err != nil || d != 10*time.Second {
+		t.Fatal(d, err)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	f := seekFile()
+	tracks, err := ReadTracks(bytes.NewReader(f), int64(len(f)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 2 || tracks[1].Handler != "vide" || tracks[1].Length() != 10*time.Second {
+		t.Fatal(tracks)
+	}
+	for _, tc := range []struct {
+		d     time.Duration
+		off   int64
+		start time.Duration
+	}{
+		{0, 900, 0},
+		{4999 * time.Millisecond, 900, 0},
+		// Sync sample 6 is the second in the third chunk, and the audio
+		// sample playing then is in the third audio chunk.
+		{7500 * time.Millisecond, 1300, 5 * time.Second},
+		{time.Hour, 1300, 5 * time.Second},
+	} {
+		off, start, err := Seek(tracks, tc.d)
+		if err != nil || off != tc.off || start != tc.start {
+			t.Errorf("Seek(%v) = %d, %v, %v; want %d, %v", tc.d, off, start, err, tc.off, tc.start)
+		}
+	}
+	if off, _, _ := tracks[1].Seek(7500 * time.Millisecond); off != 1500 {
+		t.Errorf("video track offset %d", off)
+	}
+}
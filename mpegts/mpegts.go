@@ -0,0 +1,355 @@
+// Package mpegts maps between times and offsets in MPEG transport streams
+// using their program clock references (PCRs), reading only small ranges of
+// the stream.
+package mpegts
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	syncByte = 0x47
+	// How far from an offset a PCR is looked for. Streams are required to
+	// carry one at least every 100ms.
+	pcrSearchSize = 2 << 20
+	// Reads are made in multiples of packets about this size.
+	readSize = 64 << 10
+	// Bisection stops once the range is this small.
+	seekPrecision = 128 << 10
+	// PCR base values wrap at 33 bits.
+	pcrWrap = 1 << 33
+)
+
+var (
+	ErrNotTS = errors.New("not an MPEG transport stream")
+	ErrNoPCR = errors.New("no PCR found")
+)
+
+// Stream is a transport stream with a program clock.
+type Stream struct {
+	r    io.ReaderAt
+	size int64
+	// 188, or 192 for M2TS with its timestamp prefix.
+	packetSize int64
+	// Offset of the first packet's sync byte.
+	start int64
+	// The PID carrying the PCRs followed.
+	pcrPID   uint16
+	firstPCR uint64
+	// Read by programs.
+	programsRead bool
+	tables       []byte
+	// Zero if there's no video stream, as PID 0 carries the PAT.
+	videoPID uint16
+}
+
+// Open finds the packet layout and first PCR of the stream.
+func Open(r io.ReaderAt, size int64) (*Stream, error) {
+	buf := make([]byte, 192*8)
+	n, err := r.ReadAt(buf, 0)
+	if n < len(buf) && err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+	s := &Stream{r: r, size: size}
+	for _, ps := range []int64{188, 192} {
+		for off := int64(0); off < ps && off+3*ps <= int64(len(buf)); off++ {
+			if buf[off] == syncByte && buf[off+ps] == syncByte && buf[off+2*ps] == syncByte {
+				s.packetSize, s.start = ps, off
+				break
+			}
+		}
+		if s.packetSize != 0 {
+			break
+		}
+	}
+	if s.packetSize == 0 {
+		return nil, ErrNotTS
+	}
+	pcr, _, err := s.pcrAfter(s.start, true)
+	if err != nil {
+		return nil, err
+	}
+	s.firstPCR = pcr
+	return s, nil
+}
+
+// Returns the offset of the packet containing off.
+func (s *Stream) align(off int64) int64 {
+	if off < s.start {
+		return s.start
+	}
+	return s.start + (off-s.start)/s.packetSize*s.packetSize
+}
+
+// Returns the PCR base of the packet, and its PID, if it has one.
+func packetPCR(p []byte) (pcr uint64, pid uint16, ok bool) {
+	if len(p) < 11 || p[0] != syncByte {
+		return
+	}
+	pid = uint16(p[1]&0x1f)<<8 | uint16(p[2])
+	// Adaptation field present, non-empty, with the PCR flag.
+	if p[3]&0x20 == 0 || p[4] == 0 || p[5]&0x10 == 0 {
+		return
+	}
+	pcr = uint64(p[6])<<25 | uint64(p[7])<<17 | uint64(p[8])<<9 | uint64(p[9])<<1 | uint64(p[10])>>7
+	return pcr, pid, true
+}
+
+// Calls f with the packets from off up to limit, and their offsets, until f
+// returns false. The last packet may be cut short by the end of the stream.
+func (s *Stream) packets(off, limit int64, f func(p []byte, off int64) bool) error {
+	off = s.align(off)
+	chunk := readSize / s.packetSize * s.packetSize
+	buf := make([]byte, chunk)
+	for ; off < limit && off < s.size; off += chunk {
+		n, err := s.r.ReadAt(buf, off)
+		for i := int64(0); i < int64(n) && off+i < limit; i += s.packetSize {
+			end := i + 188
+			if end > int64(n) {
+				end = int64(n)
+			}
+			if !f(buf[i:end], off+i) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Calls f with the PCRs of the followed PID, and the offsets of their
+// packets, in the packets from off up to limit, until f returns false. Any
+// PID is accepted if anyPID, and followed from then on.
+func (s *Stream) scan(off, limit int64, anyPID bool, f func(pcr uint64, off int64) bool) error {
+	return s.packets(off, limit, func(p []byte, off int64) bool {
+		pcr, pid, ok := packetPCR(p)
+		if !ok || !anyPID && pid != s.pcrPID {
+			return true
+		}
+		s.pcrPID, anyPID = pid, false
+		return f(pcr, off)
+	})
+}
+
+// Returns the first PCR at or after off, and the offset of its packet.
+func (s *Stream) pcrAfter(off int64, anyPID bool) (pcr uint64, pcrOff int64, err error) {
+	found := false
+	err = s.scan(off, off+pcrSearchSize, anyPID, func(p uint64, o int64) bool {
+		pcr, pcrOff, found = p, o, true
+		return false
+	})
+	if err == nil && !found {
+		err = ErrNoPCR
+	}
+	return
+}
+
+// Returns the PCR base as a time since the start of the stream.
+func (s *Stream) elapsed(pcr uint64) time.Duration {
+	units := (pcr + pcrWrap - s.firstPCR) % pcrWrap
+	return time.Duration(units * 100000 / 9)
+}
+
+// Duration returns the time between the first and last PCRs.
+func (s *Stream) Duration() (time.Duration, error) {
+	for back := int64(pcrSearchSize / 2); ; back *= 2 {
+		from := s.size - back
+		if from < s.start {
+			from = s.start
+		}
+		var last uint64
+		found := false
+		err := s.scan(from, s.size, false, func(pcr uint64, _ int64) bool {
+			last, found = pcr, true
+			return true
+		})
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return s.elapsed(last), nil
+		}
+		if from == s.start {
+			return 0, ErrNoPCR
+		}
+	}
+}
+
+// Seek returns the offset of a packet with a PCR at or before d, found by
+// bisection, and the time of that PCR.
+func (s *Stream) Seek(d time.Duration) (int64, time.Duration, error) {
+	lo, hi := s.start, s.size
+	var loTime time.Duration
+	for hi-lo > seekPrecision {
+		mid := s.align(lo + (hi-lo)/2)
+		pcr, off, err := s.pcrAfter(mid, false)
+		if err == ErrNoPCR {
+			hi = mid
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if t := s.elapsed(pcr); t <= d {
+			lo, loTime = off, t
+		} else {
+			hi = mid
+		}
+	}
+	return lo, loTime, nil
+}
+
+// Returns the PID of the packet, whether it starts a payload unit, and its
+// payload.
+func packetPayload(p []byte) (pid uint16, start bool, payload []byte) {
+	if len(p) < 188 || p[0] != syncByte {
+		return 0x1fff, false, nil
+	}
+	pid = uint16(p[1]&0x1f)<<8 | uint16(p[2])
+	start = p[1]&0x40 != 0
+	i := 4
+	if p[3]&0x20 != 0 {
+		i += 1 + int(p[4])
+	}
+	if p[3]&0x10 == 0 || i >= 188 {
+		return pid, start, nil
+	}
+	return pid, start, p[i:188]
+}
+
+// Returns the section a payload unit starts, up to its CRC, if it has the
+// table ID.
+func section(payload []byte, tableID byte) []byte {
+	if len(payload) == 0 || 1+int(payload[0])+3 > len(payload) {
+		return nil
+	}
+	sec := payload[1+int(payload[0]):]
+	if sec[0] != tableID {
+		return nil
+	}
+	end := 3 + (int(sec[1]&0x0f)<<8 | int(sec[2])) - 4
+	if end > len(sec) || end < 8 {
+		return nil
+	}
+	return sec[:end]
+}
+
+// Stream types of video elementary streams.
+var videoStreamTypes = map[byte]bool{
+	0x01: true, // MPEG-1
+	0x02: true, // MPEG-2
+	0x10: true, // MPEG-4 Part 2
+	0x1b: true, // H.264
+	0x20: true, // H.264 MVC
+	0x24: true, // H.265
+	0x42: true, // AVS
+	0xea: true, // VC-1
+}
+
+// Finds the first PAT and the PMT of its first program near the start of the
+// stream, keeping their packets and the PID of the program's video.
+func (s *Stream) programs() error {
+	if s.programsRead {
+		return nil
+	}
+	var pat, pmt []byte
+	var pmtPID uint16
+	err := s.packets(s.start, s.start+pcrSearchSize, func(p []byte, off int64) bool {
+		pid, start, payload := packetPayload(p)
+		switch {
+		case !start:
+		case pat == nil && pid == 0:
+			sec := section(payload, 0x00)
+			for i := 8; i+4 <= len(sec); i += 4 {
+				if program := uint16(sec[i])<<8 | uint16(sec[i+1]); program != 0 {
+					pmtPID = uint16(sec[i+2]&0x1f)<<8 | uint16(sec[i+3])
+					pat = append([]byte(nil), p...)
+					break
+				}
+			}
+		case pat != nil && pid == pmtPID:
+			sec := section(payload, 0x02)
+			if len(sec) < 12 {
+				break
+			}
+			for i := 12 + (int(sec[10]&0x0f)<<8 | int(sec[11])); i+5 <= len(sec); {
+				esPID := uint16(sec[i+1]&0x1f)<<8 | uint16(sec[i+2])
+				if videoStreamTypes[sec[i]] && s.videoPID == 0 {
+					s.videoPID = esPID
+				}
+				i += 5 + (int(sec[i+3]&0x0f)<<8 | int(sec[i+4]))
+			}
+			pmt = append([]byte(nil), p...)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	s.programsRead = true
+	if pmt != nil {
+		s.tables = append(pat, pmt...)
+	}
+	return nil
+}
+
+// ProgramTables returns the packets of the PAT and PMT found near the start
+// of the stream, which players need before anything else to decode a part of
+// it. Nil is returned if there aren't any.
+func (s *Stream) ProgramTables() ([]byte, error) {
+	if err := s.programs(); err != nil {
+		return nil, err
+	}
+	return s.tables, nil
+}
+
+// Packets returns the transport packets of data read from the offset of a
+// packet of the stream, without the timestamp prefixes of M2TS, so it can be
+// followed by other transport packets, like those of ProgramTables.
+func (s *Stream) Packets(data []byte) []byte {
+	if s.packetSize == 188 {
+		return data
+	}
+	ret := make([]byte, 0, int64(len(data))/s.packetSize*188+188)
+	for i := int64(0); i < int64(len(data)); i += s.packetSize {
+		end := i + 188
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		ret = append(ret, data[i:end]...)
+	}
+	return ret
+}
+
+// RandomAccess returns the offset of the first packet from off up to limit
+// that's marked as a random access point, like a keyframe, of the video, or
+// of the followed PID if there's no video. False is returned if there's none.
+func (s *Stream) RandomAccess(off, limit int64) (int64, bool, error) {
+	if err := s.programs(); err != nil {
+		return 0, false, err
+	}
+	pid := s.videoPID
+	if pid == 0 {
+		pid = s.pcrPID
+	}
+	var found int64 = -1
+	err := s.packets(off, limit, func(p []byte, off int64) bool {
+		// The random access indicator of a non-empty adaptation field.
+		if len(p) < 6 || p[0] != syncByte || uint16(p[1]&0x1f)<<8|uint16(p[2]) != pid ||
+			p[3]&0x20 == 0 || p[4] == 0 || p[5]&0x40 == 0 {
+			return true
+		}
+		found = off
+		return false
+	})
+	return found, found >= 0, err
+}
@@ -0,0 +1,116 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+const testPID = 0x100
+
+// Builds a stream with a PCR on testPID every 10 packets, advancing 100ms
+// each time from base. Packets of other PIDs carry their own PCRs.
+func testStream(packets int, packetSize int, base uint64) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < packets; i++ {
+		p := make([]byte, packetSize)
+		ts := p[packetSize-188:]
+		ts[0] = syncByte
+		pid := uint16(0x200)
+		if i%10 == 0 {
+			pid = testPID
+		}
+		ts[1], ts[2] = byte(pid>>8), byte(pid)
+		ts[3] = 0x30
+		ts[4] = 7
+		ts[5] = 0x10
+		pcr := (base + uint64(i/10)*9000) % pcrWrap
+		if pid != testPID {
+			pcr = 12345
+		}
+		ts[6], ts[7], ts[8], ts[9], ts[10] = byte(pcr>>25), byte(pcr>>17), byte(pcr>>9), byte(pcr>>1), byte(pcr<<7)
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
+
+func TestSeek(t *testing.T) {
+	for _, packetSize := range []int{188, 192} {
+		// The clock wraps early on.
+		data := testStream(20000, packetSize, pcrWrap-2*90000)
+		s, err := Open(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.packetSize != int64(packetSize) || s.pcrPID != testPID {
+			t.Fatal(s.packetSize, s.pcrPID)
+		}
+		d, err := s.Duration()
+		if err != nil || d != 1999*100*time.Millisecond {
+			t.Fatal(d, err)
+		}
+		for _, want := range []time.Duration{0, 50 * time.Second, 150500 * time.Millisecond, time.Hour} {
+			off, got, err := s.Seek(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got > want || got < want-8*time.Second && got < d-8*time.Second {
+				t.Errorf("%d: Seek(%v) gave %v", packetSize, want, got)
+			}
+			packet := (off - s.start) / int64(packetSize)
+			if packet%10 != 0 || time.Duration(packet/10)*100*time.Millisecond != got {
+				t.Errorf("%d: Seek(%v) gave offset %d for %v", packetSize, want, off, got)
+			}
+		}
+	}
+}
+
+func TestNotTS(t *testing.T) {
+	data := make([]byte, 4096)
+	if _, err := Open(bytes.NewReader(data), int64(len(data))); err != ErrNotTS {
+		t.Fatal(err)
+	}
+}
+
+// Returns a packet starting a section of the PID, CRC left zero.
+func sectionPacket(pid uint16, sec []byte) []byte {
+	p := bytes.Repeat([]byte{0xff}, 188)
+	p[0], p[1], p[2], p[3] = syncByte, 0x40|byte(pid>>8), byte(pid), 0x10
+	p[4] = 0
+	n := len(sec) + 4 - 3
+	sec[1], sec[2] = 0xb0|byte(n>>8), byte(n)
+	copy(p[5:], append(sec, 0, 0, 0, 0))
+	return p
+}
+
+func TestRandomAccess(t *testing.T) {
+	pat := sectionPacket(0, []byte{0x00, 0, 0, 0, 1, 0xc1, 0, 0, 0, 1, 0xe0 | 0x10, 0x00})
+	pmt := sectionPacket(0x1000, []byte{0x02, 0, 0, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0,
+		0x0f, 0xe2, 0x00, 0xf0, 0, // AAC on 0x200
+		0x1b, 0xe1, 0x00, 0xf0, 0, // H.264 on 0x100
+	})
+	data := testStream(1000, 188, 0)
+	// Keyframes on the video PID at packets 300 and 700, and a random access
+	// point of the audio at 505.
+	for _, i := range []int{300, 505, 700} {
+		data[i*188+5] |= 0x40
+	}
+	data = append(append(pat, pmt...), data...)
+	s, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := s.ProgramTables()
+	if err != nil || !bytes.Equal(tables, data[:2*188]) {
+		t.Fatal(len(tables), err)
+	}
+	for _, c := range []struct {
+		from, to int
+		want     int
+	}{{0, 1002, 302}, {303, 1002, 702}, {303, 600, -1}, {703, 1002, -1}} {
+		off, ok, err := s.RandomAccess(int64(c.from*188), int64(c.to*188))
+		if err != nil || ok != (c.want >= 0) || ok && off != int64(c.want*188) {
+			t.Errorf("%d-%d: %d, %v, %v", c.from, c.to, off, ok, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package nfo reads the metadata in Kodi's .nfo files for movies, TV shows
+// and episodes.
+package nfo
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of NFO, by their root elements.
+const (
+	Movie   = "movie"
+	TVShow  = "tvshow"
+	Episode = "episodedetails"
+)
+
+// Info is the metadata in an NFO file.
+type Info struct {
+	// One of Movie, TVShow and Episode.
+	Kind          string
+	Title         string
+	OriginalTitle string
+	// The show an episode is of.
+	ShowTitle string
+	Year      int
+	// When a movie or show premiered or an episode aired. Zero if unknown.
+	Premiered time.Time
+	Plot      string
+	Genres    []string
+	// The actors' names, in billing order.
+	Actors []string
+	// Out of 10, zero if unknown.
+	Rating  float64
+	Season  int
+	Episode int
+}
+
+// The elements read from any kind of NFO.
+type document struct {
+	XMLName       xml.Name
+	Title         string   `xml:"title"`
+	OriginalTitle string   `xml:"originaltitle"`
+	ShowTitle     string   `xml:"showtitle"`
+	Year          string   `xml:"year"`
+	Premiered     string   `xml:"premiered"`
+	Aired         string   `xml:"aired"`
+	Plot          string   `xml:"plot"`
+	Outline       string   `xml:"outline"`
+	Genres        []string `xml:"genre"`
+	Actors        []struct {
+		Name  string `xml:"name"`
+		Order *int   `xml:"order"`
+	} `xml:"actor"`
+	Rating  string `xml:"rating"`
+	Ratings []struct {
+		Default bool   `xml:"default,attr"`
+		Value   string `xml:"value"`
+	} `xml:"ratings>rating"`
+	Season  string `xml:"season"`
+	Episode string `xml:"episode"`
+}
+
+var errNotNFO = errors.New("not a movie, tvshow or episodedetails NFO")
+
+// Parse reads the first movie, tvshow or episodedetails element of an NFO.
+// Anything after it, like the scraper URL some NFOs end with, is ignored.
+func Parse(r io.Reader) (*Info, error) {
+	var doc document
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	switch doc.XMLName.Local {
+	case Movie, TVShow, Episode:
+	default:
+		return nil, errNotNFO
+	}
+	info := &Info{
+		Kind:          doc.XMLName.Local,
+		Title:         strings.TrimSpace(doc.Title),
+		OriginalTitle: strings.TrimSpace(doc.OriginalTitle),
+		ShowTitle:     strings.TrimSpace(doc.ShowTitle),
+		Plot:          strings.TrimSpace(doc.Plot),
+	}
+	if info.Plot == "" {
+		info.Plot = strings.TrimSpace(doc.Outline)
+	}
+	for _, date := range []string{doc.Premiered, doc.Aired} {
+		if t, err := time.Parse("2006-01-02", strings.TrimSpace(date)); err == nil {
+			info.Premiered = t
+			break
+		}
+	}
+	info.Year, _ = strconv.Atoi(strings.TrimSpace(doc.Year))
+	if info.Year == 0 && !info.Premiered.IsZero() {
+		info.Year = info.Premiered.Year()
+	}
+	for _, g := range doc.Genres {
+		// Some scrapers put all the genres in one element.
+		for _, g := range strings.Split(g, "/") {
+			if g = strings.TrimSpace(g); g != "" {
+				info.Genres = append(info.Genres, g)
+			}
+		}
+	}
+	// Actors without an order come after those with one, as they're listed.
+	actors := doc.Actors
+	sort.SliceStable(actors, func(i, j int) bool {
+		return actors[i].Order != nil && (actors[j].Order == nil || *actors[i].Order < *actors[j].Order)
+	})
+	for _, a := range actors {
+		if name := strings.TrimSpace(a.Name); name != "" {
+			info.Actors = append(info.Actors, name)
+		}
+	}
+	info.Rating, _ = strconv.ParseFloat(strings.TrimSpace(doc.Rating), 64)
+	for _, r := range doc.Ratings {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(r.Value), 64); err == nil && (info.Rating == 0 || r.Default) {
+			info.Rating = v
+		}
+	}
+	info.Season, _ = strconv.Atoi(strings.TrimSpace(doc.Season))
+	info.Episode, _ = strconv.Atoi(strings.TrimSpace(doc.Episode))
+	return info, nil
+}
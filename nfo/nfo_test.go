@@ -0,0 +1,65 @@
+package nfo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMovie(t *testing.T) {
+	info, err := Parse(strings.NewReader(`<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>
+<movie>
+  <title>流浪地球</title>
+  <originaltitle>The Wandering Earth</originaltitle>
+  <ratings>
+    <rating name="imdb" max="10"><value>5.9</value></rating>
+    <rating name="themoviedb" max="10" default="true"><value>6.4</value></rating>
+  </ratings>
+  <year>2019</year>
+  <plot>  The sun is dying.  </plot>
+  <genre>Science Fiction / Adventure</genre>
+  <genre>Drama</genre>
+  <premiered>2019-02-05</premiered>
+  <actor><name>Wu Jing</name><order>1</order></actor>
+  <actor><name>Extra</name></actor>
+  <actor><name>Qu Chuxiao</name><order>0</order></actor>
+</movie>
+https://www.themoviedb.org/movie/535167
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Info{
+		Kind:          Movie,
+		Title:         "流浪地球",
+		OriginalTitle: "The Wandering Earth",
+		Year:          2019,
+		Premiered:     time.Date(2019, 2, 5, 0, 0, 0, 0, time.UTC),
+		Plot:          "The sun is dying.",
+		Genres:        []string{"Science Fiction", "Adventure", "Drama"},
+		Actors:        []string{"Qu Chuxiao", "Wu Jing", "Extra"},
+		Rating:        6.4,
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("%+v", info)
+	}
+}
+
+func TestParseEpisode(t *testing.T) {
+	info, err := Parse(strings.NewReader(`<episodedetails><title>Pilot</title><showtitle>Show</showtitle>` +
+		`<season>1</season><episode>3</episode><aired>2020-01-02</aired><outline>Short.</outline><rating>8</rating></episodedetails>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Kind != Episode || info.ShowTitle != "Show" || info.Season != 1 || info.Episode != 3 ||
+		info.Year != 2020 || info.Plot != "Short." || info.Rating != 8 {
+		t.Fatalf("%+v", info)
+	}
+	if _, err := Parse(strings.NewReader(`<musicvideo><title>x</title></musicvideo>`)); err == nil {
+		t.Fatal("parsed musicvideo")
+	}
+	if _, err := Parse(strings.NewReader(`https://www.imdb.com/title/tt0000001/`)); err == nil {
+		t.Fatal("parsed URL")
+	}
+}
@@ -0,0 +1,221 @@
+// Package playlist reads M3U and PLS playlists, and .strm and .url files that
+// link to single streams, and writes M3U playlists.
+package playlist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a track of a playlist.
+type Entry struct {
+	// A URL, or a path that's relative to the playlist's directory unless
+	// it's absolute. Backslashes in paths are taken to be separators.
+	Location string
+	// Empty if the playlist doesn't give one.
+	Title string
+	// Zero if unknown.
+	Duration time.Duration
+}
+
+// Supported formats, named by their file extensions.
+const (
+	M3U  = "m3u"
+	M3U8 = "m3u8"
+	PLS  = "pls"
+)
+
+// Format returns the format of a playlist file by its name, or "" if it
+// isn't one.
+func Format(name string) string {
+	switch f := strings.ToLower(strings.TrimPrefix(path.Ext(name), ".")); f {
+	case M3U, M3U8, PLS:
+		return f
+	}
+	return ""
+}
+
+// Formats of files that each link to a single stream: Kodi's .strm, a URL
+// alone on a line, and Windows' .url internet shortcut.
+const (
+	STRM = "strm"
+	URL  = "url"
+)
+
+// StreamFormat returns the format of a file linking to a stream by its name,
+// or "" if it isn't one.
+func StreamFormat(name string) string {
+	switch f := strings.ToLower(strings.TrimPrefix(path.Ext(name), ".")); f {
+	case STRM, URL:
+		return f
+	}
+	return ""
+}
+
+var errNoURL = errors.New("no stream URL")
+
+// ParseStream returns the URL a .strm or .url file links to.
+func ParseStream(text string, format string) (string, error) {
+	text = strings.ReplaceAll(strings.TrimPrefix(text, "\uFEFF"), "\r\n", "\n")
+	inShortcut := false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch format {
+		case STRM:
+			if line != "" && !strings.HasPrefix(line, "#") && IsURL(line) {
+				return line, nil
+			}
+		case URL:
+			if strings.HasPrefix(line, "[") {
+				inShortcut = strings.EqualFold(line, "[InternetShortcut]")
+				continue
+			}
+			key, value, ok := strings.Cut(line, "=")
+			if value = strings.TrimSpace(value); ok && inShortcut && strings.EqualFold(strings.TrimSpace(key), "URL") && IsURL(value) {
+				return value, nil
+			}
+		default:
+			return "", fmt.Errorf("unsupported stream format %q", format)
+		}
+	}
+	return "", errNoURL
+}
+
+// IsURL reports whether the location of an entry is a URL, rather than a
+// path.
+func IsURL(location string) bool {
+	scheme, _, ok := strings.Cut(location, "://")
+	// Longer than a drive letter.
+	return ok && len(scheme) > 1 && !strings.ContainsAny(scheme, `/\`)
+}
+
+// ErrHLS is returned when an M3U is an HLS stream's playlist of segments or
+// variants, rather than of tracks.
+var ErrHLS = errors.New("an HLS playlist")
+
+// Parse reads the entries of a playlist from UTF-8 text in the given format.
+func Parse(text string, format string) ([]Entry, error) {
+	text = strings.ReplaceAll(strings.TrimPrefix(text, "\uFEFF"), "\r\n", "\n")
+	switch format {
+	case M3U, M3U8:
+		return parseM3U(text)
+	case PLS:
+		return parsePLS(text)
+	}
+	return nil, fmt.Errorf("unsupported playlist format %q", format)
+}
+
+// Returns a duration of seconds, zero if it's negative, which M3Us use for
+// unknown.
+func seconds(s string) time.Duration {
+	secs, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || secs <= 0 || math.IsInf(secs, 0) {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+func parseM3U(text string) ([]Entry, error) {
+	var entries []Entry
+	var next Entry
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION"), strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			return nil, ErrHLS
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// Like "#EXTINF:123,Title", maybe with attributes after the
+			// duration, like `#EXTINF:-1 tvg-id="x, y",Title`.
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			quoted := false
+			for i, c := range info {
+				if c == '"' {
+					quoted = !quoted
+				} else if c == ',' && !quoted {
+					next.Title = strings.TrimSpace(info[i+1:])
+					info = info[:i]
+					break
+				}
+			}
+			dur, _, _ := strings.Cut(info, " ")
+			next.Duration = seconds(dur)
+		case line == "", strings.HasPrefix(line, "#"):
+		default:
+			next.Location = line
+			entries = append(entries, next)
+			next = Entry{}
+		}
+	}
+	return entries, nil
+}
+
+func parsePLS(text string) ([]Entry, error) {
+	byNum := make(map[int]*Entry)
+	for _, line := range strings.Split(text, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var field string
+		for _, f := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, f) {
+				field = f
+				break
+			}
+		}
+		num, err := strconv.Atoi(strings.TrimPrefix(key, field))
+		if field == "" || err != nil {
+			continue
+		}
+		e := byNum[num]
+		if e == nil {
+			e = new(Entry)
+			byNum[num] = e
+		}
+		value = strings.TrimSpace(value)
+		switch field {
+		case "file":
+			e.Location = value
+		case "title":
+			e.Title = value
+		case "length":
+			e.Duration = seconds(value)
+		}
+	}
+	nums := make([]int, 0, len(byNum))
+	for num, e := range byNum {
+		if e.Location != "" {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+	entries := make([]Entry, 0, len(nums))
+	for _, num := range nums {
+		entries = append(entries, *byNum[num])
+	}
+	return entries, nil
+}
+
+// WriteM3U formats entries as an extended M3U.
+func WriteM3U(entries []Entry) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	for _, e := range entries {
+		secs := int64(-1)
+		if e.Duration > 0 {
+			secs = int64(math.Round(e.Duration.Seconds()))
+		}
+		// Line breaks in titles would end the line early.
+		title := strings.Join(strings.Fields(e.Title), " ")
+		fmt.Fprintf(&buf, "#EXTINF:%d,%s\n%s\n", secs, title, e.Location)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,74 @@
+package playlist
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseM3U(t *testing.T) {
+	entries, err := Parse("\uFEFF#EXTM3U\r\n#EXTINF:215,Artist - Song\r\nSongs\\song.mp3\r\n\r\n# a comment\r\n../other.flac\r\n"+
+		`#EXTINF:-1 tvg-id="a, b" group-title="News",Live`+"\nhttp://example.com/live.ts\n", M3U)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{`Songs\song.mp3`, "Artist - Song", 215 * time.Second},
+		{"../other.flac", "", 0},
+		{"http://example.com/live.ts", "Live", 0},
+	}
+	if len(entries) != len(want) {
+		t.Fatal(entries)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("%d: %+v", i, entries[i])
+		}
+	}
+	if _, err := Parse("#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10,\nseg0.ts\n", M3U8); err != ErrHLS {
+		t.Fatal(err)
+	}
+}
+
+func TestParsePLS(t *testing.T) {
+	entries, err := Parse("[playlist]\nFile2=b.mp3\nTitle2=B\nFile1=/Music/a.mp3\nLength1=61.5\nNumberOfEntries=2\nVersion=2\n", PLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0] != (Entry{"/Music/a.mp3", "", 61500 * time.Millisecond}) || entries[1] != (Entry{"b.mp3", "B", 0}) {
+		t.Fatal(entries)
+	}
+}
+
+func TestWriteM3U(t *testing.T) {
+	got := string(WriteM3U([]Entry{{"http://h/a.mp3", "A\nB", 90 * time.Second}, {"b.mp3", "", 0}}))
+	if got != "#EXTM3U\n#EXTINF:90,A B\nhttp://h/a.mp3\n#EXTINF:-1,\nb.mp3\n" {
+		t.Fatal(got)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if Format("a.M3U8") != M3U8 || Format("a.pls") != PLS || Format("a.mp3") != "" {
+		t.Fatal()
+	}
+	if !IsURL("https://example.com/a.mp3") || IsURL("C:\\Music\\a.mp3") || IsURL("a.mp3") {
+		t.Fatal()
+	}
+}
+
+func TestParseStream(t *testing.T) {
+	for _, tc := range []struct {
+		text, format, url string
+	}{
+		{"\uFEFF# IPTV\r\nhttps://example.com/live/index.m3u8\r\n", STRM, "https://example.com/live/index.m3u8"},
+		{"[{000214A0-0000-0000-C000-000000000046}]\nURL=x\n[InternetShortcut]\nIDList=\nURL=http://radio.example.com:8000/stream\n", URL, "http://radio.example.com:8000/stream"},
+		{"not a url\n", STRM, ""},
+	} {
+		url, err := ParseStream(tc.text, tc.format)
+		if url != tc.url || (err == nil) != (tc.url != "") {
+			t.Errorf("%q: %q, %v", tc.text, url, err)
+		}
+	}
+	if StreamFormat("Radio.URL") != URL || StreamFormat("a.strm") != STRM || StreamFormat("a.m3u") != "" {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,170 @@
+// Package series recognizes episodes of TV series by their file paths, in the
+// naming schemes of English and Chinese releases.
+package series
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofly/alipan-dms/title"
+)
+
+// Episode is what a file's path says of the episode in it.
+type Episode struct {
+	Show    string
+	Season  int
+	Episode int
+	// The episode's own title, if the name has one.
+	Title string
+}
+
+const chineseDigits = "零〇一二两三四五六七八九十百"
+
+var (
+	// Names with both the season and the episode, like "Show.S01E03" and
+	// "Show 1x03".
+	seasonEpisodeRE = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,2})[ ._-]?e(\d{1,3})(?:[^0-9]|$)`),
+		regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(\d{1,2})x(\d{2,3})(?:[^0-9]|$)`),
+	}
+	// Names with just the episode, like "第03集", "EP03" and "[Group] Show - 03".
+	episodeRE = []*regexp.Regexp{
+		regexp.MustCompile(`第\s*([0-9` + chineseDigits + `]+)\s*[集话話回期]`),
+		regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:ep?|episode)[ ._-]?(\d{1,3})(?:[^0-9]|$)`),
+		regexp.MustCompile(`\s-\s(\d{1,3})(?:v\d)?(?:[^0-9]|$)`),
+	}
+	// A season within a name, like "S02", "Season 2" or "第二季".
+	seasonRE = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:s|season|series)[ ._-]?(\d{1,2})(?:[^0-9]|$)|第\s*([0-9` + chineseDigits + `]+)\s*季`)
+	// A directory that's only a season.
+	seasonDirRE = regexp.MustCompile(`(?i)^(?:(?:s|season|series)[ ._-]*(\d{1,2})|第\s*([0-9` + chineseDigits + `]+)\s*季)$`)
+	// Names made only of an episode number, like "03" and "[03]", which are
+	// episodes only in season directories.
+	numberRE = regexp.MustCompile(`^[\[(【]?(\d{1,3})[\])】]?$`)
+)
+
+// Parse returns the episode a file is, judging by its name and the names of
+// the directories it's in. The show is named by the file, or else by the
+// nearest directory that isn't a season, and the season can be given by
+// either. Episodes that don't give a season are in season 1.
+func Parse(filePath string) (ep Episode, ok bool) {
+	name := path.Base(filePath)
+	name = strings.TrimSuffix(name, path.Ext(name))
+	dir := path.Dir(filePath)
+	before, after := "", ""
+	for _, re := range seasonEpisodeRE {
+		if m := re.FindStringSubmatchIndex(name); m != nil {
+			ep.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+			ep.Episode, _ = strconv.Atoi(name[m[4]:m[5]])
+			before, after = name[:m[0]], name[m[5]:]
+			ok = true
+			break
+		}
+	}
+	for _, re := range episodeRE {
+		if ok {
+			break
+		}
+		if m := re.FindStringSubmatchIndex(name); m != nil {
+			ep.Episode = number(name[m[2]:m[3]])
+			before, after = name[:m[0]], name[m[1]:]
+			ok = true
+		}
+	}
+	dirSeason := 0
+	if m := seasonDirRE.FindStringSubmatch(path.Base(dir)); m != nil {
+		dirSeason = number(m[1] + m[2])
+		dir = path.Dir(dir)
+	}
+	if !ok && dirSeason != 0 {
+		if m := numberRE.FindStringSubmatch(strings.TrimSpace(name)); m != nil {
+			ep.Episode, _ = strconv.Atoi(m[1])
+			ok = true
+		}
+	}
+	if !ok || ep.Episode == 0 {
+		return Episode{}, false
+	}
+	var season int
+	ep.Show, season = showName(before)
+	if (ep.Show == "" || season == 0) && dir != "/" && dir != "." {
+		dirShow, dirShowSeason := showName(path.Base(dir))
+		if ep.Show == "" {
+			ep.Show = dirShow
+		}
+		if season == 0 {
+			season = dirShowSeason
+		}
+	}
+	if ep.Show == "" {
+		return Episode{}, false
+	}
+	if ep.Season == 0 {
+		ep.Season = season
+	}
+	if ep.Season == 0 {
+		ep.Season = dirSeason
+	}
+	if ep.Season == 0 {
+		ep.Season = 1
+	}
+	ep.Title = tidy(after)
+	return ep, true
+}
+
+// Returns a show's name from the start of a name, and the season if it gives
+// one.
+func showName(s string) (show string, season int) {
+	if m := seasonRE.FindStringSubmatchIndex(s); m != nil {
+		if m[2] >= 0 {
+			season, _ = strconv.Atoi(s[m[2]:m[3]])
+		} else {
+			season = number(s[m[4]:m[5]])
+		}
+		s = s[:m[0]]
+	}
+	show = tidy(s)
+	return
+}
+
+// Removes release details, bracketed tags and a trailing year, and separates
+// words with spaces. Unlike a file's title, what's left can be nothing.
+func tidy(s string) string {
+	for _, r := range title.DefaultRules {
+		s = r.Pattern.ReplaceAllString(s, r.Replacement)
+	}
+	s, _ = title.Clean(s, nil)
+	s, _ = title.TrimYear(s)
+	return strings.Trim(s, " -–:[]()【】")
+}
+
+// Parses a number in Arabic or Chinese numerals, like "12" or "十二".
+func number(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	n, digit := 0, 0
+	for _, r := range s {
+		switch r {
+		case '十', '百':
+			unit := 10
+			if r == '百' {
+				unit = 100
+			}
+			if digit == 0 {
+				digit = 1
+			}
+			n += digit * unit
+			digit = 0
+		default:
+			digit = strings.IndexRune("零一二三四五六七八九", r) / len("零")
+			if r == '〇' {
+				digit = 0
+			} else if r == '两' {
+				digit = 2
+			}
+		}
+	}
+	return n + digit
+}
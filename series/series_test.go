@@ -0,0 +1,46 @@
+package series
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want Episode
+	}{
+		{"/TV/Show.Name.S01E03.1080p.WEB-DL.x264-GRP.mkv", Episode{"Show Name", 1, 3, ""}},
+		{"/TV/Show.Name.2019.s02e10.The.Long.Night.720p.mkv", Episode{"Show Name", 2, 10, "The Long Night"}},
+		{"/TV/Show Name 3x07.avi", Episode{"Show Name", 3, 7, ""}},
+		{"/Anime/[Group] Some Anime - 05 [1080p].mkv", Episode{"Some Anime", 1, 5, ""}},
+		{"/电视剧/长安十二时辰/第03集.mp4", Episode{"长安十二时辰", 1, 3, ""}},
+		{"/电视剧/庆余年 第二季/庆余年2 第十二集.mp4", Episode{"庆余年2", 2, 12, ""}},
+		{"/电视剧/庆余年/第二季/第十二集.mp4", Episode{"庆余年", 2, 12, ""}},
+		{"/电视剧/[字幕组]三体.EP05.2160p.mp4", Episode{"三体", 1, 5, ""}},
+		{"/TV/Show.Name.S03.1080p/Show.Name.E04.mkv", Episode{"Show Name", 3, 4, ""}},
+		{"/TV/Show.Name.S03.1080p/E04.mkv", Episode{"Show Name", 3, 4, ""}},
+		{"/TV/Show Name/Season 2/05.mkv", Episode{"Show Name", 2, 5, ""}},
+	} {
+		ep, ok := Parse(tc.path)
+		if !ok || ep != tc.want {
+			t.Errorf("%s: %+v, %v", tc.path, ep, ok)
+		}
+	}
+	for _, p := range []string{
+		"/Movies/Blade.Runner.2049.2017.1080p.BluRay.x264.mkv",
+		"/Movies/Apollo 13 - 1995.mkv",
+		"/Movies/1920x1080 sample.mp4",
+		"/Videos/05.mp4",
+		"/S01E01.mkv",
+	} {
+		if ep, ok := Parse(p); ok {
+			t.Errorf("%s: %+v", p, ep)
+		}
+	}
+}
+
+func TestNumber(t *testing.T) {
+	for s, want := range map[string]int{"12": 12, "三": 3, "十": 10, "十二": 12, "二十": 20, "二十三": 23, "一百零五": 105, "两": 2} {
+		if n := number(s); n != want {
+			t.Errorf("%s: %d", s, n)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package subtitle
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The field order used when an [Events] section has no Format line.
+var defaultASSFormat = []string{"layer", "start", "end", "style", "name", "marginl", "marginr", "marginv", "effect", "text"}
+
+// Parses Advanced SubStation Alpha and SubStation Alpha dialogue events.
+func parseASS(text string) (cues []Cue, err error) {
+	inEvents := false
+	format := defaultASSFormat
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Format":
+			format = nil
+			for _, f := range strings.Split(value, ",") {
+				format = append(format, strings.ToLower(strings.TrimSpace(f)))
+			}
+		case "Dialogue":
+			fields := strings.SplitN(strings.TrimSpace(value), ",", len(format))
+			if len(fields) != len(format) {
+				continue
+			}
+			var c Cue
+			for i, name := range format {
+				switch name {
+				case "start":
+					c.Start, err = parseTimestamp(fields[i])
+				case "end":
+					c.End, err = parseTimestamp(fields[i])
+				case "text":
+					c.Text = assText(fields[i])
+				}
+				if err != nil {
+					return
+				}
+			}
+			if c.Text != "" {
+				cues = append(cues, c)
+			}
+		}
+	}
+	sortCues(cues)
+	return cues, nil
+}
+
+var assOverrideRegexp = regexp.MustCompile(`\{[^}]*\}`)
+
+var assUnescaper = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ")
+
+func assText(s string) string {
+	return stripTags(assUnescaper.Replace(assOverrideRegexp.ReplaceAllString(s, "")))
+}
+
+// Events are often ordered by layer or style rather than time.
+func sortCues(cues []Cue) {
+	for i := 1; i < len(cues); i++ {
+		for j := i; j > 0 && cues[j].Start < cues[j-1].Start; j-- {
+			cues[j], cues[j-1] = cues[j-1], cues[j]
+		}
+	}
+}
+
+func writeASS(buf *bytes.Buffer, cues []Cue, ssa bool) {
+	buf.WriteString("[Script Info]\nScriptType: ")
+	if ssa {
+		buf.WriteString("v4.00\n\n[V4 Styles]\n")
+		buf.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, TertiaryColour, BackColour, Bold, Italic, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, AlphaLevel, Encoding\n")
+		buf.WriteString("Style: Default,Arial,20,16777215,65535,65535,0,0,0,1,2,2,2,10,10,10,0,1\n\n")
+		buf.WriteString("[Events]\nFormat: Marked, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+	} else {
+		buf.WriteString("v4.00+\n\n[V4+ Styles]\n")
+		buf.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+		buf.WriteString("Style: Default,Arial,20,&H00FFFFFF,&H0000FFFF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,2,2,10,10,10,1\n\n")
+		buf.WriteString("[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+	}
+	marked := "0"
+	if ssa {
+		marked = "Marked=0"
+	}
+	for _, c := range cues {
+		fmt.Fprintf(buf, "Dialogue: %s,%s,%s,Default,,0,0,0,,%s\n", marked,
+			formatTimestamp(c.Start, ".", 2), formatTimestamp(c.End, ".", 2),
+			strings.ReplaceAll(c.Text, "\n", `\N`))
+	}
+}
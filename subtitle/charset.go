@@ -0,0 +1,114 @@
+package subtitle
+
+import (
+	"bytes"
+	_ "embed"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Legacy encodings tried, in order, for text that isn't UTF-8. Subtitles for
+// Chinese releases are by far the most common case.
+var legacyEncodings = []struct {
+	name string
+	enc  encoding.Encoding
+}{
+	{"gb18030", simplifiedchinese.GB18030},
+	{"big5", traditionalchinese.Big5},
+	{"shift_jis", japanese.ShiftJIS},
+	{"euc-kr", korean.EUCKR},
+}
+
+// Characters common in simplified and traditional Chinese text, by frequency,
+// in lines of 50. Decoding text with the wrong one of GB18030 and Big5 rarely
+// fails outright, but gives mostly rare characters.
+var (
+	//go:embed common_simplified.txt
+	commonSimplified string
+	//go:embed common_traditional.txt
+	commonTraditional string
+)
+
+var commonChars = func() map[rune]bool {
+	m := make(map[rune]bool)
+	for _, r := range commonSimplified + commonTraditional {
+		if r != '\n' {
+			m[r] = true
+		}
+	}
+	return m
+}()
+
+// DecodeText returns subtitle data as UTF-8, along with the name of the
+// encoding it was found to be in. Byte order marks and valid UTF-8 are
+// trusted; otherwise the legacy encoding giving the most common characters
+// wins.
+func DecodeText(data []byte) (string, string) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xef, 0xbb, 0xbf}):
+		return string(data[3:]), "utf-8"
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		return decodeWith(unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM), data), "utf-16le"
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		return decodeWith(unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM), data), "utf-16be"
+	case utf8.Valid(data):
+		return string(data), "utf-8"
+	}
+	best, bestName, bestScore := "", "", -1
+	for _, le := range legacyEncodings {
+		text := decodeWith(le.enc, data)
+		if score := scoreText(text); score > bestScore {
+			best, bestName, bestScore = text, le.name, score
+		}
+	}
+	if bestScore <= 0 {
+		return decodeWith(charmap.Windows1252, data), "windows-1252"
+	}
+	return best, bestName
+}
+
+// DecodeTextAs decodes data from the named encoding, falling back to
+// detection if the name isn't known.
+func DecodeTextAs(data []byte, charset string) (string, string) {
+	charset = strings.ToLower(charset)
+	for _, le := range legacyEncodings {
+		if le.name == charset {
+			return decodeWith(le.enc, data), le.name
+		}
+	}
+	return DecodeText(data)
+}
+
+func decodeWith(enc encoding.Encoding, data []byte) string {
+	b, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(b)
+}
+
+// Scores decoded text by how many common characters it has, penalising
+// replacement characters from invalid sequences.
+func scoreText(text string) (score int) {
+	for _, r := range text {
+		switch {
+		case r == utf8.RuneError:
+			score -= 10
+		case r < utf8.RuneSelf:
+		case commonChars[r]:
+			score += 2
+		case r >= 0x3040 && r <= 0x30ff, r >= 0xac00 && r <= 0xd7a3:
+			// Kana and Hangul syllables.
+			score++
+		}
+	}
+	return
+}
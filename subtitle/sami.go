@@ -0,0 +1,68 @@
+package subtitle
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	samiSyncRegexp = regexp.MustCompile(`(?i)<sync\s+start\s*=\s*["']?(\d+)[^>]*>`)
+	samiBRRegexp   = regexp.MustCompile(`(?i)<br\s*/?>`)
+	samiBodyRegexp = regexp.MustCompile(`(?is)</?body[^>]*>|</sami>`)
+)
+
+// Parses SAMI. Each SYNC lasts until the next one; a SYNC with only
+// whitespace clears the screen. Multiple language classes are merged.
+func parseSAMI(text string) (cues []Cue, err error) {
+	locs := samiSyncRegexp.FindAllStringSubmatchIndex(text, -1)
+	if locs == nil {
+		return nil, fmt.Errorf("no SYNC elements")
+	}
+	for i, loc := range locs {
+		ms, err := strconv.ParseInt(text[loc[2]:loc[3]], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		end := len(text)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		start := time.Duration(ms) * time.Millisecond
+		if n := len(cues); n != 0 && cues[n-1].End == 0 {
+			cues[n-1].End = start
+		}
+		body := samiBodyRegexp.ReplaceAllString(text[loc[1]:end], "")
+		body = samiBRRegexp.ReplaceAllString(strings.ReplaceAll(body, "\n", ""), "\n")
+		body = strings.ReplaceAll(html.UnescapeString(stripTags(body)), "\u00a0", " ")
+		if body = strings.TrimSpace(body); body != "" {
+			cues = append(cues, Cue{Start: start, Text: body})
+		}
+	}
+	if n := len(cues); n != 0 && cues[n-1].End == 0 {
+		cues[n-1].End = cues[n-1].Start + 5*time.Second
+	}
+	return cues, nil
+}
+
+func writeSAMI(buf *bytes.Buffer, cues []Cue) {
+	buf.WriteString("<SAMI>\r\n<HEAD>\r\n<STYLE TYPE=\"text/css\">\r\n<!--\r\n")
+	buf.WriteString("P { margin-left:8pt; margin-right:8pt; margin-bottom:2pt; margin-top:2pt; text-align:center; font-size:20pt; font-family:Arial; color:white; }\r\n")
+	// The class claims no language, as the cues' isn't known.
+	buf.WriteString(".SUBTTL { Name:Subtitles; SAMIType:CC; }\r\n-->\r\n</STYLE>\r\n</HEAD>\r\n<BODY>\r\n")
+	for i, c := range cues {
+		lines := strings.Split(c.Text, "\n")
+		for j := range lines {
+			lines[j] = html.EscapeString(lines[j])
+		}
+		fmt.Fprintf(buf, "<SYNC Start=%d><P Class=SUBTTL>%s</P></SYNC>\r\n", c.Start/time.Millisecond, strings.Join(lines, "<br>"))
+		if i+1 == len(cues) || cues[i+1].Start > c.End {
+			fmt.Fprintf(buf, "<SYNC Start=%d><P Class=SUBTTL>&nbsp;</P></SYNC>\r\n", c.End/time.Millisecond)
+		}
+	}
+	buf.WriteString("</BODY>\r\n</SAMI>\r\n")
+}
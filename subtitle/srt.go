@@ -0,0 +1,41 @@
+package subtitle
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Parses SubRip. Cue numbers are optional, since plenty of files in the wild
+// have them wrong or missing.
+func parseSRT(text string) (cues []Cue, err error) {
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for i, line := range lines {
+			start, end, ok := strings.Cut(line, "-->")
+			if !ok {
+				continue
+			}
+			var c Cue
+			if c.Start, err = parseTimestamp(start); err != nil {
+				return
+			}
+			// Drop any position coordinates after the end time.
+			if c.End, err = parseTimestamp(strings.Fields(end + " x")[0]); err != nil {
+				return
+			}
+			c.Text = stripTags(strings.Join(lines[i+1:], "\n"))
+			cues = append(cues, c)
+			break
+		}
+	}
+	return cues, nil
+}
+
+func writeSRT(buf *bytes.Buffer, cues []Cue) {
+	for i, c := range cues {
+		fmt.Fprintf(buf, "%d\r\n%s --> %s\r\n%s\r\n\r\n", i+1,
+			formatTimestamp(c.Start, ",", 3), formatTimestamp(c.End, ",", 3),
+			strings.ReplaceAll(c.Text, "\n", "\r\n"))
+	}
+}
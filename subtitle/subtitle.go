@@ -0,0 +1,147 @@
+// Package subtitle converts between subtitle formats through a common cue
+// model.
+package subtitle
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Cue is a timed piece of subtitle text. Text is plain, with lines separated
+// by "\n"; styling is dropped when parsing.
+type Cue struct {
+	Start, End time.Duration
+	Text       string
+}
+
+// Supported formats, named by their usual file extensions.
+const (
+	SRT  = "srt"
+	ASS  = "ass"
+	SSA  = "ssa"
+	VTT  = "vtt"
+	SAMI = "smi"
+)
+
+// Formats lists the supported formats.
+var Formats = []string{SRT, ASS, SSA, VTT, SAMI}
+
+// Supported reports whether format can be parsed and written.
+func Supported(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse reads cues from UTF-8 text in the given format.
+func Parse(text string, format string) ([]Cue, error) {
+	text = normalizeNewlines(strings.TrimPrefix(text, "\uFEFF"))
+	switch format {
+	case SRT:
+		return parseSRT(text)
+	case ASS, SSA:
+		return parseASS(text)
+	case VTT:
+		return parseVTT(text)
+	case SAMI:
+		return parseSAMI(text)
+	}
+	return nil, fmt.Errorf("unsupported subtitle format %q", format)
+}
+
+// Write formats cues.
+func Write(cues []Cue, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case SRT:
+		writeSRT(&buf, cues)
+	case ASS, SSA:
+		writeASS(&buf, cues, format == SSA)
+	case VTT:
+		writeVTT(&buf, cues)
+	case SAMI:
+		writeSAMI(&buf, cues)
+	default:
+		return nil, fmt.Errorf("unsupported subtitle format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// Convert returns UTF-8 subtitle text in the target format. Text that is
+// already in the target format is returned as is, to keep any styling.
+func Convert(text string, from, to string) ([]byte, error) {
+	if from == to {
+		return []byte(text), nil
+	}
+	cues, err := Parse(text, from)
+	if err != nil {
+		return nil, err
+	}
+	return Write(cues, to)
+}
+
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+}
+
+var tagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// Removes markup tags, and blank lines left behind.
+func stripTags(s string) string {
+	s = tagRegexp.ReplaceAllString(s, "")
+	lines := strings.Split(s, "\n")
+	kept := lines[:0]
+	for _, l := range lines {
+		if l = strings.TrimSpace(l); l != "" {
+			kept = append(kept, l)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// Formats d as hours, minutes, seconds and a fraction with the given
+// separator and number of digits.
+func formatTimestamp(d time.Duration, sep string, digits int) string {
+	if d < 0 {
+		d = 0
+	}
+	h := d / time.Hour
+	m := d % time.Hour / time.Minute
+	s := d % time.Minute / time.Second
+	frac := d % time.Second
+	switch digits {
+	case 2:
+		return fmt.Sprintf("%d:%02d:%02d%s%02d", h, m, s, sep, frac/(10*time.Millisecond))
+	default:
+		return fmt.Sprintf("%02d:%02d:%02d%s%03d", h, m, s, sep, frac/time.Millisecond)
+	}
+}
+
+var timestampRegexp = regexp.MustCompile(`^(?:(\d+):)?(\d{1,2}):(\d{1,2})(?:[.,](\d{1,3}))?$`)
+
+// Parses "[h:]mm:ss[.fff]" with either separator and any fraction length.
+func parseTimestamp(s string) (time.Duration, error) {
+	m := timestampRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("bad timestamp %q", s)
+	}
+	var h, min, sec, frac int
+	fmt.Sscan(m[1]+" ", &h)
+	fmt.Sscan(m[2], &min)
+	fmt.Sscan(m[3], &sec)
+	fracDigits := m[4]
+	if fracDigits != "" {
+		fmt.Sscan(fracDigits, &frac)
+		for i := len(fracDigits); i < 3; i++ {
+			frac *= 10
+		}
+	}
+	return time.Duration(h)*time.Hour + time.Duration(min)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(frac)*time.Millisecond, nil
+}
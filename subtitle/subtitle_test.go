@@ -0,0 +1,105 @@
+package subtitle
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+func ms(n int) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
+
+var wantCues = []Cue{
+	{ms(1000), ms(3500), "Hello\nworld"},
+	{ms(4000), ms(6250), "Second & last"},
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		text   string
+	}{
+		{SRT, "\uFEFF1\r\n00:00:01,000 --> 00:00:03,500\r\n<i>Hello</i>\r\nworld\r\n\r\n2\r\n00:00:04,000 --> 00:00:06,250 X1:0\r\nSecond & last\r\n"},
+		{VTT, "WEBVTT\n\nNOTE a comment\n\nintro\n00:01.000 --> 00:03.500 align:start\n<v Bob>Hello</v>\nworld\n\n00:00:04.000 --> 00:00:06.250\nSecond &amp; last\n"},
+		{ASS, "[Script Info]\nTitle: x\n\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+			"Dialogue: 0,0:00:04.00,0:00:06.25,Default,,0,0,0,,Second & last\n" +
+			"Dialogue: 0,0:00:01.00,0:00:03.50,Default,,0,0,0,,{\\i1}Hello{\\i0}\\Nworld\n"},
+		{SAMI, "<SAMI><BODY>\n<SYNC Start=1000><P Class=KRCC>Hello<br>world\n<SYNC Start=3500><P Class=KRCC>&nbsp;\n<SYNC Start=4000><P Class=KRCC>Second &amp; last\n<SYNC Start=6250><P Class=KRCC>&nbsp;\n</BODY></SAMI>"},
+	} {
+		cues, err := Parse(tc.text, tc.format)
+		if err != nil {
+			t.Errorf("%s: %v", tc.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(cues, wantCues) {
+			t.Errorf("%s: got %q", tc.format, cues)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range Formats {
+		data, err := Write(wantCues, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cues, err := Parse(string(data), format)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if !reflect.DeepEqual(cues, wantCues) {
+			t.Errorf("%s: got %q from %q", format, cues, data)
+		}
+		if format == SAMI && strings.Contains(string(data), "lang:") {
+			t.Errorf("sami claims a language: %q", data)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	srt := "1\n00:00:01,000 --> 00:00:03,500\nHello\n"
+	got, err := Convert(srt, SRT, VTT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "WEBVTT\n\n00:00:01.000 --> 00:00:03.500\nHello\n\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, _ := Convert(srt, SRT, SRT); string(got) != srt {
+		t.Errorf("same format changed: %q", got)
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	const simplified = "我们现在就去看电影吧，这个故事很有意思。"
+	const traditional = "我們現在就去看電影吧，這個故事很有意思。"
+	gb, _ := simplifiedchinese.GB18030.NewEncoder().String(simplified)
+	big5, _ := traditionalchinese.Big5.NewEncoder().String(traditional)
+	for _, tc := range []struct {
+		data     []byte
+		text     string
+		encoding string
+	}{
+		{[]byte(simplified), simplified, "utf-8"},
+		{append([]byte{0xef, 0xbb, 0xbf}, simplified...), simplified, "utf-8"},
+		{[]byte{0xff, 0xfe, 'h', 0, 'i', 0}, "hi", "utf-16le"},
+		{[]byte{0xfe, 0xff, 0, 'h', 0, 'i'}, "hi", "utf-16be"},
+		{[]byte(gb), simplified, "gb18030"},
+		{[]byte(big5), traditional, "big5"},
+		{[]byte("caf\xe9"), "café", "windows-1252"},
+	} {
+		text, encoding := DecodeText(tc.data)
+		if text != tc.text || encoding != tc.encoding {
+			t.Errorf("DecodeText(%q) = %q, %q; want %q, %q", tc.data, text, encoding, tc.text, tc.encoding)
+		}
+	}
+	if text, _ := DecodeTextAs([]byte(big5), "BIG5"); !strings.HasPrefix(text, "我們") {
+		t.Errorf("DecodeTextAs: %q", text)
+	}
+}
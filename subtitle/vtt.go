@@ -0,0 +1,54 @@
+package subtitle
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Parses WebVTT. Cue settings, notes, styles and regions are ignored.
+func parseVTT(text string) (cues []Cue, err error) {
+	if !strings.HasPrefix(text, "WEBVTT") {
+		return nil, fmt.Errorf("missing WEBVTT header")
+	}
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if strings.HasPrefix(lines[0], "NOTE") || strings.HasPrefix(lines[0], "STYLE") {
+			continue
+		}
+		for i, line := range lines {
+			start, rest, ok := strings.Cut(line, "-->")
+			if !ok {
+				continue
+			}
+			var c Cue
+			if c.Start, err = parseTimestamp(start); err != nil {
+				return
+			}
+			if c.End, err = parseTimestamp(strings.Fields(rest + " x")[0]); err != nil {
+				return
+			}
+			c.Text = unescapeVTT(stripTags(strings.Join(lines[i+1:], "\n")))
+			cues = append(cues, c)
+			break
+		}
+	}
+	return cues, nil
+}
+
+var vttUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&nbsp;", " ", "&amp;", "&")
+
+func unescapeVTT(s string) string {
+	return vttUnescaper.Replace(s)
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func writeVTT(buf *bytes.Buffer, cues []Cue) {
+	buf.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		fmt.Fprintf(buf, "%s --> %s\n%s\n\n",
+			formatTimestamp(c.Start, ".", 3), formatTimestamp(c.End, ".", 3),
+			vttEscaper.Replace(c.Text))
+	}
+}
@@ -0,0 +1,84 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	for _, c := range []struct {
+		w, h, maxW, maxH, ew, eh int
+	}{
+		{1600, 1200, 160, 160, 160, 120},
+		{1200, 1600, 160, 160, 120, 160},
+		{1920, 1080, 640, 480, 640, 360},
+		{100, 50, 160, 160, 100, 50},
+		{5000, 10, 160, 160, 160, 1},
+	} {
+		b := Fit(image.NewRGBA(image.Rect(0, 0, c.w, c.h)), c.maxW, c.maxH).Bounds()
+		if b.Dx() != c.ew || b.Dy() != c.eh {
+			t.Errorf("%dx%d in %dx%d: got %v", c.w, c.h, c.maxW, c.maxH, b)
+		}
+	}
+}
+
+func TestFitAverages(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	img.SetGray(0, 0, color.Gray{200})
+	img.SetGray(1, 1, color.Gray{200})
+	got := Fit(img, 2, 1).RGBAAt(0, 0)
+	if got.R != 100 || got.A != 0xff {
+		t.Fatal(got)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.White)
+	for _, c := range []struct {
+		orientation int
+		x, y        int
+	}{
+		{1, 0, 0},
+		{3, 2, 1},
+		{6, 1, 0},
+		{8, 0, 2},
+	} {
+		got := Orient(img, c.orientation)
+		if got.RGBAAt(c.x, c.y) != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+			t.Errorf("orientation %d: white pixel not at %d,%d", c.orientation, c.x, c.y)
+		}
+		if c.orientation >= 5 && got.Rect.Dx() != 2 {
+			t.Errorf("orientation %d: %v", c.orientation, got.Rect)
+		}
+	}
+}
+
+func TestMakeFromPNG(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 320, 320)))
+	data, err := Make(&buf, 160, 160)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "jpeg" || cfg.Width != 160 {
+		t.Fatal(cfg, format, err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := Cache{Dir: t.TempDir()}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("unexpected hit")
+	}
+	if err := c.Put("a", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := c.Get("a"); !ok || string(data) != "data" {
+		t.Fatal(data, ok)
+	}
+}
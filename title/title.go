@@ -0,0 +1,96 @@
+// Package title makes titles fit to show on a TV of release file names, like
+// "[字幕组] Movie.Name.2019.BluRay.x264-GRP".
+package title
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rule replaces the matches of Pattern with Replacement, which can refer to
+// submatches as in regexp.Regexp.ReplaceAllString.
+type Rule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// DefaultRules remove bracketed tags, and release details like resolutions,
+// sources and codecs along with everything after them.
+var DefaultRules = []Rule{
+	{regexp.MustCompile(`\[[^\]]*\]|【[^】]*】`), " "},
+	{regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:2160p|1080[pi]|720p|576p|480p|4k|uhd|hdr|web-?dl|webrip|blu-?ray|bdrip|brrip|hdtv|dvdrip|remux|x\.?26[45]|h\.?26[45]|hevc|avc|aac|ac3|dts|10bit|proper|repack)(?:[^a-z0-9].*)?$`), ""},
+}
+
+// A year at the end of a title, like "2019" or "(2019)".
+var yearRE = regexp.MustCompile(`(?:^|\s)[(（\[]?((?:19|20)\d\d)[)）\]]?$`)
+
+// ParseRules parses rules, one to a line, each a regular expression followed
+// by a tab and its replacement if it isn't empty. Blank lines and those
+// starting with # are skipped.
+func ParseRules(text string) (rules []Rule, err error) {
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		expr, repl, _ := strings.Cut(line, "\t")
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		rules = append(rules, Rule{re, repl})
+	}
+	return
+}
+
+// Clean makes a title of a name by applying the rules in turn, skipping any
+// that would leave nothing, then separating words with spaces rather than
+// dots and underscores. A year the title ends with is taken off and returned
+// if it's in brackets, or release details after it were removed, so that a
+// name like "Blade Runner 2049" keeps its number. The year is never the
+// whole title.
+func Clean(name string, rules []Rule) (title string, year int) {
+	title = name
+	for _, r := range rules {
+		if s := r.Pattern.ReplaceAllString(title, r.Replacement); strings.TrimSpace(s) != "" {
+			title = s
+		}
+	}
+	title = strings.Join(strings.Fields(spaceWords(title)), " ")
+	title = strings.Trim(title, " -–:")
+	m := yearRE.FindStringSubmatchIndex(title)
+	if m == nil {
+		return
+	}
+	// Followed by a closing bracket.
+	bracketed := m[3] < m[1]
+	plain := strings.Trim(strings.Join(strings.Fields(spaceWords(name)), " "), " -–:")
+	if bracketed || !strings.HasSuffix(plain, title[m[2]:m[3]]) {
+		title, year = TrimYear(title)
+	}
+	return
+}
+
+// TrimYear takes a year, bracketed or not, off the end of a title and returns
+// it, unless it's the whole title.
+func TrimYear(title string) (string, int) {
+	m := yearRE.FindStringSubmatchIndex(title)
+	if m == nil || m[0] == 0 {
+		return title, 0
+	}
+	year, _ := strconv.Atoi(title[m[2]:m[3]])
+	return strings.TrimRight(title[:m[0]], " -–:"), year
+}
+
+// Replaces underscores, and dots that aren't followed by spaces, with spaces.
+func spaceWords(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c == '_' || c == '.' && (i+1 == len(b) || b[i+1] != ' ') {
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
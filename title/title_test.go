@@ -0,0 +1,56 @@
+package title
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		title string
+		year  int
+	}{
+		{"[字幕组] Movie.Name.2019.BluRay.x264-GRP", "Movie Name", 2019},
+		{"Movie_Name_(1999)_1080p", "Movie Name", 1999},
+		{"流浪地球2.2023.4K.HDR", "流浪地球2", 2023},
+		{"【高清】长安三万里 (2023)", "长安三万里", 2023},
+		{"Mr. Robot", "Mr. Robot", 0},
+		{"1917", "1917", 0},
+		{"Blade Runner 2049", "Blade Runner 2049", 0},
+		{"Blade.Runner.2049.2017.1080p.BluRay", "Blade Runner 2049", 2017},
+		{"[字幕组] Movie 2019", "Movie 2019", 0},
+		{"[Only Tags]", "[Only Tags]", 0},
+		{"1080p", "1080p", 0},
+		{"Home Videos", "Home Videos", 0},
+	} {
+		title, year := Clean(tc.name, DefaultRules)
+		if title != tc.title || year != tc.year {
+			t.Errorf("%q: %q, %d", tc.name, title, year)
+		}
+	}
+}
+
+func TestTrimYear(t *testing.T) {
+	for name, want := range map[string]string{"Show Name 2019": "Show Name", "Show (2019)": "Show", "1917": "1917", "Show": "Show"} {
+		if got, _ := TrimYear(name); got != want {
+			t.Errorf("%q: %q", name, got)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("# Chinese subtitle tags\n(?i)中字|双语\n\n^Copy of (.*)\t$1\r\n")
+	if err != nil || len(rules) != 2 {
+		t.Fatal(rules, err)
+	}
+	if title, _ := Clean("Copy of Movie.中字", rules); title != "Movie" {
+		t.Fatal(title)
+	}
+	if _, err := ParseRules("ok\n(bad"); err == nil {
+		t.Fatal("bad rule parsed")
+	}
+	if rules[0].Pattern.String() != regexp.MustCompile(`(?i)中字|双语`).String() {
+		t.Fatal(rules[0].Pattern)
+	}
+}
@@ -0,0 +1,202 @@
+// Package transcode converts media streams by running an external command,
+// normally ffmpeg, that reads the source on its standard input and writes the
+// result to its standard output.
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Placeholders replaced in command arguments.
+const (
+	// The position to start from, in seconds.
+	StartArg = "{start}"
+	// How much to transcode, in seconds. When transcoding to the end, an
+	// argument holding it is removed along with the option before it.
+	LengthArg = "{length}"
+	// The URL of the source. Commands without it read the source from their
+	// standard input instead, and can't skip to the start without reading
+	// everything before it.
+	InputArg = "{input}"
+)
+
+// DefaultCommand transcodes to H.264 and AAC in MPEG-TS, which nearly every
+// renderer can play.
+var DefaultCommand = []string{
+	"ffmpeg", "-hide_banner", "-loglevel", "error", "-nostdin",
+	"-ss", StartArg, "-i", InputArg, "-t", LengthArg,
+	"-map", "0:v:0", "-map", "0:a:0?",
+	"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main", "-pix_fmt", "yuv420p",
+	"-c:a", "aac", "-ac", "2", "-b:a", "192k",
+	"-output_ts_offset", StartArg,
+	"-f", "mpegts", "pipe:1",
+}
+
+// Job is a source and the part of it to transcode.
+type Job struct {
+	// Opens the source for commands that read their standard input.
+	Input func() (io.ReadCloser, error)
+	// Where commands that take an InputArg read the source.
+	URL   string
+	Start time.Duration
+	// Zero to transcode to the end.
+	Length time.Duration
+}
+
+// How much of the command's standard error is kept for error messages.
+const maxStderr = 4 << 10
+
+// Transcoder starts transcoding commands, limiting how many run at once.
+type Transcoder struct {
+	command []string
+	slots   chan struct{}
+}
+
+// New returns a Transcoder that runs command, allowing up to maxConcurrent
+// at once.
+func New(command []string, maxConcurrent int) *Transcoder {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &Transcoder{
+		command: command,
+		slots:   make(chan struct{}, maxConcurrent),
+	}
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// Returns the command arguments for the job, and whether it reads the source
+// from standard input.
+func (t *Transcoder) args(job Job) (args []string, stdin bool) {
+	stdin = true
+	for i, arg := range t.command {
+		if strings.Contains(arg, LengthArg) && job.Length == 0 {
+			if i > 0 && len(args) > 0 && strings.HasPrefix(t.command[i-1], "-") {
+				args = args[:len(args)-1]
+			}
+			continue
+		}
+		if strings.Contains(arg, InputArg) {
+			stdin = false
+		}
+		arg = strings.ReplaceAll(arg, StartArg, seconds(job.Start))
+		arg = strings.ReplaceAll(arg, LengthArg, seconds(job.Length))
+		args = append(args, strings.ReplaceAll(arg, InputArg, job.URL))
+	}
+	return
+}
+
+// Start transcodes the job, waiting for a free slot until ctx is done. The
+// command is killed when ctx is done or the output is closed.
+func (t *Transcoder) Start(ctx context.Context, job Job) (io.ReadCloser, error) {
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	args, useStdin := t.args(job)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	s := &stream{cmd: cmd, cancel: cancel, release: func() { <-t.slots }}
+	cmd.Stderr = &s.stderr
+	var input io.ReadCloser
+	var stdin io.WriteCloser
+	var err error
+	if useStdin {
+		if input, err = job.Input(); err == nil {
+			stdin, err = cmd.StdinPipe()
+		}
+	}
+	if err == nil {
+		s.stdout, err = cmd.StdoutPipe()
+	}
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		if input != nil {
+			input.Close()
+		}
+		cancel()
+		s.release()
+		return nil, err
+	}
+	if input != nil {
+		go func() {
+			defer input.Close()
+			io.Copy(stdin, input)
+			stdin.Close()
+		}()
+	}
+	return s, nil
+}
+
+// The output of a running command.
+type stream struct {
+	cmd     *exec.Cmd
+	stdout  io.ReadCloser
+	stderr  limitedBuffer
+	cancel  context.CancelFunc
+	release func()
+	once    sync.Once
+	err     error
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	n, err := s.stdout.Read(p)
+	if err == io.EOF {
+		if werr := s.wait(); werr != nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// Waits for the command to exit, and frees its slot.
+func (s *stream) wait() error {
+	s.once.Do(func() {
+		err := s.cmd.Wait()
+		s.cancel()
+		s.release()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			err = fmt.Errorf("%s: %w: %s", s.cmd.Path, err, bytes.TrimSpace(s.stderr.Bytes()))
+		}
+		s.err = err
+	})
+	return s.err
+}
+
+// Close kills the command if it's still running.
+func (s *stream) Close() error {
+	s.cancel()
+	s.wait()
+	return nil
+}
+
+// Keeps the start of what's written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := maxStderr - b.Len(); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		b.Buffer.Write(p[:n])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,75 @@
+package transcode
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func input(s string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(s)), nil
+	}
+}
+
+func TestArgs(t *testing.T) {
+	tr := New([]string{"ffmpeg", "-ss", StartArg, "-i", InputArg, "-t", LengthArg, "-f", "mpegts", "pipe:1"}, 1)
+	args, stdin := tr.args(Job{URL: "http://host/res", Start: 90 * time.Second, Length: 6 * time.Second})
+	if stdin || strings.Join(args, " ") != "ffmpeg -ss 90.000 -i http://host/res -t 6.000 -f mpegts pipe:1" {
+		t.Fatal(args, stdin)
+	}
+	args, _ = tr.args(Job{URL: "http://host/res"})
+	if strings.Join(args, " ") != "ffmpeg -ss 0.000 -i http://host/res -f mpegts pipe:1" {
+		t.Fatal(args)
+	}
+}
+
+func TestStart(t *testing.T) {
+	tr := New([]string{"sh", "-c", `echo "start=$0"; tr a-z A-Z`, StartArg}, 1)
+	out, err := tr.Start(context.Background(), Job{Input: input("hello"), Start: 1500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(out)
+	out.Close()
+	if err != nil || string(b) != "start=1.500\nHELLO" {
+		t.Fatalf("%q %v", b, err)
+	}
+}
+
+func TestCommandFailure(t *testing.T) {
+	tr := New([]string{"sh", "-c", "echo bad input >&2; exit 3"}, 1)
+	out, err := tr.Start(context.Background(), Job{Input: input("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := io.ReadAll(out); err == nil || !strings.Contains(err.Error(), "bad input") {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrencyAndClose(t *testing.T) {
+	tr := New([]string{"sh", "-c", "exec sleep 10"}, 1)
+	first, err := tr.Start(context.Background(), Job{Input: input("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := tr.Start(ctx, Job{Input: input("")}); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	closed := time.Now()
+	first.Close()
+	if time.Since(closed) > 5*time.Second {
+		t.Fatal("close didn't kill the command")
+	}
+	second, err := tr.Start(context.Background(), Job{Input: input("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Close()
+}
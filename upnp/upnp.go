@@ -114,6 +114,7 @@ func (e *Error) Error() string {
 
 const (
 	InvalidActionErrorCode        = 401
+	InvalidArgsErrorCode          = 402
 	ActionFailedErrorCode         = 501
 	ArgumentValueInvalidErrorCode = 600
 )
@@ -8,6 +8,12 @@ import (
 const (
 	// NoSuchObjectErrorCode : The specified ObjectID is invalid.
 	NoSuchObjectErrorCode = 701
+	// InvalidSearchCriteriaErrorCode : The search criteria specified is not
+	// supported or is invalid.
+	InvalidSearchCriteriaErrorCode = 708
+	// NoSuchContainerErrorCode : The specified ContainerID is invalid or
+	// identifies an object that is not a container.
+	NoSuchContainerErrorCode = 710
 )
 
 // Resource description
@@ -24,33 +30,59 @@ type Resource struct {
 // Container description
 type Container struct {
 	Object
-	XMLName    xml.Name `xml:"container"`
-	ChildCount int      `xml:"childCount,attr"`
+	XMLName xml.Name `xml:"container"`
+	// Nil if it's not known.
+	ChildCount *int `xml:"childCount,attr,omitempty"`
+	// Like a playlist of the container's items.
+	Res []Resource
+}
+
+// CaptionInfo references a subtitle file, as understood by Samsung renderers
+type CaptionInfo struct {
+	XMLName xml.Name `xml:"sec:CaptionInfoEx"`
+	Type    string   `xml:"sec:type,attr"`
+	URL     string   `xml:",chardata"`
 }
 
 // Item description
 type Item struct {
 	Object
-	XMLName  xml.Name `xml:"item"`
-	Res      []Resource
+	XMLName     xml.Name `xml:"item"`
+	Res         []Resource
+	CaptionInfo []CaptionInfo
+	// Set for episodes of TV series.
+	SeriesTitle   string `xml:"upnp:seriesTitle,omitempty"`
+	EpisodeSeason int    `xml:"upnp:episodeSeason,omitempty"`
+	EpisodeNumber int    `xml:"upnp:episodeNumber,omitempty"`
+	// Samsung bookmark information, like "CREATIONDATE=0,FOLDER=TV,BM=42".
+	DcmInfo  string `xml:"sec:dcmInfo,omitempty"`
 	InnerXML string `xml:",innerxml"`
 }
 
 // Object description
 type Object struct {
-	ID          string    `xml:"id,attr"`
-	ParentID    string    `xml:"parentID,attr"`
-	Restricted  int       `xml:"restricted,attr"` // indicates whether the object is modifiable
-	Title       string    `xml:"dc:title"`
-	Class       string    `xml:"upnp:class"`
-	Icon        string    `xml:"upnp:icon,omitempty"`
-	Date        Timestamp `xml:"dc:date"`
-	Artist      string    `xml:"upnp:artist,omitempty"`
-	Album       string    `xml:"upnp:album,omitempty"`
-	Genre       string    `xml:"upnp:genre,omitempty"`
-	AlbumArtURI string    `xml:"upnp:albumArtURI,omitempty"`
-	Searchable  int       `xml:"searchable,attr"`
-	SearchXML   string    `xml:",innerxml"`
+	ID          string       `xml:"id,attr"`
+	ParentID    string       `xml:"parentID,attr"`
+	Restricted  int          `xml:"restricted,attr"` // indicates whether the object is modifiable
+	Title       string       `xml:"dc:title"`
+	Class       string       `xml:"upnp:class"`
+	Icon        string       `xml:"upnp:icon,omitempty"`
+	Date        Timestamp    `xml:"dc:date"`
+	Description string       `xml:"dc:description,omitempty"`
+	Artist      string       `xml:"upnp:artist,omitempty"`
+	Album       string       `xml:"upnp:album,omitempty"`
+	Genre       string       `xml:"upnp:genre,omitempty"`
+	Actors      []string     `xml:"upnp:actor,omitempty"`
+	Rating      string       `xml:"upnp:rating,omitempty"`
+	AlbumArtURI *AlbumArtURI `xml:"upnp:albumArtURI,omitempty"`
+	Searchable  int          `xml:"searchable,attr,omitempty"` // only set for containers
+	SearchXML   string       `xml:",innerxml"`
+}
+
+// AlbumArtURI description
+type AlbumArtURI struct {
+	ProfileID string `xml:"dlna:profileID,attr,omitempty"`
+	URI       string `xml:",chardata"`
 }
 
 // Timestamp wraps time.Time for formatting purposes